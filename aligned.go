@@ -0,0 +1,61 @@
+package terminal
+
+import "strings"
+
+// AsAlignedText renders the screen as plain text like AsPlainText, but so that
+// every column of the screen is one column of text (two for wide characters)
+// and the columns line up in a fixed-width font, however tabs are displayed:
+// tabs are expanded to spaces, and images and other elements are left as a
+// space.
+//
+// If maxWidth is positive, lines are cut to at most maxWidth columns, for
+// fixed-width exports. Wide characters count as 2 columns and are never
+// split, so a line may be cut a column short.
+func (s *Screen) AsAlignedText(maxWidth int) string {
+	lines := make([]string, 0, len(s.screen))
+
+	for _, line := range s.screen[s.firstRenderedLine(false):] {
+		lines = append(lines, line.asAligned(&s.render, maxWidth))
+	}
+
+	return s.render.joinLines(lines)
+}
+
+// asAligned returns the line contents for AsAlignedText, cut to maxWidth
+// columns if it is positive.
+func (l *screenLine) asAligned(opts *renderOptions, maxWidth int) string {
+	nodes := l.contentNodes(opts)
+	if maxWidth > 0 && maxWidth < len(nodes) {
+		// Each node is a column. If the first node cut off continues a
+		// character, leave out the whole character.
+		end := maxWidth
+		for end > 0 && nodes[end].style.cont() {
+			end--
+		}
+		nodes = nodes[:end]
+	}
+
+	var buf strings.Builder
+	tab := false // whether continuation nodes are the rest of a tab
+	for _, n := range nodes {
+		switch {
+		case n.style.cont():
+			if tab {
+				buf.WriteByte(' ')
+			}
+		case n.style.element():
+			buf.WriteByte(' ')
+		case !n.style.cluster() && n.blob == '\t':
+			buf.WriteByte(' ')
+			tab = true
+			continue
+		default:
+			l.writePlainNode(&buf, n, opts)
+		}
+		if !n.style.cont() {
+			tab = false
+		}
+	}
+
+	return strings.TrimRight(buf.String(), " ")
+}
@@ -0,0 +1,70 @@
+package terminal
+
+import "testing"
+
+func TestAsAlignedText(t *testing.T) {
+	tests := []struct {
+		name, input string
+		maxWidth    int
+		want        string
+	}{
+		{
+			name:  "tabs are expanded",
+			input: "a\tb\nabcdefghi\tj",
+			want:  "a       b\nabcdefghi       j",
+		},
+		{
+			name:  "wide characters",
+			input: "漢字ab\nabcdef",
+			want:  "漢字ab\nabcdef",
+		},
+		{
+			name:     "cut at the width",
+			input:    "0123456789\n012",
+			maxWidth: 5,
+			want:     "01234\n012",
+		},
+		{
+			name:     "cut after a wide character",
+			input:    "a漢字b",
+			maxWidth: 5,
+			want:     "a漢字",
+		},
+		{
+			name:     "cut in the middle of a wide character",
+			input:    "a漢字b\nab漢字",
+			maxWidth: 4,
+			want:     "a漢\nab漢",
+		},
+		{
+			name:     "cut in the middle of a tab",
+			input:    "a\tb",
+			maxWidth: 4,
+			want:     "a",
+		},
+		{
+			name:     "cut in the middle of a grapheme cluster",
+			input:    "ab👩‍💻c",
+			maxWidth: 3,
+			want:     "ab",
+		},
+		{
+			name:  "styles are dropped",
+			input: "\x1b[31mred\x1b[0m\tplain",
+			want:  "red     plain",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsAlignedText(test.maxWidth); got != test.want {
+				t.Errorf("AsAlignedText(%d) = %q, want %q", test.maxWidth, got, test.want)
+			}
+		})
+	}
+}
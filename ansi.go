@@ -0,0 +1,168 @@
+package terminal
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AsANSI returns the contents of the screen buffer as text with ANSI escape
+// sequences, which when written to a new Screen, reproduces these contents.
+// Styles are written as SGR sequences, but only the changes between one run
+// of text and the next are written, so redundant sequences in the original
+// input are not reproduced. OSC 8 hyperlinks are kept. Elements (such as
+// inline images) and line metadata are left out.
+func (s *Screen) AsANSI() string {
+	return s.AsANSIDepth(ColorDepthTrueColor)
+}
+
+// AsANSIDepth is like AsANSI, but only uses the colours available at the given
+// colour depth, for terminals with less colour support. Colours that aren't
+// available are replaced with the nearest one that is (using the default xterm
+// palette).
+func (s *Screen) AsANSIDepth(depth ColorDepth) string {
+	var b strings.Builder
+	var current style
+	var url string
+
+	for i := range s.screen {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		line := &s.screen[i]
+		for x, n := range line.nodes {
+			if n.style.element() || n.style.cont() {
+				continue
+			}
+
+			linkURL := ""
+			if n.style.hyperlink() {
+				linkURL = line.hyperlinks[x]
+			}
+			if linkURL != url {
+				b.WriteString("\x1b]8;;" + linkURL + "\x1b\\")
+				url = linkURL
+			}
+
+			if st := n.style.downsample(depth); st.visual() != current.visual() {
+				b.WriteString(sgrTransition(current, st))
+				current = st
+			}
+
+			b.WriteString(line.text(n))
+		}
+	}
+
+	if url != "" {
+		b.WriteString("\x1b]8;;\x1b\\")
+	}
+	if current.visual() != (style{}) {
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
+
+// sgrTransition returns an SGR sequence that changes the style from "from" to
+// "to". It is the shorter of a sequence setting only what changed, and one
+// that resets the style and sets it again from scratch. Unknown SGR
+// parameters can only be turned off by resetting.
+func sgrTransition(from, to style) string {
+	delta := sgrParams(from.visual(), to.visual())
+	full := append([]string{"0"}, sgrParams(style{}, to.visual())...)
+	if len(strings.Join(full, ";")) < len(strings.Join(delta, ";")) || !unknownKept(from, to) {
+		delta = full
+	}
+	if len(delta) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(delta, ";") + "m"
+}
+
+// sgrParams returns the SGR parameters that change the style from "from" to
+// "to", without resetting it.
+func sgrParams(from, to style) []string {
+	var params []string
+
+	// Bold and faint can't both be set: 1 turns off faint, 2 turns off bold,
+	// and 22 turns off both.
+	if (from.bold() || from.faint()) && !to.bold() && !to.faint() {
+		params = append(params, "22")
+	}
+	if to.bold() && !from.bold() {
+		params = append(params, "1")
+	}
+	if to.faint() && !from.faint() {
+		params = append(params, "2")
+	}
+
+	attrs := []struct {
+		get     func(style) bool
+		on, off string
+	}{
+		{style.italic, "3", "23"},
+		{style.underline, "4", "24"},
+		{style.blink, "5", "25"},
+		{style.reverse, "7", "27"},
+		{style.conceal, "8", "28"},
+		{style.strike, "9", "29"},
+		{style.framed, "51", "54"},
+		{style.overline, "53", "55"},
+	}
+	for _, a := range attrs {
+		switch was, is := a.get(from), a.get(to); {
+		case is && !was:
+			params = append(params, a.on)
+		case was && !is:
+			params = append(params, a.off)
+		}
+	}
+
+	if from.fg != to.fg {
+		params = append(params, to.fg.sgrParams(false)...)
+	}
+	if from.bg != to.bg {
+		params = append(params, to.bg.sgrParams(true)...)
+	}
+
+	for _, p := range to.unknownParams() {
+		if !slices.Contains(from.unknownParams(), p) {
+			params = append(params, strconv.Itoa(int(p)))
+		}
+	}
+	return params
+}
+
+// unknownKept reports if all the unknown SGR parameters of "from" are also
+// set in "to".
+func unknownKept(from, to style) bool {
+	for _, p := range from.unknownParams() {
+		if !slices.Contains(to.unknownParams(), p) {
+			return false
+		}
+	}
+	return true
+}
+
+// sgrParams returns the SGR parameters that set the colour, as a background
+// colour if bg is true, or a foreground colour otherwise.
+func (c color) sgrParams(bg bool) []string {
+	base := 38
+	if bg {
+		base = 48
+	}
+	switch c.mode() {
+	case colorModeBasic:
+		return []string{strconv.Itoa(int(c.value()))}
+	case colorMode256:
+		return []string{strconv.Itoa(base), "5", strconv.Itoa(int(c.value()))}
+	case colorModeRGB:
+		v := c.value()
+		return []string{
+			strconv.Itoa(base), "2",
+			strconv.Itoa(int(v >> 16 & 0xff)),
+			strconv.Itoa(int(v >> 8 & 0xff)),
+			strconv.Itoa(int(v & 0xff)),
+		}
+	}
+	return []string{strconv.Itoa(base + 1)}
+}
@@ -0,0 +1,284 @@
+package terminal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAsANSI(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "plain text",
+			input: "hello\nworld",
+			want:  "hello\nworld",
+		},
+		{
+			name:  "redundant SGR",
+			input: "\x1b[31m\x1b[31mred\x1b[31m red\x1b[0m",
+			want:  "\x1b[31mred red\x1b[0m",
+		},
+		{
+			name:  "only changes are written",
+			input: "\x1b[1;31ma\x1b[4mb\x1b[24;32mc",
+			want:  "\x1b[1;31ma\x1b[4mb\x1b[24;32mc\x1b[0m",
+		},
+		{
+			name:  "bold and faint",
+			input: "\x1b[1mbold\x1b[22;2mfaint\x1b[2;3mitalic\x1b[22mplain italic",
+			want:  "\x1b[1mbold\x1b[2mfaint\x1b[3mitalic\x1b[22mplain italic\x1b[0m",
+		},
+		{
+			name:  "faint to bold",
+			input: "\x1b[2;4mfaint\x1b[22;1mbold",
+			want:  "\x1b[2;4mfaint\x1b[1mbold\x1b[0m",
+		},
+		{
+			name:  "reset when shorter",
+			input: "\x1b[1;3;4;9;31;42mall\x1b[0;33myellow",
+			want:  "\x1b[1;3;4;9;31;42mall\x1b[0;33myellow\x1b[0m",
+		},
+		{
+			name:  "style carries across lines",
+			input: "\x1b[32mone\ntwo\x1b[0m",
+			want:  "\x1b[32mone\ntwo\x1b[0m",
+		},
+		{
+			name:  "palette and 24-bit colours",
+			input: "\x1b[38;5;200;48;2;1;2;3mx\x1b[39;49my",
+			want:  "\x1b[38;5;200;48;2;1;2;3mx\x1b[0my",
+		},
+		{
+			name:  "hyperlinks",
+			input: "\x1b]8;;http://example.com\x07link\x1b]8;;\x07 text",
+			want:  "\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\ text",
+		},
+		{
+			name:  "wide characters and clusters",
+			input: "漢字 é",
+			want:  "漢字 é",
+		},
+		{
+			name:  "empty cells",
+			input: "a\x1b[2Cb",
+			want:  "a  b",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if got := s.AsANSI(); got != test.want {
+				t.Errorf("AsANSI() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAsANSIRoundTrip(t *testing.T) {
+	inputs := []string{
+		"\x1b[1;31mbold red\x1b[22m red\x1b[0m plain\n\x1b[7;4mreverse underline\x1b[27m underline",
+		"\x1b[2mfaint\x1b[1m bold\x1b[2m faint\x1b[0m",
+		"\x1b[38;5;123mpalette\x1b[48;2;10;20;30m rgb\x1b[49m\x1b[39m default",
+		"\x1b[3;9;53;51mmany\x1b[23m\x1b[29m\x1b[55mfewer\x1b[54m",
+		"\x1b]8;;http://example.com/a\x07a\x1b]8;;http://example.com/b\x07b\x1b]8;;\x07\nnext line",
+		"progress 10%\rprogress 100%\n\x1b[32m✔\x1b[0m done",
+		"漢字\x1b[41m 👩‍💻 \x1b[0m\tafter tab",
+	}
+
+	for _, input := range inputs {
+		s := parsedScreen(t, input)
+		ansi := s.AsANSI()
+		again := parsedScreen(t, ansi)
+		if got, want := again.AsHTML(), s.AsHTML(); got != want {
+			t.Errorf("input %q: AsHTML() after round trip via %q = %q, want %q", input, ansi, got, want)
+		}
+		if got := again.AsANSI(); got != ansi {
+			t.Errorf("input %q: AsANSI() after round trip = %q, want %q", input, got, ansi)
+		}
+	}
+}
+
+func TestAsANSIDepth(t *testing.T) {
+	tests := []struct {
+		name, input string
+		depth       ColorDepth
+		want        string
+	}{
+		{
+			name:  "truecolor unchanged",
+			input: "\x1b[38;2;255;135;0mo\x1b[48;5;21mb",
+			depth: ColorDepthTrueColor,
+			want:  "\x1b[38;2;255;135;0mo\x1b[48;5;21mb\x1b[0m",
+		},
+		{
+			name:  "rgb to 256",
+			input: "\x1b[38;2;255;135;0mo\x1b[48;2;0;0;255mb",
+			depth: ColorDepth256,
+			want:  "\x1b[38;5;208mo\x1b[48;5;21mb\x1b[0m",
+		},
+		{
+			name:  "rgb to 16",
+			input: "\x1b[38;2;250;10;10mr\x1b[48;2;0;0;100mb",
+			depth: ColorDepth16,
+			want:  "\x1b[91mr\x1b[40mb\x1b[0m",
+		},
+		{
+			name:  "256 to 16",
+			input: "\x1b[38;5;46mg\x1b[48;5;226my",
+			depth: ColorDepth16,
+			want:  "\x1b[92mg\x1b[103my\x1b[0m",
+		},
+		{
+			name:  "basic colours unchanged",
+			input: "\x1b[31;44mr\x1b[93mb",
+			depth: ColorDepth16,
+			want:  "\x1b[31;44mr\x1b[93mb\x1b[0m",
+		},
+		{
+			name:  "colours that become the same are merged",
+			input: "\x1b[38;2;255;0;0ma\x1b[38;2;254;1;1mb",
+			depth: ColorDepth16,
+			want:  "\x1b[91mab\x1b[0m",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if got := s.AsANSIDepth(test.depth); got != test.want {
+				t.Errorf("AsANSIDepth(%d) = %q, want %q", test.depth, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAsANSIDepth16OnlyBasicCodes(t *testing.T) {
+	var input strings.Builder
+	for r := 0; r < 256; r += 15 {
+		for g := 0; g < 256; g += 51 {
+			fmt.Fprintf(&input, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dmx", r, g, 255-r, g, r, 128)
+		}
+	}
+	for idx := range 256 {
+		fmt.Fprintf(&input, "\x1b[38;5;%d;48;5;%dmy", idx, 255-idx)
+	}
+
+	s := parsedScreen(t, input.String())
+	ansi := s.AsANSIDepth(ColorDepth16)
+	for _, m := range regexp.MustCompile(`\x1b\[([0-9;]*)m`).FindAllStringSubmatch(ansi, -1) {
+		for _, p := range strings.Split(m[1], ";") {
+			code, err := strconv.Atoi(p)
+			if err != nil {
+				t.Fatalf("AsANSIDepth(ColorDepth16) wrote SGR %q, with a non-numeric parameter", m[0])
+			}
+			switch {
+			case code >= 30 && code <= 37, code >= 90 && code <= 97:
+			case code >= 40 && code <= 47, code >= 100 && code <= 107:
+			case code == 0:
+			default:
+				t.Errorf("AsANSIDepth(ColorDepth16) wrote SGR %q, with code %d", m[0], code)
+			}
+		}
+	}
+}
+
+func TestAsANSIUnknownSGR(t *testing.T) {
+	tests := []struct {
+		name, input string
+		enabled     bool
+		want        string
+	}{
+		{
+			name:  "dropped by default",
+			input: "\x1b[11;31mfont\x1b[0m",
+			want:  "\x1b[31mfont\x1b[0m",
+		},
+		{
+			name:    "kept when enabled",
+			input:   "\x1b[11;31mfont\x1b[0m plain",
+			enabled: true,
+			want:    "\x1b[31;11mfont\x1b[0m plain",
+		},
+		{
+			name:    "added to",
+			input:   "\x1b[11ma\x1b[73mb\x1b[11mc",
+			enabled: true,
+			want:    "\x1b[11ma\x1b[73mbc\x1b[0m",
+		},
+		{
+			name:    "removed by resetting",
+			input:   "\x1b[1;11ma\x1b[0;1mb",
+			enabled: true,
+			want:    "\x1b[1;11ma\x1b[0;1mb\x1b[0m",
+		},
+		{
+			name:    "limited",
+			input:   "\x1b[10;11;12;13;14;15mx",
+			enabled: true,
+			want:    "\x1b[10;11;12;13mx\x1b[0m",
+		},
+		{
+			name:    "underline colours are dropped whole",
+			input:   "\x1b[58;5;196;31ma\x1b[58;2;1;2;3;4mb",
+			enabled: true,
+			want:    "\x1b[31ma\x1b[4mb\x1b[0m",
+		},
+		{
+			name:    "underline colours are dropped when disabled",
+			input:   "\x1b[58;5;196ma",
+			enabled: false,
+			want:    "a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithUnknownSGR(test.enabled))
+			if err != nil {
+				t.Fatalf("NewScreen(WithUnknownSGR(%t)) error = %v", test.enabled, err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsANSI(); got != test.want {
+				t.Errorf("AsANSI() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAsANSIUnknownSGRRoundTrip(t *testing.T) {
+	input := "\x1b[1;11mfont\x1b[22m still font\x1b[0m plain \x1b[73;32msuperscript \x1b[58;5;196mcoloured underline"
+
+	s, err := NewScreen(WithUnknownSGR(true))
+	if err != nil {
+		t.Fatalf("NewScreen(WithUnknownSGR(true)) error = %v", err)
+	}
+	s.Write([]byte(input))
+	ansi := s.AsANSI()
+
+	again, err := NewScreen(WithUnknownSGR(true))
+	if err != nil {
+		t.Fatalf("NewScreen(WithUnknownSGR(true)) error = %v", err)
+	}
+	again.Write([]byte(ansi))
+	if got := again.AsANSI(); got != ansi {
+		t.Errorf("AsANSI() after round trip = %q, want %q", got, ansi)
+	}
+	for _, p := range []string{"11", "73"} {
+		if !regexp.MustCompile(`\x1b\[([0-9]+;)*` + p + `[;m]`).MatchString(ansi) {
+			t.Errorf("AsANSI() = %q, want it to contain SGR parameter %s", ansi, p)
+		}
+	}
+	for _, p := range []string{"5", "58", "196"} {
+		if regexp.MustCompile(`\x1b\[([0-9]+;)*` + p + `[;m]`).MatchString(ansi) {
+			t.Errorf("AsANSI() = %q, want no SGR parameter %s (from the underline colour)", ansi, p)
+		}
+	}
+	if got, want := again.AsHTML(), parsedScreen(t, input).AsHTML(); got != want {
+		t.Errorf("AsHTML() after round trip = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package terminal
+
+import (
+	"regexp"
+	"slices"
+)
+
+// defaultAutoLinkPattern matches http and https URLs, excluding any trailing
+// punctuation (which is more likely to be part of the surrounding sentence).
+var defaultAutoLinkPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]*[^\s<>"'` + "`" + `.,;:!?)\]}]`)
+
+// WithAutoLink enables or disables automatic linking. When enabled, URLs
+// found in the text of each line are rendered as links in HTML output, as
+// though they were OSC 8 links. URLs that overlap an OSC 8 link are left
+// alone. By default only http and https URLs are found; use
+// WithAutoLinkPattern to change this.
+func WithAutoLink(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.autoLink = enabled
+		return nil
+	}
+}
+
+// WithAutoLinkPattern sets the regular expression used to find URLs for
+// automatic linking (see WithAutoLink). Each match is used as the link
+// target, and is matched against the plain text of each line.
+func WithAutoLinkPattern(pattern *regexp.Regexp) ScreenOption {
+	return func(s *Screen) error {
+		s.render.autoLinkPattern = pattern
+		return nil
+	}
+}
+
+// autoLink is a URL found in the text of a line, covering the nodes from
+// start up to (but excluding) end.
+type autoLink struct {
+	start, end int
+	url        string
+}
+
+// autoLinks finds the URLs in the line for automatic linking, if enabled.
+func (l *screenLine) autoLinks(opts *renderOptions) []autoLink {
+	if !opts.autoLink || len(l.nodes) == 0 {
+		return nil
+	}
+	pattern := opts.autoLinkPattern
+	if pattern == nil {
+		pattern = defaultAutoLinkPattern
+	}
+
+	// Matches are byte offsets into the text, which the offsets map back
+	// to nodes (each node may be any number of bytes, or none).
+	text, offsets := l.plainWithOffsets()
+	var links []autoLink
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		start, end := offsets[m[0]], offsets[m[1]-1]+1
+		if slices.ContainsFunc(l.nodes[start:end], func(n node) bool { return n.style.hyperlink() }) {
+			continue
+		}
+		links = append(links, autoLink{start: start, end: end, url: text[m[0]:m[1]]})
+	}
+	return links
+}
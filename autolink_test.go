@@ -0,0 +1,72 @@
+package terminal
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAutoLink(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []ScreenOption
+		input string
+		want  string
+	}{
+		{
+			name:  "disabled",
+			input: "see https://example.com/docs for more",
+			want:  "see https:&#47;&#47;example.com&#47;docs for more",
+		},
+		{
+			name:  "bare URL",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			input: "see https://example.com/docs for more",
+			want:  `see <a href="https://example.com/docs">https:&#47;&#47;example.com&#47;docs</a> for more`,
+		},
+		{
+			name:  "trailing punctuation",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			input: "(see http://example.com).",
+			want:  `(see <a href="http://example.com">http:&#47;&#47;example.com</a>).`,
+		},
+		{
+			name:  "styled URL",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			input: "\x1b[4mhttp://a.com\x1b[0m http://b.com",
+			want:  `<a href="http://a.com"><span class="term-fg4">http:&#47;&#47;a.com</span></a> <a href="http://b.com">http:&#47;&#47;b.com</a>`,
+		},
+		{
+			name:  "after wide characters",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			input: "漢字 http://example.com 字",
+			want:  `漢字 <a href="http://example.com">http:&#47;&#47;example.com</a> 字`,
+		},
+		{
+			name:  "overlapping OSC 8 link",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			input: "\x1b]8;;http://other.com\x1b\\http://example\x1b]8;;\x1b\\.com and http://b.com",
+			want:  `<a href="http://other.com">http:&#47;&#47;example</a>.com and <a href="http://b.com">http:&#47;&#47;b.com</a>`,
+		},
+		{
+			name:  "custom pattern",
+			opts:  []ScreenOption{WithAutoLink(true), WithAutoLinkPattern(regexp.MustCompile(`ftp://\S+`))},
+			input: "ftp://example.com http://example.com",
+			want:  `<a href="ftp://example.com">ftp:&#47;&#47;example.com</a> http:&#47;&#47;example.com`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package terminal
+
+import "fmt"
+
+// color is a foreground or background colour. The top byte holds the colour
+// mode, and the lower 24 bits hold a value whose meaning depends on the mode.
+// The zero value is the default colour.
+type color uint32
+
+const (
+	colorModeDefault = iota
+	colorModeBasic   // value is the SGR code, e.g. 31 (red fg) or 101 (bright red bg)
+	colorMode256     // value is an index into the xterm 256-colour palette
+	colorModeRGB     // value is a 24-bit colour, 0xRRGGBB
+)
+
+const defaultColor color = 0
+
+func basicColor(code uint8) color  { return color(colorModeBasic<<24 | uint32(code)) }
+func paletteColor(idx uint8) color { return color(colorMode256<<24 | uint32(idx)) }
+
+func rgbColor(r, g, b uint8) color {
+	return color(colorModeRGB<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b))
+}
+
+func (c color) mode() int     { return int(c >> 24) }
+func (c color) value() uint32 { return uint32(c & 0xff_ff_ff) }
+
+// rgbHex returns the colour as #rrggbb. It is only meaningful for 24-bit
+// colours and palette entries 16-255.
+func (c color) rgbHex() string {
+	if c.mode() == colorMode256 {
+		return fmt.Sprintf("#%06x", xterm256RGB(uint8(c.value())))
+	}
+	return fmt.Sprintf("#%06x", c.value())
+}
+
+// swapLayer converts a basic foreground colour into the same basic colour as a
+// background, and vice versa, since the SGR code differs. Other colours are
+// unchanged.
+func (c color) swapLayer() color {
+	if c.mode() != colorModeBasic {
+		return c
+	}
+	switch code := uint8(c.value()); {
+	case code >= 30 && code <= 37, code >= 90 && code <= 97:
+		return basicColor(code + 10)
+	case code >= 40 && code <= 47, code >= 100 && code <= 107:
+		return basicColor(code - 10)
+	}
+	return c
+}
+
+// paletteIndex returns the index of the colour within the xterm 256-colour
+// palette. Basic colours are mapped to the first 16 entries. It returns false
+// for default and 24-bit colours.
+func (c color) paletteIndex() (uint8, bool) {
+	switch c.mode() {
+	case colorModeBasic:
+		code := uint8(c.value())
+		switch {
+		case code >= 30 && code <= 37:
+			return code - 30, true
+		case code >= 40 && code <= 47:
+			return code - 40, true
+		case code >= 90 && code <= 97:
+			return code - 90 + 8, true
+		case code >= 100 && code <= 107:
+			return code - 100 + 8, true
+		}
+	case colorMode256:
+		return uint8(c.value()), true
+	}
+	return 0, false
+}
+
+// Names of the CSS custom properties used for the base palette when CSS
+// variables are enabled, in palette order.
+var basePaletteVariables = [16]string{
+	"--ansi-black",
+	"--ansi-red",
+	"--ansi-green",
+	"--ansi-yellow",
+	"--ansi-blue",
+	"--ansi-magenta",
+	"--ansi-cyan",
+	"--ansi-white",
+	"--ansi-bright-black",
+	"--ansi-bright-red",
+	"--ansi-bright-green",
+	"--ansi-bright-yellow",
+	"--ansi-bright-blue",
+	"--ansi-bright-magenta",
+	"--ansi-bright-cyan",
+	"--ansi-bright-white",
+}
+
+// xterm256RGB returns the 24-bit value of entries 16-255 of the xterm
+// 256-colour palette: a 6x6x6 colour cube followed by a greyscale ramp.
+func xterm256RGB(idx uint8) uint32 {
+	if idx >= 232 {
+		v := 8 + 10*uint32(idx-232)
+		return v<<16 | v<<8 | v
+	}
+	cube := func(i uint8) uint32 {
+		if i == 0 {
+			return 0
+		}
+		return 55 + 40*uint32(i)
+	}
+	i := idx - 16
+	return cube(i/36)<<16 | cube((i/6)%6)<<8 | cube(i%6)
+}
+
+// xtermBasicRGB is the 24-bit value of the basic colours (entries 0-15 of the
+// palette) in xterm's default palette.
+var xtermBasicRGB = [16]uint32{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+// paletteRGB returns the 24-bit value of an entry of the xterm 256-colour
+// palette, using the default palette for the basic colours.
+func paletteRGB(idx uint8) uint32 {
+	if idx < 16 {
+		return xtermBasicRGB[idx]
+	}
+	return xterm256RGB(idx)
+}
+
+// nearestPaletteIndex returns the index of the palette entry from first to
+// last (inclusive) nearest to the 24-bit colour v.
+func nearestPaletteIndex(v uint32, first, last uint8) uint8 {
+	best, bestDist := first, -1
+	for idx := int(first); idx <= int(last); idx++ {
+		p := paletteRGB(uint8(idx))
+		dr := int(v>>16&0xff) - int(p>>16&0xff)
+		dg := int(v>>8&0xff) - int(p>>8&0xff)
+		db := int(v&0xff) - int(p&0xff)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = uint8(idx), dist
+		}
+	}
+	return best
+}
+
+// ColorDepth is the number of colours available, for AsANSIDepth.
+type ColorDepth int
+
+const (
+	// ColorDepthTrueColor allows all colours, including 24-bit colours.
+	ColorDepthTrueColor ColorDepth = iota
+
+	// ColorDepth256 allows the 256 colours of the xterm palette.
+	ColorDepth256
+
+	// ColorDepth16 allows only the 16 basic colours, as set by SGR 30-37,
+	// 90-97, 40-47 and 100-107.
+	ColorDepth16
+)
+
+// downsample returns the colour, replaced by the nearest available at the
+// colour depth if it isn't available. bg says whether it is a background
+// colour, since basic colours are different codes for the background.
+func (c color) downsample(depth ColorDepth, bg bool) color {
+	switch {
+	case depth == ColorDepth256 && c.mode() == colorModeRGB:
+		// The basic colours vary between terminals, so only the colour cube
+		// and greyscale ramp are used.
+		return paletteColor(nearestPaletteIndex(c.value(), 16, 255))
+
+	case depth == ColorDepth16 && (c.mode() == colorModeRGB || c.mode() == colorMode256):
+		v := c.value()
+		if c.mode() == colorMode256 {
+			v = paletteRGB(uint8(v))
+		}
+		idx := nearestPaletteIndex(v, 0, 15)
+		code := 30 + idx
+		if idx >= 8 {
+			code = 90 + idx - 8
+		}
+		if bg {
+			code += 10
+		}
+		return basicColor(code)
+	}
+	return c
+}
+
+// asCSS returns a CSS colour value for colours that must be rendered inline
+// rather than with a class: 24-bit colours, and, when CSS variables are
+// enabled, all palette colours. Otherwise it returns "".
+func (c color) asCSS(opts *renderOptions) string {
+	switch c.mode() {
+	case colorModeRGB:
+		return c.rgbHex()
+
+	case colorModeBasic, colorMode256:
+		if !opts.cssVariables {
+			return ""
+		}
+		idx, ok := c.paletteIndex()
+		if !ok {
+			return ""
+		}
+		if idx < 16 {
+			return "var(" + basePaletteVariables[idx] + ")"
+		}
+		return paletteColor(idx).rgbHex()
+	}
+	return ""
+}
+
+// ColorMode describes how a Color is specified.
+type ColorMode int
+
+const (
+	// ColorDefault is the terminal's default foreground or background colour.
+	ColorDefault ColorMode = iota
+
+	// ColorPalette is an entry in the xterm 256-colour palette, given by
+	// Index. Entries 0-15 are the basic ANSI colours (including those set
+	// with SGR codes 30-37, 90-97, etc).
+	ColorPalette
+
+	// ColorRGB is a 24-bit colour, given by R, G and B.
+	ColorRGB
+)
+
+// Color is a foreground or background colour.
+type Color struct {
+	Mode    ColorMode
+	Index   uint8
+	R, G, B uint8
+}
+
+// info converts the colour into a Color.
+func (c color) info() Color {
+	if idx, ok := c.paletteIndex(); ok {
+		return Color{Mode: ColorPalette, Index: idx}
+	}
+	if c.mode() == colorModeRGB {
+		v := c.value()
+		return Color{Mode: ColorRGB, R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}
+	}
+	return Color{}
+}
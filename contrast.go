@@ -0,0 +1,150 @@
+package terminal
+
+import (
+	"fmt"
+	"math"
+)
+
+// The default colours of the bundled stylesheet, used to resolve the default
+// foreground and background when adjusting contrast.
+const (
+	defaultForegroundRGB = 0xffffff
+	defaultBackgroundRGB = 0x171717
+)
+
+// WithMinContrast enables adjusting foreground colours in HTML output so that
+// text has at least the given WCAG contrast ratio against its background
+// (between 1 and 21; 4.5 is the WCAG AA level for normal text). Where a colour
+// pair falls short, the foreground is made lighter or darker until it meets
+// the ratio, and rendered as an inline #rrggbb colour. 0, the default,
+// disables the adjustment.
+//
+// Palette colours are resolved using xterm's default palette, and the default
+// colours using those of the bundled stylesheet (white on #171717), so the
+// adjustment is only accurate for stylesheets with similar colours. Cells using
+// the default colours for both the foreground and background are never
+// adjusted.
+func WithMinContrast(ratio float64) ScreenOption {
+	return func(s *Screen) error {
+		if ratio != 0 && (ratio < 1 || ratio > 21) {
+			return fmt.Errorf("invalid contrast ratio %g", ratio)
+		}
+		s.render.minContrast = ratio
+		return nil
+	}
+}
+
+// resolvedRGB returns the 24-bit value of the colour, or def for the default
+// colour.
+func (c color) resolvedRGB(def uint32) uint32 {
+	if idx, ok := c.paletteIndex(); ok {
+		return paletteRGB(idx)
+	}
+	if c.mode() == colorModeRGB {
+		return c.value()
+	}
+	return def
+}
+
+// relativeLuminance returns the WCAG relative luminance of a 24-bit colour.
+func relativeLuminance(v uint32) float64 {
+	channel := func(c uint32) float64 {
+		f := float64(c&0xff) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(v>>16) + 0.7152*channel(v>>8) + 0.0722*channel(v)
+}
+
+// contrastRatio returns the WCAG contrast ratio between two 24-bit colours.
+func contrastRatio(a, b uint32) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	return (max(la, lb) + 0.05) / (min(la, lb) + 0.05)
+}
+
+// contrastForeground returns the foreground colour to render with the
+// background bg, adjusted to meet the minimum contrast ratio if enabled.
+func (s style) contrastForeground(fg, bg color, opts *renderOptions) color {
+	if opts.minContrast == 0 || s.conceal() || (fg == defaultColor && bg == defaultColor) {
+		return fg
+	}
+	fgRGB := fg.resolvedRGB(defaultForegroundRGB)
+	bgRGB := bg.resolvedRGB(defaultBackgroundRGB)
+	if contrastRatio(fgRGB, bgRGB) >= opts.minContrast {
+		return fg
+	}
+
+	// Move away from the background's lightness: lighter on dark
+	// backgrounds, darker on light ones. If even white (or black) isn't
+	// enough, go as far as possible.
+	h, sat, l := rgbToHSL(fgRGB)
+	target, extreme := 1.0, uint32(0xffffff)
+	if contrastRatio(0xffffff, bgRGB) < contrastRatio(0x000000, bgRGB) {
+		target, extreme = 0, 0x000000
+	}
+	if contrastRatio(extreme, bgRGB) < opts.minContrast {
+		return rgbColor(uint8(extreme>>16), uint8(extreme>>8), uint8(extreme))
+	}
+
+	// Binary search for the lightness nearest the original that is enough.
+	near, far := l, target
+	for range 16 {
+		mid := (near + far) / 2
+		if contrastRatio(hslToRGB(h, sat, mid), bgRGB) >= opts.minContrast {
+			far = mid
+		} else {
+			near = mid
+		}
+	}
+	v := hslToRGB(h, sat, far)
+	return rgbColor(uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+// rgbToHSL converts a 24-bit colour to hue (0-6), saturation and lightness
+// (0-1).
+func rgbToHSL(v uint32) (h, s, l float64) {
+	r, g, b := float64(v>>16&0xff)/255, float64(v>>8&0xff)/255, float64(v&0xff)/255
+	hi, lo := max(r, g, b), min(r, g, b)
+	l = (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+	s = d / (1 - math.Abs(2*l-1))
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h, s, l
+}
+
+// hslToRGB converts hue (0-6), saturation and lightness (0-1) to a 24-bit
+// colour.
+func hslToRGB(h, s, l float64) uint32 {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch {
+	case h < 1:
+		r, g = c, x
+	case h < 2:
+		r, g = x, c
+	case h < 3:
+		g, b = c, x
+	case h < 4:
+		g, b = x, c
+	case h < 5:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	byteOf := func(f float64) uint32 { return uint32(math.Round(min(max(f+m, 0), 1) * 255)) }
+	return byteOf(r)<<16 | byteOf(g)<<8 | byteOf(b)
+}
@@ -0,0 +1,80 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithMinContrast(t *testing.T) {
+	tests := []struct {
+		name  string
+		ratio float64
+		input string
+		want  string
+	}{
+		{
+			name:  "low contrast foreground is lightened",
+			ratio: 4.5,
+			input: "\x1b[38;5;238;40mdim\x1b[0m",
+			want:  `<span class="term-bg40" style="color:#757575">dim</span>`,
+		},
+		{
+			name:  "low contrast foreground on a light background is darkened",
+			ratio: 4.5,
+			input: "\x1b[93;107mpale\x1b[0m",
+			want:  `<span class="term-bgi107" style="color:#7a7a00">pale</span>`,
+		},
+		{
+			name:  "default background",
+			ratio: 4.5,
+			input: "\x1b[38;2;40;40;60mnavy\x1b[0m",
+			want:  `<span style="color:#7c7ca7">navy</span>`,
+		},
+		{
+			name:  "high contrast pair is untouched",
+			ratio: 4.5,
+			input: "\x1b[97;40mbright\x1b[0m",
+			want:  `<span class="term-fgi97 term-bg40">bright</span>`,
+		},
+		{
+			name:  "disabled",
+			input: "\x1b[38;5;238;40mdim\x1b[0m",
+			want:  `<span class="term-fgx238 term-bg40">dim</span>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithMinContrast(test.ratio))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestContrastForegroundMeetsRatio(t *testing.T) {
+	opts := &renderOptions{minContrast: 7}
+	for idx := range 256 {
+		bg := paletteColor(uint8(idx))
+		fg := style{}.contrastForeground(paletteColor(uint8(255-idx)), bg, opts)
+		v, bgRGB := fg.resolvedRGB(defaultForegroundRGB), paletteRGB(uint8(idx))
+		// Some backgrounds can't reach the ratio even with white or black.
+		want := min(7, max(contrastRatio(0xffffff, bgRGB), contrastRatio(0x000000, bgRGB)))
+		if got := contrastRatio(v, bgRGB); got < want {
+			t.Errorf("contrast of %06x on palette colour %d = %.2f, want at least %.2f", v, idx, got, want)
+		}
+	}
+}
+
+func TestWithMinContrastInvalid(t *testing.T) {
+	for _, ratio := range []float64{-1, 0.5, 22} {
+		if _, err := NewScreen(WithMinContrast(ratio)); err == nil {
+			t.Errorf("NewScreen(WithMinContrast(%g)) error = nil, want an error", ratio)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The maximum number of buffered bytes shown by DebugState.
+const debugStateBytesLimit = 64
+
+// DebugState returns a description of the state of the parser, for
+// debugging input that isn't parsed as expected (such as a sequence that is
+// never terminated). It includes the current parser mode, the bytes buffered
+// waiting for the rest of a sequence (see Pending), the parameters of the
+// sequence so far, and the cursor positions. The format is meant to be read
+// by people, and may change.
+func (s *Screen) DebugState() string {
+	var b strings.Builder
+	p := &s.parser
+
+	mode := fmt.Sprintf("unknown (%d)", p.mode)
+	if p.mode >= 0 && p.mode < len(parserModeNames) {
+		mode = parserModeNames[p.mode]
+	}
+	fmt.Fprintf(&b, "mode: %s\n", mode)
+	fmt.Fprintf(&b, "offset: %d\n", p.offset)
+
+	buffered := p.remainder
+	fmt.Fprintf(&b, "buffered: %d bytes", len(buffered))
+	if len(buffered) > 0 {
+		fmt.Fprintf(&b, ": % x", buffered[:min(len(buffered), debugStateBytesLimit)])
+		if len(buffered) > debugStateBytesLimit {
+			b.WriteString(" ...")
+		}
+	}
+	b.WriteByte('\n')
+
+	if p.mode != parserModeNormal {
+		// These are indices into the buffered bytes.
+		fmt.Fprintf(&b, "sequence start: %d\n", p.escapeStartedAt)
+		fmt.Fprintf(&b, "parser cursor: %d\n", p.cursor)
+	}
+	if p.mode == parserModeControl {
+		fmt.Fprintf(&b, "instructions: %q\n", p.instructions)
+		if len(p.intermediates) > 0 {
+			fmt.Fprintf(&b, "intermediates: %q\n", p.intermediates)
+		}
+	}
+
+	fmt.Fprintf(&b, "screen cursor: %d,%d\n", s.x, s.y)
+	fmt.Fprintf(&b, "saved cursor: %d,%d\n", s.savedCursor.x, s.savedCursor.y)
+	return b.String()
+}
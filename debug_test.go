@@ -0,0 +1,73 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugState(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string
+	}{
+		{
+			name:  "normal",
+			input: "abc\x1b7\ndef",
+			want: []string{
+				"mode: normal\n",
+				"offset: 9\n",
+				"buffered: 0 bytes\n",
+				"screen cursor: 3,1\n",
+				"saved cursor: 3,0\n",
+			},
+		},
+		{
+			name:  "mid OSC",
+			input: "abc\x1b]0;title",
+			want: []string{
+				"mode: OSC\n",
+				"offset: 3\n",
+				"buffered: 9 bytes: 1b 5d 30 3b 74 69 74 6c 65\n",
+				"sequence start: 0\n",
+				"parser cursor: 9\n",
+				"screen cursor: 3,0\n",
+			},
+		},
+		{
+			name:  "mid OSC escape",
+			input: "\x1b]0;title\x1b",
+			want:  []string{"mode: OSC escape\n"},
+		},
+		{
+			name:  "mid CSI",
+			input: "x\x1b[1;31",
+			want: []string{
+				"mode: CSI\n",
+				"buffered: 6 bytes: 1b 5b 31 3b 33 31\n",
+				`instructions: ["1"]` + "\n",
+			},
+		},
+		{
+			name:  "truncated",
+			input: "\x1b]0;" + strings.Repeat("a", 100),
+			want:  []string{"buffered: 104 bytes: 1b 5d 30 3b 61 61", "61 61 ...\n"},
+		},
+		{
+			name:  "incomplete rune",
+			input: "ab\xe6\xbc",
+			want:  []string{"mode: normal\n", "buffered: 2 bytes: e6 bc\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			got := s.DebugState()
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("DebugState() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
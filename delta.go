@@ -0,0 +1,43 @@
+package terminal
+
+import "strings"
+
+// WriteAndRenderDelta writes input to the screen, as with Write, and returns
+// the HTML (rendered as in AsHTML) of the lines that changed as a result, in
+// order and separated by newlines. This is the building block for streaming
+// rendered output, where each chunk of input maps to a patch.
+//
+// A line has changed if its HTML differs from when it was last returned by
+// WriteAndRenderDelta, or if it hasn't been returned before. Lines above the
+// window can't change, so once a line has left the window, it isn't returned
+// again. Lines that scroll out of the buffer during the write (see
+// ScrollOutFunc) aren't returned either. To tell which line is which, use
+// WithLineIDs.
+func (s *Screen) WriteAndRenderDelta(input []byte) string {
+	s.Write(input)
+
+	if s.deltaHTML == nil {
+		s.deltaHTML = make(map[int]string)
+	}
+
+	var changed []string
+	for i := max(s.deltaTop-s.LinesScrolledOut, 0); i < len(s.screen); i++ {
+		number := s.LinesScrolledOut + i
+		html := s.lineHTML(i, nil)
+		if prev, ok := s.deltaHTML[number]; ok && prev == html {
+			continue
+		}
+		s.deltaHTML[number] = html
+		changed = append(changed, html)
+	}
+
+	// Forget lines that have left the window, since they won't change again.
+	s.deltaTop = s.LinesScrolledOut + s.top()
+	for number := range s.deltaHTML {
+		if number < s.deltaTop {
+			delete(s.deltaHTML, number)
+		}
+	}
+
+	return strings.Join(changed, "\n")
+}
@@ -0,0 +1,30 @@
+package terminal
+
+import "testing"
+
+func TestWriteAndRenderDelta(t *testing.T) {
+	s, err := NewScreen(WithSize(10, 3))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+
+	steps := []struct {
+		input, want string
+	}{
+		{input: "\x1b[32mok\x1b[0m one\n", want: `<span class="term-fg32">ok</span> one`},
+		{input: "two", want: "two"},
+		{input: "", want: ""},
+		{input: " more\nthree", want: "two more\nthree"},
+		{input: "\x1b[2A\rOK", want: "OK one"},
+		{input: "\x1b[2B\nfour", want: "four"},
+		// "OK one" has left the window, so can't change.
+		{input: "\x1b[10A\rT", want: "Two more"},
+	}
+
+	for _, step := range steps {
+		got := s.WriteAndRenderDelta([]byte(step.input))
+		if got != step.want {
+			t.Errorf("WriteAndRenderDelta(%q) = %q, want %q", step.input, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/terminal-to-html/v3/internal/assets"
+)
+
+// WrapperOptions control how AsHTMLDocument wraps the screen contents.
+type WrapperOptions struct {
+	// Tag is the element to wrap the contents in: "pre" (the default, used
+	// for any other value) or "div".
+	Tag string
+
+	// Class is added to the wrapper's class attribute, after the
+	// term-container class that the stylesheet requires.
+	Class string
+
+	// Style is the wrapper's inline style attribute, if not empty.
+	Style string
+
+	// Role is the wrapper's ARIA role, if not empty. If it is empty,
+	// AsHTMLDocument uses the role from WithAccessibility, if any.
+	Role string
+
+	// TabSize, if positive, sets the CSS tab-size property of the wrapper
+	// (ahead of Style), so that preserved tabs (see TabPreserve) line up
+	// with the tab stops. If it is 0, AsHTMLDocument sets it to the screen's
+	// tab width when tabs are preserved, unless that is 8, which is the
+	// default tab-size anyway.
+	TabSize int
+
+	// EmbedStylesheet includes the stylesheet for the classes used in the
+	// output as a <style> element before the wrapper.
+	EmbedStylesheet bool
+}
+
+// AsHTMLDocument returns the contents of the screen buffer as HTML (as in
+// AsHTML), wrapped in an element ready to embed in a page, and optionally
+// preceded by the stylesheet. Attribute values are escaped.
+func (s *Screen) AsHTMLDocument(opts WrapperOptions) string {
+	if opts.TabSize == 0 && s.tabMode == TabPreserve && s.tabWidth != cssDefaultTabSize {
+		opts.TabSize = s.tabWidth
+	}
+	if opts.Role == "" {
+		opts.Role = s.render.role
+	}
+	return opts.wrap(s.AsHTML())
+}
+
+// cssDefaultTabSize is the initial value of the CSS tab-size property.
+const cssDefaultTabSize = 8
+
+// wrap wraps HTML contents as described by the options.
+func (opts WrapperOptions) wrap(contents string) string {
+	tag := "pre"
+	if opts.Tag == "div" {
+		tag = "div"
+	}
+
+	var b outputBuffer
+	if opts.EmbedStylesheet {
+		// The stylesheet is embedded in the binary, so this can't fail.
+		css, _ := assets.TerminalCSS()
+		b.buf.WriteString("<style>")
+		b.buf.Write(css)
+		b.buf.WriteString("</style>")
+	}
+
+	b.buf.WriteString("<" + tag)
+	b.appendAttr("class", strings.TrimSpace("term-container "+opts.Class))
+	style := opts.Style
+	if opts.TabSize > 0 {
+		style = strings.TrimSuffix("tab-size: "+strconv.Itoa(opts.TabSize)+"; "+style, " ")
+	}
+	if style != "" {
+		b.appendAttr("style", style)
+	}
+	if opts.Role != "" {
+		b.appendAttr("role", opts.Role)
+	}
+	b.buf.WriteString(">")
+	b.buf.WriteString(contents)
+	b.buf.WriteString("</" + tag + ">")
+	return b.buf.String()
+}
@@ -0,0 +1,117 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAsHTMLDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		opts WrapperOptions
+		want string
+	}{
+		{
+			name: "defaults",
+			want: `<pre class="term-container"><span class="term-fg31">hi</span></pre>`,
+		},
+		{
+			name: "div with class and style",
+			opts: WrapperOptions{Tag: "div", Class: "term log", Style: "max-height: 10em"},
+			want: `<div class="term-container term log" style="max-height: 10em"><span class="term-fg31">hi</span></div>`,
+		},
+		{
+			name: "unknown tag",
+			opts: WrapperOptions{Tag: "script"},
+			want: `<pre class="term-container"><span class="term-fg31">hi</span></pre>`,
+		},
+		{
+			name: "attribute values are escaped",
+			opts: WrapperOptions{Class: `x" onclick="alert(1)`, Style: `a:"b" <c>`},
+			want: `<pre class="term-container x&#34; onclick=&#34;alert(1)" style="a:&#34;b&#34; &lt;c&gt;"><span class="term-fg31">hi</span></pre>`,
+		},
+		{
+			name: "tab size",
+			opts: WrapperOptions{TabSize: 4},
+			want: `<pre class="term-container" style="tab-size: 4;"><span class="term-fg31">hi</span></pre>`,
+		},
+		{
+			name: "tab size and style",
+			opts: WrapperOptions{TabSize: 2, Style: "max-height: 10em"},
+			want: `<pre class="term-container" style="tab-size: 2; max-height: 10em"><span class="term-fg31">hi</span></pre>`,
+		},
+	}
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b[31mhi"))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := s.AsHTMLDocument(test.opts); got != test.want {
+				t.Errorf("AsHTMLDocument(%+v) = %q, want %q", test.opts, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAsHTMLDocumentTabSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		screenOpts  []ScreenOption
+		wrapperOpts WrapperOptions
+		want        string
+	}{
+		{
+			name: "default tab width",
+			want: "<pre class=\"term-container\">a\tb</pre>",
+		},
+		{
+			name:       "tab width",
+			screenOpts: []ScreenOption{WithTabWidth(4)},
+			want:       "<pre class=\"term-container\" style=\"tab-size: 4;\">a\tb</pre>",
+		},
+		{
+			name:        "explicit tab size",
+			screenOpts:  []ScreenOption{WithTabWidth(4)},
+			wrapperOpts: WrapperOptions{TabSize: 3},
+			want:        "<pre class=\"term-container\" style=\"tab-size: 3;\">a\tb</pre>",
+		},
+		{
+			name:       "tabs expanded",
+			screenOpts: []ScreenOption{WithTabWidth(4), WithTabMode(TabExpand)},
+			want:       `<pre class="term-container">a   b</pre>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.screenOpts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte("a\tb"))
+			if got := s.AsHTMLDocument(test.wrapperOpts); got != test.want {
+				t.Errorf("AsHTMLDocument(%+v) = %q, want %q", test.wrapperOpts, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAsHTMLDocumentEmbedsStylesheet(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("hi"))
+
+	got := s.AsHTMLDocument(WrapperOptions{EmbedStylesheet: true})
+	if !strings.HasPrefix(got, "<style>.term-container {") {
+		t.Errorf("AsHTMLDocument() = %q, want a leading <style> element", got)
+	}
+	if want := `</style><pre class="term-container">hi</pre>`; !strings.HasSuffix(got, want) {
+		t.Errorf("AsHTMLDocument() = %q, want suffix %q", got, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"html"
 	"mime"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +27,29 @@ type element struct {
 	elementType int
 }
 
+// ElementInfo describes an element: an inline image, or a link written with
+// the Buildkite link sequence. See WithElementPlaceholder.
+type ElementInfo struct {
+	// Image is true for images, and false for links.
+	Image bool
+
+	// URL is the image or link URL. For iTerm inline images, it is the file
+	// name.
+	URL string
+
+	// Alt is the alt text of an image, and Text is the text of a link. Either
+	// may be empty.
+	Alt, Text string
+}
+
+// info converts the element into an ElementInfo.
+func (i *element) info() ElementInfo {
+	if i.elementType == elementLink {
+		return ElementInfo{URL: i.url, Text: i.content}
+	}
+	return ElementInfo{Image: true, URL: i.url, Alt: i.alt}
+}
+
 var errUnsupportedElementSequence = errors.New("Unsupported element sequence")
 
 func (i *element) asHTML() string {
@@ -93,17 +117,24 @@ func parseElementSequence(sequence string) (*element, error) {
 	elem := &element{content: content, elementType: elementType}
 
 	if elementType == elementITermLink {
-		// For "iTerm" links (OSC 8), tokens[0] is params and tokens[1] is the URL.
-		// Aside from not quoting the URL, we ignore params.
+		// For "iTerm" links (OSC 8), args is params;URL. Params are
+		// key=value pairs separated by colons (e.g. id=abc:foo=bar), and
+		// are ignored, so only the first ; is significant: everything after
+		// it is the URL, verbatim, even if it contains ; : or =.
 		// The link "content" comes after the element and is stored
 		// as regular text in the screen line, because they are designed to gracefully
 		// degrade to plain text if the sequence isn't supported.
-		tokens := strings.Split(args, ";")
-		if len(tokens) != 2 {
+		_, url, ok := strings.Cut(args, ";")
+		if !ok {
 			// Probably malformed
 			return nil, nil
 		}
-		elem.url = tokens[1]
+		elem.url = url
+		if !validURL(elem.url) {
+			// A broken URL (e.g. one cut short by a stray control character)
+			// would make a broken link, so leave the text unlinked instead.
+			elem.url = ""
+		}
 		return elem, nil
 	}
 
@@ -173,6 +204,16 @@ func contentTypeForFile(filename string) string {
 	return mime.TypeByExtension(filename[dot:])
 }
 
+// cells returns the number of character cells the element is declared to be
+// wide: its width, if that was given as a number of cells, and 1 otherwise.
+func (i *element) cells() int {
+	n, err := strconv.Atoi(strings.TrimSuffix(i.width, "em"))
+	if err != nil || n < 1 || !strings.HasSuffix(i.width, "em") {
+		return 1
+	}
+	return n
+}
+
 func parseImageDimension(s string) string {
 	s = strings.ToLower(s)
 	if !strings.HasSuffix(s, "px") && !strings.HasSuffix(s, "%") {
@@ -102,6 +102,34 @@ var validCases = []struct {
 		`1337: malfored arguments are silently ignored`,
 		`1337;File=name=Zm9vLmdpZg==;inline=1;sdfsdfs;====ddd;herp=derps:AA==`,
 		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", elementType: elementITermImage},
+	}, {
+		`8: link without params`,
+		"8;;https://example.com/",
+		&element{url: "https://example.com/", elementType: elementITermLink},
+	}, {
+		`8: link with an id param`,
+		"8;id=build-42;https://example.com/",
+		&element{url: "https://example.com/", elementType: elementITermLink},
+	}, {
+		`8: link with several params, containing special characters`,
+		"8;id=a=b/c?d:foo=https%3A//x:empty=:novalue;https://example.com/",
+		&element{url: "https://example.com/", elementType: elementITermLink},
+	}, {
+		`8: the end of a link`,
+		"8;;",
+		&element{elementType: elementITermLink},
+	}, {
+		`8: URL with a query string and fragment`,
+		"8;id=1;https://example.com/search?q=a+b&lang=en#results",
+		&element{url: "https://example.com/search?q=a+b&lang=en#results", elementType: elementITermLink},
+	}, {
+		`8: URL with colons, = and ;`,
+		"8;;https://user:pw@example.com:8443/a:b;c=d?e=f:g;h#i:j=k",
+		&element{url: "https://user:pw@example.com:8443/a:b;c=d?e=f:g;h#i:j=k", elementType: elementITermLink},
+	}, {
+		`8: missing URL part is ignored`,
+		"8;https://example.com/",
+		nil,
 	}, {
 		`1338: image with filename`,
 		"1338;url=tmp/foo.gif",
@@ -0,0 +1,81 @@
+package terminal
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// Fingerprint returns a hash of the contents of the screen buffer: the text,
+// styles, links, elements and metadata of each line. Screens with the same
+// contents have the same fingerprint, so it can be used to cheaply tell
+// whether the output needs rendering again. The cursor position and other
+// state that doesn't affect the output (such as the window title) are not
+// included. Fingerprints are not stable across versions of this package.
+func (s *Screen) Fingerprint() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	writeInt := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+
+	writeInt(uint64(len(s.screen)))
+	for i := range s.screen {
+		line := &s.screen[i]
+		writeInt(uint64(len(line.nodes)))
+		for x, n := range line.nodes {
+			writeInt(uint64(n.blob)<<32 | uint64(n.style.flags))
+			writeInt(uint64(n.style.fg)<<32 | uint64(n.style.bg))
+			switch {
+			case n.style.element():
+				writeString(h, line.elements[n.blob].asHTML())
+			case n.style.cluster():
+				writeString(h, line.graphemes[n.blob])
+			}
+			if n.style.hyperlink() {
+				writeString(h, line.hyperlinks[x])
+			}
+			if n.style.tooltip() {
+				writeString(h, line.tooltips[x])
+			}
+		}
+		writeMetadata(h, line.metadata)
+	}
+	return h.Sum64()
+}
+
+// writeString writes s to h, preceded by its length so that consecutive
+// strings can't run together.
+func writeString(h hash.Hash64, s string) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(s)))
+	h.Write(buf[:])
+	h.Write([]byte(s))
+}
+
+// writeMetadata writes line metadata to h, in sorted order. The number of
+// namespaces is written first, so that lines can't run together.
+func writeMetadata(h hash.Hash64, metadata map[string]map[string]string) {
+	writeString(h, strconv.Itoa(len(metadata)))
+	namespaces := make([]string, 0, len(metadata))
+	for ns := range metadata {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+	for _, ns := range namespaces {
+		keys := make([]string, 0, len(metadata[ns]))
+		for k := range metadata[ns] {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		writeString(h, ns)
+		writeString(h, strconv.Itoa(len(keys)))
+		for _, k := range keys {
+			writeString(h, k)
+			writeString(h, metadata[ns][k])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package terminal
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(input string) uint64 {
+		t.Helper()
+		s, err := NewScreen()
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte(input))
+		return s.Fingerprint()
+	}
+
+	base := fingerprint("hello \x1b[31mworld\x1b[0m\nbye")
+
+	same := []struct{ name, input string }{
+		{name: "identical", input: "hello \x1b[31mworld\x1b[0m\nbye"},
+		{name: "redundant sequences", input: "hello \x1b[31m\x1b[31mworld\x1b[m\nbye"},
+		{name: "cursor moved", input: "hello \x1b[31mworld\x1b[0m\nbye\x1b[2D"},
+		{name: "overwritten", input: "xxxxx \x1b[31mworld\x1b[0m\nbye\x1b[A\rhello"},
+	}
+	for _, test := range same {
+		if got := fingerprint(test.input); got != base {
+			t.Errorf("%s: Fingerprint() = %x, want %x", test.name, got, base)
+		}
+	}
+
+	different := []struct{ name, input string }{
+		{name: "style changed", input: "hello \x1b[32mworld\x1b[0m\nbye"},
+		{name: "bold added", input: "hello \x1b[1;31mworld\x1b[0m\nbye"},
+		{name: "text changed", input: "hello \x1b[31mworle\x1b[0m\nbye"},
+		{name: "lines joined", input: "hello \x1b[31mworld\x1b[0mbye"},
+		{name: "linked", input: "hello \x1b[31m\x1b]8;;http://example.com\x1b\\world\x1b]8;;\x1b\\\x1b[0m\nbye"},
+		{name: "timestamped", input: "\x1b_bk;t=1\x07hello \x1b[31mworld\x1b[0m\nbye"},
+	}
+	for _, test := range different {
+		if got := fingerprint(test.input); got == base {
+			t.Errorf("%s: Fingerprint() = %x, the same as the original", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// foldMaxBlockLines is the largest block of lines that WithRepeatFolding
+// looks for repeats of.
+const foldMaxBlockLines = 50
+
+// WithRepeatFolding folds blocks of lines that are repeated at least
+// minRepeats times in a row into collapsible regions in the HTML output.
+// Programs that repaint the whole screen on each tick (such as interactive
+// installers) can otherwise produce many identical copies of each frame. The
+// first copy of the block is shown, and the rest are wrapped in a <details
+// class="term-fold"> element, with a summary saying how many copies it holds.
+// Blocks can be up to 50 lines long. Lines are compared by content and style,
+// ignoring metadata such as timestamps, and blocks of blank lines are never
+// folded. minRepeats must be at least 2, and folding is off by default.
+func WithRepeatFolding(minRepeats int) ScreenOption {
+	return func(s *Screen) error {
+		if minRepeats < 2 {
+			return fmt.Errorf("repeat folding threshold %d is less than 2", minRepeats)
+		}
+		s.render.foldMinRepeats = minRepeats
+		return nil
+	}
+}
+
+// A fold is a range of lines, from start up to (but not including) end, that
+// repeat the block of lines before them, and so are folded away.
+type fold struct {
+	start, end int
+
+	// Number of copies of the repeated block within the fold
+	copies int
+}
+
+// open returns the opening tags of the fold region.
+func (f fold) open() string {
+	return `<details class="term-fold"><summary>Repeated ` + strconv.Itoa(f.copies) + ` more times</summary>`
+}
+
+// foldClose is the closing tag of a fold region.
+const foldClose = "</details>"
+
+// findFolds returns the folds, in order, in the lines of the screen buffer
+// from start onwards, if repeat folding is enabled.
+func (s *Screen) findFolds(start int) []fold {
+	if s.render.foldMinRepeats == 0 {
+		return nil
+	}
+
+	// Give each distinct line an id, so blocks can be compared cheaply.
+	// Metadata is left out, since timestamps would make every line distinct.
+	ids := make([]int, len(s.screen)-start)
+	blank := make([]bool, len(ids))
+	seen := make(map[string]int)
+	for i := range ids {
+		line := s.screen[start+i]
+		line.metadata = nil
+		key := line.asHTML(&s.render, nil)
+		id, ok := seen[key]
+		if !ok {
+			id = len(seen)
+			seen[key] = id
+		}
+		ids[i] = id
+		blank[i] = line.isBlank()
+	}
+
+	var folds []fold
+	minRepeats := s.render.foldMinRepeats
+	for i := 0; i < len(ids); {
+		// Find the block length that folds away the most lines.
+		best, bestRepeats := 0, 0
+		for n := 1; n <= foldMaxBlockLines && i+n*minRepeats <= len(ids); n++ {
+			if allTrue(blank[i : i+n]) {
+				continue
+			}
+			repeats := 1
+			for repeatsBlock(ids, i, n, i+repeats*n) {
+				repeats++
+			}
+			if repeats >= minRepeats && n*repeats > best*bestRepeats {
+				best, bestRepeats = n, repeats
+			}
+		}
+
+		if best == 0 {
+			i++
+			continue
+		}
+		folds = append(folds, fold{
+			start:  start + i + best,
+			end:    start + i + best*bestRepeats,
+			copies: bestRepeats - 1,
+		})
+		i += best * bestRepeats
+	}
+	return folds
+}
+
+// repeatsBlock reports whether the n ids from at are the same as the n ids
+// from block.
+func repeatsBlock(ids []int, block, n, at int) bool {
+	if at+n > len(ids) {
+		return false
+	}
+	for j := range n {
+		if ids[at+j] != ids[block+j] {
+			return false
+		}
+	}
+	return true
+}
+
+func allTrue(bs []bool) bool {
+	for _, b := range bs {
+		if !b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithRepeatFolding(t *testing.T) {
+	tests := []struct {
+		name, input string
+		minRepeats  int
+		want        string
+	}{
+		{
+			name:       "repeating block",
+			input:      "start\n" + strings.Repeat("\x1b[32mframe\x1b[0m\ntick\n", 4) + "end",
+			minRepeats: 3,
+			want: "start\n" +
+				`<span class="term-fg32">frame</span>` + "\ntick\n" +
+				`<details class="term-fold"><summary>Repeated 3 more times</summary>` +
+				strings.Repeat(`<span class="term-fg32">frame</span>`+"\ntick\n", 2) +
+				`<span class="term-fg32">frame</span>` + "\ntick</details>\n" +
+				"end",
+		},
+		{
+			name:       "repeated line",
+			input:      "a\nb\nb\nb\nc",
+			minRepeats: 3,
+			want: "a\nb\n" +
+				`<details class="term-fold"><summary>Repeated 2 more times</summary>b` + "\nb</details>\n" +
+				"c",
+		},
+		{
+			name:       "below threshold",
+			input:      "a\nb\nb\nc",
+			minRepeats: 3,
+			want:       "a\nb\nb\nc",
+		},
+		{
+			name:       "blank lines are not folded",
+			input:      "a\n\n\n\n\nc",
+			minRepeats: 2,
+			want:       "a\n&nbsp;\n&nbsp;\n&nbsp;\n&nbsp;\nc",
+		},
+		{
+			name:       "timestamps are ignored",
+			input:      "\x1b_bk;t=1\x07x\n\x1b_bk;t=2\x07x\n\x1b_bk;t=3\x07x",
+			minRepeats: 2,
+			want: `<time datetime="1970-01-01T00:00:00.001Z">1970-01-01T00:00:00.001Z</time>x` + "\n" +
+				`<details class="term-fold"><summary>Repeated 2 more times</summary>` +
+				`<time datetime="1970-01-01T00:00:00.002Z">1970-01-01T00:00:00.002Z</time>x` + "\n" +
+				`<time datetime="1970-01-01T00:00:00.003Z">1970-01-01T00:00:00.003Z</time>x</details>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithRepeatFolding(test.minRepeats))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithRepeatFoldingTruncated(t *testing.T) {
+	s, err := NewScreen(WithRepeatFolding(2), WithMaxHTMLBytes(80))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte(strings.Repeat("frame\n", 20)))
+	want := "frame\n" +
+		`<details class="term-fold"><summary>Repeated 19 more times</summary>frame</details>` + "\n" +
+		htmlTruncationNotice
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWithRepeatFoldingInvalid(t *testing.T) {
+	if _, err := NewScreen(WithRepeatFolding(1)); err == nil {
+		t.Error("NewScreen(WithRepeatFolding(1)) error = nil, want error")
+	}
+}
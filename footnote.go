@@ -0,0 +1,102 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PlainLinkMode controls how OSC 8 links and elements (such as Buildkite
+// links and images) are rendered by AsPlainText. See WithPlainTextLinks.
+type PlainLinkMode int
+
+const (
+	// PlainLinksOmit renders only the linked text, leaving out the URLs.
+	// This is the default.
+	PlainLinksOmit PlainLinkMode = iota
+
+	// PlainLinksInline renders links as Markdown links: [text](url).
+	PlainLinksInline
+
+	// PlainLinksFootnotes renders a numbered reference after the linked text
+	// (and after each element with a URL), as in text[1], and appends a list
+	// of the URLs after a blank line at the end: [1] https://... Links to the
+	// same URL share a number.
+	PlainLinksFootnotes
+)
+
+// WithPlainTextLinks sets how links and elements are rendered by AsPlainText
+// (and AsMarkdown). Other plain text output (such as TailText and LineText) is
+// not affected.
+func WithPlainTextLinks(mode PlainLinkMode) ScreenOption {
+	return func(s *Screen) error {
+		s.render.plainLinks = mode
+		return nil
+	}
+}
+
+// footnotes numbers the URLs referred to in plain text output, in the order
+// they are first referred to.
+type footnotes struct {
+	urls    []string
+	numbers map[string]int
+}
+
+// ref returns the reference to url, such as [1], numbering it if it is new.
+func (f *footnotes) ref(url string) string {
+	n, ok := f.numbers[url]
+	if !ok {
+		if f.numbers == nil {
+			f.numbers = make(map[string]int)
+		}
+		f.urls = append(f.urls, url)
+		n = len(f.urls)
+		f.numbers[url] = n
+	}
+	return "[" + strconv.Itoa(n) + "]"
+}
+
+// elementRef returns the reference to the URL of an element, or "" if it
+// doesn't have one (inline images are contained in the sequence).
+func (f *footnotes) elementRef(e *element) string {
+	if e.elementType == elementITermImage || e.url == "" {
+		return ""
+	}
+	return f.ref(e.url)
+}
+
+// list returns the list of URLs, one per line, or "" if there are none.
+func (f *footnotes) list() string {
+	var b strings.Builder
+	for i, url := range f.urls {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("[" + strconv.Itoa(i+1) + "] " + url)
+	}
+	return b.String()
+}
+
+// plainLines renders the lines from start onwards for AsPlainText, with links
+// rendered as set by links. For footnotes, the list of URLs is added after a
+// blank line.
+func (s *Screen) plainLines(start int, links PlainLinkMode) []string {
+	rendered, opts := s.renderedLines(start)
+	lines := make([]string, 0, len(rendered))
+	var notes footnotes
+	for _, line := range rendered {
+		var text string
+		switch links {
+		case PlainLinksInline:
+			text = line.asLinkedText(opts, "[", markdownLinkEnd, nil)
+		case PlainLinksFootnotes:
+			text = line.asLinkedText(opts, "", notes.ref, notes.elementRef)
+		default:
+			text = line.asPlain(opts)
+		}
+		lines = append(lines, text)
+	}
+	if len(notes.urls) > 0 {
+		lines = append(lines, "", notes.list())
+	}
+	return lines
+}
@@ -0,0 +1,89 @@
+package terminal
+
+import "testing"
+
+func TestWithPlainTextLinks(t *testing.T) {
+	const input = "see \x1b]8;;http://example.com/a\x1b\\the docs\x1b]8;;\x1b\\ or \x1b]8;;http://example.com/b\x1b\\the FAQ\x1b]8;;\x1b\\\n" +
+		"and \x1b]8;;http://example.com/a\x1b\\the docs\x1b]8;;\x1b\\ again, " +
+		"\x1b]1339;url=http://example.com/c;content=more\x07"
+
+	tests := []struct {
+		name string
+		mode PlainLinkMode
+		want string
+	}{
+		{
+			name: "omit",
+			mode: PlainLinksOmit,
+			want: "see the docs or the FAQ\nand the docs again,",
+		},
+		{
+			name: "inline",
+			mode: PlainLinksInline,
+			want: "see [the docs](http://example.com/a) or [the FAQ](http://example.com/b)\n" +
+				"and [the docs](http://example.com/a) again,",
+		},
+		{
+			name: "footnotes",
+			mode: PlainLinksFootnotes,
+			want: "see the docs[1] or the FAQ[2]\n" +
+				"and the docs[1] again, [3]\n" +
+				"\n" +
+				"[1] http://example.com/a\n" +
+				"[2] http://example.com/b\n" +
+				"[3] http://example.com/c",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithPlainTextLinks(test.mode))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if got := s.AsPlainText(); got != test.want {
+				t.Errorf("AsPlainText() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithPlainTextLinksFootnotesElements(t *testing.T) {
+	placeholder := func(info ElementInfo) string {
+		if info.Image {
+			return "[image: " + info.URL + "]"
+		}
+		return info.Text
+	}
+	s, err := NewScreen(WithPlainTextLinks(PlainLinksFootnotes), WithElementPlaceholder(placeholder), WithTrailingNewline(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("a \x1b]1339;url=http://example.com/;content=link\x07\n" +
+		"\x1b]1338;url=http://example.com/cat.gif;alt=cat\x07" +
+		"\x1b]1337;File=name=" + base64Encode("dog.gif") + ";inline=1:AA==\x07" +
+		"\x1b]8;;http://example.com/\x1b\\same\x1b]8;;\x1b\\"))
+
+	want := "a link[1]\n" +
+		"[image: http://example.com/cat.gif][2]\n" +
+		"[image: dog.gif]\n" +
+		"same[1]\n" +
+		"\n" +
+		"[1] http://example.com/\n" +
+		"[2] http://example.com/cat.gif\n"
+	if got := s.AsPlainText(); got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
+
+func TestWithPlainTextLinksNoLinks(t *testing.T) {
+	s, err := NewScreen(WithPlainTextLinks(PlainLinksFootnotes))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("no \x1b[1mlinks\x1b[0m here"))
+	if got, want := s.AsPlainText(), "no links here"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package terminal
+
+import "unicode/utf8"
+
+// Cell is a single cell of the screen, as returned by AsGrid.
+type Cell struct {
+	// The character in the cell. Empty cells contain a space.
+	// If the cell holds a grapheme cluster, this is its first rune.
+	Rune rune
+
+	// The full text of the cell, if it holds a grapheme cluster of more than
+	// one rune (such as a letter with combining accents, or an emoji
+	// sequence). Otherwise it is empty.
+	Cluster string
+
+	// Continuation is true if the cell is part of a wide character or
+	// preserved tab that starts in an earlier cell. Rune is a space for
+	// continuation cells.
+	Continuation bool
+
+	// The style of the cell.
+	Style StyleInfo
+
+	// Element is true if the cell contains an element (such as an inline
+	// image) rather than a character. Rune is a space for element cells.
+	Element bool
+
+	// The target of the OSC 8 hyperlink covering the cell, if any.
+	URL string
+}
+
+// AsGrid returns the visible window as a dense grid of cells, with one row
+// per window line and one cell per window column. Unlike the screen buffer,
+// which only stores as much of each line as has been written to, every cell
+// of the window is populated: empty cells are spaces with the default style.
+// Lines longer than the window width are clipped.
+func (s *Screen) AsGrid() [][]Cell {
+	grid := make([][]Cell, s.lines)
+	top := s.top()
+	for y := range grid {
+		row := make([]Cell, s.cols)
+		for x := range row {
+			row[x].Rune = ' '
+		}
+		grid[y] = row
+
+		if top+y >= len(s.screen) {
+			continue
+		}
+		line := &s.screen[top+y]
+		for x, n := range line.nodes[:min(len(line.nodes), s.cols)] {
+			cell := &row[x]
+			cell.Style = n.style.info()
+			switch {
+			case n.style.element():
+				cell.Element = true
+			case n.style.cont():
+				cell.Continuation = true
+			case n.style.cluster():
+				cell.Cluster = line.graphemes[n.blob]
+				cell.Rune, _ = utf8.DecodeRuneInString(cell.Cluster)
+			default:
+				cell.Rune = n.blob
+			}
+			if n.style.hyperlink() {
+				cell.URL = line.hyperlinks[x]
+			}
+		}
+	}
+	return grid
+}
+
+// StyleAt returns the style of the cell at the given row and column of the
+// screen buffer. Unlike AsGrid, row is an index into the whole buffer (including
+// any lines above the window), not the window. For cells containing elements,
+// the style is the one that was current when the element was written.
+// It returns false if nothing has been written at that position.
+func (s *Screen) StyleAt(row, col int) (StyleInfo, bool) {
+	if row < 0 || row >= len(s.screen) {
+		return StyleInfo{}, false
+	}
+	line := &s.screen[row]
+	if col < 0 || col >= len(line.nodes) {
+		return StyleInfo{}, false
+	}
+	return line.nodes[col].style.info(), true
+}
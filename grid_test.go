@@ -0,0 +1,136 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAsGrid(t *testing.T) {
+	s, err := NewScreen(WithSize(4, 3))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("zero\none\n\x1b[31mt\x1b[0mw\x1b]8;;http://example.com\x07o\x1b]8;;\x07"))
+
+	blank := Cell{Rune: ' '}
+	red := StyleInfo{Foreground: Color{Mode: ColorPalette, Index: 1}}
+	want := [][]Cell{
+		{{Rune: 'z'}, {Rune: 'e'}, {Rune: 'r'}, {Rune: 'o'}},
+		{{Rune: 'o'}, {Rune: 'n'}, {Rune: 'e'}, blank},
+		{{Rune: 't', Style: red}, {Rune: 'w'}, {Rune: 'o', URL: "http://example.com"}, blank},
+	}
+	if diff := cmp.Diff(s.AsGrid(), want); diff != "" {
+		t.Errorf("AsGrid diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAsGridWindow(t *testing.T) {
+	s, err := NewScreen(WithSize(3, 2))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+
+	// Fewer lines than the window height: rows below are blank.
+	s.Write([]byte("a"))
+	blank := Cell{Rune: ' '}
+	want := [][]Cell{
+		{{Rune: 'a'}, blank, blank},
+		{blank, blank, blank},
+	}
+	if diff := cmp.Diff(s.AsGrid(), want); diff != "" {
+		t.Errorf("AsGrid diff (-got +want):\n%s", diff)
+	}
+
+	// More lines than the window height: only the bottom lines are visible.
+	// Lines wider than the window (after resizing) are clipped.
+	s.Write([]byte("bc\ndef\nghi"))
+	if err := s.SetSize(2, 2); err != nil {
+		t.Fatalf("SetSize(2, 2) = %v", err)
+	}
+	want = [][]Cell{
+		{{Rune: 'd'}, {Rune: 'e'}},
+		{{Rune: 'g'}, {Rune: 'h'}},
+	}
+	if diff := cmp.Diff(s.AsGrid(), want); diff != "" {
+		t.Errorf("AsGrid diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestStyleAt(t *testing.T) {
+	s, err := NewScreen(WithSize(10, 1))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("first\n\x1b[1;31mre\x1b[0md\x1b[42m\x1b]1339;url=http://example.com\x07"))
+
+	red := StyleInfo{Foreground: Color{Mode: ColorPalette, Index: 1}, Bold: true}
+	green := StyleInfo{Background: Color{Mode: ColorPalette, Index: 2}}
+	tests := []struct {
+		row, col  int
+		want      StyleInfo
+		wantFound bool
+	}{
+		{row: 0, col: 0, wantFound: true}, // above the window
+		{row: 1, col: 0, want: red, wantFound: true},
+		{row: 1, col: 1, want: red, wantFound: true},
+		{row: 1, col: 2, wantFound: true},
+		{row: 1, col: 3, want: green, wantFound: true}, // element
+		{row: 1, col: 4}, // past the end of the line
+		{row: 1, col: -1},
+		{row: 2, col: 0},
+		{row: -1, col: 0},
+	}
+
+	for _, test := range tests {
+		got, found := s.StyleAt(test.row, test.col)
+		if diff := cmp.Diff(got, test.want); diff != "" || found != test.wantFound {
+			t.Errorf("StyleAt(%d, %d) = (%v, %t), want (%v, %t)", test.row, test.col, got, found, test.want, test.wantFound)
+		}
+	}
+}
+
+func TestLineWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{name: "empty", input: "", want: 0},
+		{name: "ascii", input: "hello", want: 5},
+		{name: "trailing blanks", input: "hi   \x1b[41m  \x1b[0m", want: 2},
+		{name: "wide characters", input: "漢字!", want: 5},
+		{name: "trailing wide character", input: "a漢", want: 3},
+		{name: "combining mark", input: "café", want: 4},
+		{name: "tab", input: "a\tb", want: 9},
+		{name: "trailing tab", input: "a\t", want: 1},
+		{name: "element with cell width", input: "\x1b]1338;url=http://example.com/a.png;width=3\x07", want: 3},
+		{name: "element with pixel width", input: "\x1b]1338;url=http://example.com/a.png;width=30px\x07", want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.LineWidth(0); got != test.want {
+				t.Errorf("LineWidth(0) = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLineWidthOutOfRange(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("hello"))
+	for _, row := range []int{-1, 1} {
+		if got := s.LineWidth(row); got != 0 {
+			t.Errorf("LineWidth(%d) = %d, want 0", row, got)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package terminal
+
+import "io"
+
+// HTMLRun maps a run of text in the HTML output back to the cells of the
+// screen buffer it was rendered from.
+type HTMLRun struct {
+	// Offset is the byte offset in the output of the start of the run's text.
+	Offset int64
+
+	// Range is the nodes rendered as the run. The text of the run follows
+	// Offset, up to the next tag, with one character (or grapheme cluster,
+	// or element) for each node, other than the continuation cells of wide
+	// characters and tabs.
+	Range Range
+}
+
+// WriteHTMLWithRuns is like WriteHTMLTo, but also returns where the text of
+// each cell is in the output, as runs of text, in order. A new run starts
+// wherever a tag is written, so a run is rendered in one style, and is either
+// all in a link or all not. This lets a page map a position in the HTML (such
+// as a click) back to a row and column of the screen buffer.
+func (s *Screen) WriteHTMLWithRuns(w io.Writer) ([]HTMLRun, int64, error) {
+	var runs []HTMLRun
+	n, err := s.writeHTMLLines(w, s.firstRenderedLine(true), htmlLinesOptions{runs: &runs})
+	return runs, n, err
+}
+
+// lineHTMLWithRuns is lineHTML, but also appends the runs of text in the
+// line's HTML to runs.
+func (s *Screen) lineHTMLWithRuns(i int, marks []Range, runs []htmlRun) (string, []htmlRun) {
+	contents := s.screen[i].asHTMLWithRuns(&s.render, marks, &runs)
+	open := s.lineOpenTag(i, s.lineID(i))
+	gutter := s.lineGutter(i)
+	for j := range runs {
+		runs[j].offset += len(open) + len(gutter)
+	}
+	if open == "" {
+		return gutter + contents, runs
+	}
+	return open + gutter + contents + "</span>", runs
+}
@@ -0,0 +1,103 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteHTMLWithRuns(t *testing.T) {
+	tests := []struct {
+		name, input string
+		opts        []ScreenOption
+		want        []HTMLRun
+		wantText    []string
+	}{
+		{
+			name:  "styled line",
+			input: "ab\x1b[31mc<\x1b[0m漢e",
+			want: []HTMLRun{
+				{Offset: 0, Range: Range{Line: 0, Start: 0, End: 2}},
+				{Offset: 26, Range: Range{Line: 0, Start: 2, End: 4}},
+				{Offset: 38, Range: Range{Line: 0, Start: 4, End: 7}},
+			},
+			wantText: []string{"ab", "c&lt;", "漢e"},
+		},
+		{
+			name:  "several lines",
+			input: "one\n\x1b[1mtwo\x1b[0m three\n\nfour",
+			opts:  []ScreenOption{WithLineIDs("L")},
+			want: []HTMLRun{
+				{Offset: 14, Range: Range{Line: 0, Start: 0, End: 3}},
+				{Offset: 62, Range: Range{Line: 1, Start: 0, End: 3}},
+				{Offset: 72, Range: Range{Line: 1, Start: 3, End: 9}},
+				{Offset: 128, Range: Range{Line: 3, Start: 0, End: 4}},
+			},
+			wantText: []string{"one", "two", " three", "four"},
+		},
+		{
+			name:  "line numbers",
+			input: "one\ntwo",
+			opts:  []ScreenOption{WithLineNumbers(1)},
+			want: []HTMLRun{
+				{Offset: 53, Range: Range{Line: 0, Start: 0, End: 3}},
+				{Offset: 110, Range: Range{Line: 1, Start: 0, End: 3}},
+			},
+			wantText: []string{"one", "two"},
+		},
+		{
+			name:  "link",
+			input: "see \x1b]8;;http://example.com\x1b\\here\x1b]8;;\x1b\\.",
+			want: []HTMLRun{
+				{Offset: 0, Range: Range{Line: 0, Start: 0, End: 4}},
+				{Offset: 33, Range: Range{Line: 0, Start: 4, End: 8}},
+				{Offset: 41, Range: Range{Line: 0, Start: 8, End: 9}},
+			},
+			wantText: []string{"see ", "here", "."},
+		},
+		{
+			name:  "trailing spaces",
+			input: "a\x1b[32mb\x1b[0m   ",
+			want: []HTMLRun{
+				{Offset: 0, Range: Range{Line: 0, Start: 0, End: 1}},
+				{Offset: 25, Range: Range{Line: 0, Start: 1, End: 2}},
+			},
+			wantText: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+
+			var b strings.Builder
+			runs, n, err := s.WriteHTMLWithRuns(&b)
+			if err != nil {
+				t.Fatalf("WriteHTMLWithRuns() error = %v", err)
+			}
+			html := b.String()
+			if n != int64(len(html)) {
+				t.Errorf("WriteHTMLWithRuns() n = %d, want %d", n, len(html))
+			}
+			if want := s.AsHTML(); html != want {
+				t.Errorf("WriteHTMLWithRuns() wrote %q, want %q", html, want)
+			}
+			if diff := cmp.Diff(runs, test.want); diff != "" {
+				t.Errorf("WriteHTMLWithRuns() runs diff (-got +want):\n%s\nhtml: %q", diff, html)
+			}
+			for i, r := range runs {
+				if i >= len(test.wantText) {
+					break
+				}
+				if got := html[r.Offset:]; !strings.HasPrefix(got, test.wantText[i]) {
+					t.Errorf("html[runs[%d].Offset:] = %q, want prefix %q", i, got, test.wantText[i])
+				}
+			}
+		})
+	}
+}
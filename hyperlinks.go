@@ -0,0 +1,64 @@
+package terminal
+
+import "strings"
+
+// LinkRef describes an OSC 8 hyperlink in the screen buffer, as returned by
+// Screen.Hyperlinks.
+type LinkRef struct {
+	// The link target.
+	URL string
+
+	// The plain text of the linked cells.
+	Text string
+
+	// The cells covered by the link. A link is within a single line: text
+	// linked across several lines is reported once for each line.
+	Range Range
+}
+
+// Hyperlinks returns the OSC 8 hyperlinks in the screen buffer (including any
+// lines above the window), in document order. Each run of adjacent cells
+// linked to the same URL is one link, even if it was written with several
+// sequences. Elements (such as Buildkite links) and automatic links (see
+// WithAutoLink) are not included.
+func (s *Screen) Hyperlinks() []LinkRef {
+	var links []LinkRef
+	for i := range s.screen {
+		links = s.screen[i].appendHyperlinks(links, i, &s.render)
+	}
+	return links
+}
+
+// appendHyperlinks appends the OSC 8 links in the line, which is at row i of
+// the buffer, to links.
+func (l *screenLine) appendHyperlinks(links []LinkRef, i int, opts *renderOptions) []LinkRef {
+	if len(l.hyperlinks) == 0 {
+		return links
+	}
+	var text strings.Builder
+	start, url := -1, ""
+	end := func(x int) {
+		if start >= 0 {
+			links = append(links, LinkRef{URL: url, Text: text.String(), Range: Range{Line: i, Start: start, End: x}})
+		}
+		text.Reset()
+		start, url = -1, ""
+	}
+	for x, n := range l.nodes {
+		linkURL := ""
+		if n.style.hyperlink() {
+			linkURL = l.hyperlinks[x]
+		}
+		if linkURL != url {
+			end(x)
+			if linkURL != "" {
+				start, url = x, linkURL
+			}
+		}
+		if start >= 0 {
+			l.writePlainNode(&text, n, opts)
+		}
+	}
+	end(len(l.nodes))
+	return links
+}
@@ -0,0 +1,42 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHyperlinks(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("see \x1b]8;;http://example.com/a\x1b\\the \x1b[1mdocs\x1b[0m\x1b]8;;\x1b\\ or " +
+		"\x1b]8;;http://example.com/b\x1b\\漢字\x1b]8;;\x1b\\\x1b]8;;http://example.com/c\x1b\\!\x1b]8;;\x1b\\\n" +
+		"no links\n" +
+		"\x1b]8;;http://example.com/a\x1b\\split\x1b]8;;\x1b\\\x1b]8;;http://example.com/a\x1b\\ twice\x1b]8;;\x1b\\ " +
+		"\x1b]8;id=x;http://example.com/d\x1b\\wraps\nhere\x1b]8;;\x1b\\"))
+
+	want := []LinkRef{
+		{URL: "http://example.com/a", Text: "the docs", Range: Range{Line: 0, Start: 4, End: 12}},
+		{URL: "http://example.com/b", Text: "漢字", Range: Range{Line: 0, Start: 16, End: 20}},
+		{URL: "http://example.com/c", Text: "!", Range: Range{Line: 0, Start: 20, End: 21}},
+		{URL: "http://example.com/a", Text: "split twice", Range: Range{Line: 2, Start: 0, End: 11}},
+		{URL: "http://example.com/d", Text: "wraps", Range: Range{Line: 2, Start: 12, End: 17}},
+		{URL: "http://example.com/d", Text: "here", Range: Range{Line: 3, Start: 0, End: 4}},
+	}
+	if diff := cmp.Diff(s.Hyperlinks(), want); diff != "" {
+		t.Errorf("Hyperlinks() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestHyperlinksNone(t *testing.T) {
+	s, err := NewScreen(WithAutoLink(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("http://example.com/ \x1b]1339;url=http://example.com/;content=x\x07"))
+	if got := s.Hyperlinks(); got != nil {
+		t.Errorf("Hyperlinks() = %v, want nil", got)
+	}
+}
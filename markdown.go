@@ -0,0 +1,86 @@
+package terminal
+
+import "strings"
+
+// WithMarkdownLinks keeps OSC 8 hyperlinks in the output of AsMarkdown, as
+// Markdown links around the linked text: [text](url). They are left out by
+// default, so that the code block contains only what was on the screen.
+func WithMarkdownLinks(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.markdownLinks = enabled
+		return nil
+	}
+}
+
+// AsMarkdown returns the contents of the screen buffer as a Markdown fenced
+// code block, for embedding in documents and issues. The text is the same as
+// AsPlainText, except that if WithMarkdownLinks is enabled and links would
+// otherwise be left out, they are kept as with PlainLinksInline. The fence is
+// made of more backticks than the longest run of backticks in the text, so the
+// text can't close the block early.
+func (s *Screen) AsMarkdown() string {
+	links := s.render.plainLinks
+	if s.render.markdownLinks && links == PlainLinksOmit {
+		links = PlainLinksInline
+	}
+	text := strings.Join(s.plainLines(s.firstRenderedLine(false), links), "\n")
+
+	fence := strings.Repeat("`", max(3, longestRun(text, '`')+1))
+	return fence + "\n" + text + "\n" + fence + "\n"
+}
+
+// markdownLinkEnd ends the text of a Markdown link to url.
+func markdownLinkEnd(url string) string {
+	return "](" + url + ")"
+}
+
+// asLinkedText renders the line as plain text, with the text of each OSC 8
+// link between open and close(url). If elementRef is not nil, its result is
+// written after each element.
+func (l *screenLine) asLinkedText(opts *renderOptions, open string, close func(url string) string, elementRef func(*element) string) string {
+	if len(l.hyperlinks) == 0 && (elementRef == nil || len(l.elements) == 0) {
+		return l.asPlain(opts)
+	}
+
+	var buf strings.Builder
+	url := ""
+	for x, node := range l.contentNodes(opts) {
+		linkURL := ""
+		if node.style.hyperlink() {
+			linkURL = l.hyperlinks[x]
+		}
+		if linkURL != url {
+			if url != "" {
+				buf.WriteString(close(url))
+			}
+			if linkURL != "" {
+				buf.WriteString(open)
+			}
+			url = linkURL
+		}
+
+		l.writePlainNode(&buf, node, opts)
+		if elementRef != nil && node.style.element() {
+			buf.WriteString(elementRef(l.elements[node.blob]))
+		}
+	}
+	if url != "" {
+		buf.WriteString(close(url))
+	}
+
+	return strings.TrimRight(buf.String(), " \t")
+}
+
+// longestRun returns the length of the longest run of c in s.
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != c {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return longest
+}
@@ -0,0 +1,73 @@
+package terminal
+
+import "testing"
+
+func TestAsMarkdown(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		opts              []ScreenOption
+	}{
+		{
+			name:  "plain text",
+			input: "\x1b[31mhello\x1b[0m\nworld",
+			want:  "```\nhello\nworld\n```\n",
+		},
+		{
+			name:  "single backticks",
+			input: "run `make`",
+			want:  "```\nrun `make`\n```\n",
+		},
+		{
+			name:  "triple backticks",
+			input: "```go\nfmt.Println()\n```",
+			want:  "````\n```go\nfmt.Println()\n```\n````\n",
+		},
+		{
+			name:  "longer runs",
+			input: "a ````` b ``` c",
+			want:  "``````\na ````` b ``` c\n``````\n",
+		},
+		{
+			name:  "links dropped by default",
+			input: "see \x1b]8;;http://example.com\x1b\\here\x1b]8;;\x1b\\.",
+			want:  "```\nsee here.\n```\n",
+		},
+		{
+			name:  "links kept",
+			input: "see \x1b]8;;http://example.com\x1b\\here\x1b]8;;\x1b\\ or \x1b]8;;http://example.org\x1b\\there",
+			opts:  []ScreenOption{WithMarkdownLinks(true)},
+			want:  "```\nsee [here](http://example.com) or [there](http://example.org)\n```\n",
+		},
+		{
+			name:  "adjacent links",
+			input: "\x1b]8;;http://a\x1b\\a\x1b]8;;http://b\x1b\\b\x1b]8;;\x1b\\",
+			opts:  []ScreenOption{WithMarkdownLinks(true)},
+			want:  "```\n[a](http://a)[b](http://b)\n```\n",
+		},
+		{
+			name:  "word wrapping",
+			input: "hello world foo bar baz",
+			opts:  []ScreenOption{WithSize(10, 5), WithWrapMode(WrapWord)},
+			want:  "```\nhello\nworld foo\nbar baz\n```\n",
+		},
+		{
+			name:  "plain text links",
+			input: "see \x1b]8;;http://example.com\x1b\\here\x1b]8;;\x1b\\.",
+			opts:  []ScreenOption{WithPlainTextLinks(PlainLinksFootnotes)},
+			want:  "```\nsee here[1].\n\n[1] http://example.com\n```\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsMarkdown(); got != test.want {
+				t.Errorf("AsMarkdown() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package terminal
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// mergeMetadata merges data into the line's metadata in the namespace,
+// replacing the values of any keys that are already set.
+func (l *screenLine) mergeMetadata(namespace string, data map[string]string) {
+	if l.metadata == nil {
+		l.metadata = map[string]map[string]string{
+			namespace: data,
+		}
+		return
+	}
+
+	ns := l.metadata[namespace]
+	if ns == nil {
+		// namespace did not exist, set all data
+		l.metadata[namespace] = data
+		return
+	}
+
+	// copy new data over old data
+	for k, v := range data {
+		ns[k] = v
+	}
+}
+
+// LineMetadata returns a copy of the metadata of the line at the given row of
+// the screen buffer (including any lines above the window), keyed by
+// namespace. For example, Buildkite timestamps are in the "bk" namespace,
+// under "t". It returns nil if the line has no metadata, or the row is out of
+// range.
+func (s *Screen) LineMetadata(row int) map[string]map[string]string {
+	if row < 0 || row >= len(s.screen) || len(s.screen[row].metadata) == 0 {
+		return nil
+	}
+	md := make(map[string]map[string]string, len(s.screen[row].metadata))
+	for ns, data := range s.screen[row].metadata {
+		md[ns] = maps.Clone(data)
+	}
+	return md
+}
+
+// SetLineMetadata sets metadata on the line at the given row of the screen
+// buffer (including any lines above the window), in the namespace. The keys in
+// data are merged with any already set in the namespace. data is copied. It
+// returns false if the row is out of range.
+func (s *Screen) SetLineMetadata(row int, namespace string, data map[string]string) bool {
+	if row < 0 || row >= len(s.screen) {
+		return false
+	}
+	s.screen[row].mergeMetadata(namespace, maps.Clone(data))
+	return true
+}
+
+// WithMetadataAttributes adds the metadata in the given namespaces to each
+// line in the HTML output as data attributes, named data-namespace-key.
+// For example, a Buildkite timestamp becomes data-bk-t="1700000000000".
+// Metadata in other namespaces is not included, and neither are namespaces
+// and keys that don't make valid attribute names (only lowercase letters,
+// digits, - and _ are allowed). By default, no metadata attributes are added.
+func WithMetadataAttributes(namespaces ...string) ScreenOption {
+	return func(s *Screen) error {
+		s.render.metadataAttrs = slices.Clone(namespaces)
+		return nil
+	}
+}
+
+// appendMetadataAttrs appends the data attributes for the line's metadata in
+// the namespaces to b, in a stable order.
+func (b *outputBuffer) appendMetadataAttrs(metadata map[string]map[string]string, namespaces []string) {
+	for _, ns := range namespaces {
+		data := metadata[ns]
+		if len(data) == 0 || !validAttrNamePart(ns) {
+			continue
+		}
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			if validAttrNamePart(k) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			b.appendAttr("data-"+ns+"-"+k, data[k])
+		}
+	}
+}
+
+// validAttrNamePart reports if s is non-empty and only contains lowercase ASCII
+// letters, digits, - and _, so is safe to use in a data attribute name.
+func validAttrNamePart(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+}
@@ -0,0 +1,65 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLineMetadata(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b_bk;t=123\x07one\ntwo\nthree"))
+
+	if !s.SetLineMetadata(1, "app", map[string]string{"step": "build"}) {
+		t.Fatal("SetLineMetadata(1, ...) = false, want true")
+	}
+	if !s.SetLineMetadata(1, "app", map[string]string{"status": "ok"}) {
+		t.Fatal("SetLineMetadata(1, ...) = false, want true")
+	}
+	if s.SetLineMetadata(3, "app", map[string]string{"step": "test"}) {
+		t.Error("SetLineMetadata(3, ...) = true, want false")
+	}
+
+	tests := []struct {
+		row  int
+		want map[string]map[string]string
+	}{
+		{row: 0, want: map[string]map[string]string{"bk": {"t": "123"}}},
+		{row: 1, want: map[string]map[string]string{"app": {"step": "build", "status": "ok"}}},
+		{row: 2, want: nil},
+		{row: 3, want: nil},
+		{row: -1, want: nil},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(s.LineMetadata(test.row), test.want); diff != "" {
+			t.Errorf("LineMetadata(%d) diff (-got +want):\n%s", test.row, diff)
+		}
+	}
+
+	// The result is a copy.
+	s.LineMetadata(0)["bk"]["t"] = "456"
+	if got := s.LineMetadata(0)["bk"]["t"]; got != "123" {
+		t.Errorf("LineMetadata(0)[bk][t] = %q after modifying a copy, want %q", got, "123")
+	}
+}
+
+func TestWithMetadataAttributes(t *testing.T) {
+	s, err := NewScreen(WithMetadataAttributes("app", "bk", "Bad"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b_bk;t=123\x07one\ntwo\nthree"))
+	s.SetLineMetadata(1, "app", map[string]string{"step": "a&b", "status": "ok", "Bad Key": "x"})
+	s.SetLineMetadata(1, "other", map[string]string{"secret": "hidden"})
+	s.SetLineMetadata(2, "Bad", map[string]string{"k": "v"})
+
+	want := `<span data-bk-t="123"><time datetime="1970-01-01T00:00:00.123Z">1970-01-01T00:00:00.123Z</time>one</span>` + "\n" +
+		`<span data-app-status="ok" data-app-step="a&amp;b">two</span>` + "\n" +
+		`three`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
@@ -1,12 +1,21 @@
 package terminal
 
-var emptyNode = node{blob: ' '}
+// emptyNode is the node in cells that haven't been written to (or have been
+// erased). It is a space, but is marked so it can be told apart from a space
+// that was written (see WithBlankChar).
+var emptyNode = node{blob: ' ', style: style{flags: sbEmpty}}
 
 // node represents an item in the screen. Most of the time, it is a single rune
 // which may or may not have a style (colour, bold, etc).
 // Sometimes it is a HTML element (e.g. from an inline image). This is encoded
 // by using style.element() == true and using blob as the index into a slice of
 // elements stored in the line.
+// Similarly, when a cell holds a grapheme cluster of several runes (such as a
+// letter with combining accents, or an emoji ZWJ sequence), style.cluster() is
+// true and blob is an index into a slice of strings stored in the line.
+// Wide characters occupy two nodes, and preserved tabs occupy a node for each
+// cell up to the next tab stop. The nodes after the first have style.cont()
+// set, and are otherwise ignored when rendering.
 type node struct {
 	blob  rune
 	style style
@@ -14,5 +23,5 @@ type node struct {
 
 // hasSameStyle reports if the two nodes have the same style.
 func (n *node) hasSameStyle(o node) bool {
-	return n.style&styleComparisonMask == o.style&styleComparisonMask
+	return n.style.rendered() == o.style.rendered()
 }
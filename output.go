@@ -1,30 +1,471 @@
 package terminal
 
 import (
+	"fmt"
 	"html"
 	"html/template"
+	"io"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
-var (
-	timeTagImpl = template.Must(template.New("time").Parse(
-		`<time datetime="{{.}}">{{.}}</time>`,
-	))
+var timeTagImpl = template.Must(template.New("time").Parse(
+	`<time datetime="{{.}}">{{.}}</time>`,
+))
 
-	openSpanTagTmpl = template.Must(template.New("span").Parse(
-		`<span class="{{.}}">`,
-	))
-)
+// renderOptions holds options that affect how the screen is rendered, but not
+// how input is processed.
+type renderOptions struct {
+	// ARIA role given to the wrapper by AsHTMLDocument, if not empty (see
+	// WithAccessibility)
+	role string
+
+	// Label lines with their Buildkite timestamp (see WithAccessibility)
+	timestampLabels bool
+
+	// Render palette colours using CSS custom properties (see
+	// WithCSSVariables)
+	cssVariables bool
+
+	// Minimum contrast ratio of foreground colours, if not 0 (see
+	// WithMinContrast)
+	minContrast float64
+
+	// Render faint, normal and bold text with these numeric font weights
+	// (see WithFontWeights)
+	fontWeights                         bool
+	dimWeight, normalWeight, boldWeight int
+
+	// Skip blank lines at the start of the buffer (see
+	// WithTrimLeadingBlankLines)
+	trimLeadingBlankLines bool
+
+	// Link URLs found in the text (see WithAutoLink). If autoLinkPattern is
+	// nil, defaultAutoLinkPattern is used.
+	autoLink        bool
+	autoLinkPattern *regexp.Regexp
+
+	// Maximum size of HTML output, if positive (see WithMaxHTMLBytes)
+	maxHTMLBytes int
+
+	// Character for empty cells, if not 0 (see WithBlankChar)
+	blankChar rune
+
+	// Prefix of line ids, which are only added if not empty (see WithLineIDs)
+	lineIDPrefix string
+
+	// Add a gutter with line numbers, counting from lineNumberStart (see
+	// WithLineNumbers)
+	lineNumbers     bool
+	lineNumberStart int
+
+	// Class for the line containing the cursor, if not empty (see
+	// WithCurrentLineClass)
+	currentLineClass string
+
+	// Add classes for shell integration regions (see WithShellRegionClasses)
+	shellRegionClasses bool
+
+	// Returns a class for each line, if not nil (see WithLineClassifier)
+	lineClassifier func(plainText string) string
+
+	// Metadata namespaces to add as data attributes (see
+	// WithMetadataAttributes)
+	metadataAttrs []string
+
+	// Text for elements in plain text output, if not nil (see
+	// WithElementPlaceholder)
+	elementPlaceholder func(ElementInfo) string
+
+	// Minimum number of copies of a block of lines to fold, if not 0 (see
+	// WithRepeatFolding)
+	foldMinRepeats int
+
+	// Keep OSC 8 links as Markdown links in AsMarkdown (see
+	// WithMarkdownLinks)
+	markdownLinks bool
+
+	// End output with a newline (see WithTrailingNewline)
+	trailingNewline bool
+
+	// How links are rendered in AsPlainText (see WithPlainTextLinks)
+	plainLinks PlainLinkMode
+
+	// How wrapped lines are rendered (see WithWrapMode)
+	wrapMode WrapMode
+}
+
+// WithAccessibility enables ARIA attributes in the HTML output, which are off
+// by default. If role is not empty, AsHTMLDocument gives the wrapper that role
+// (unless WrapperOptions sets one), such as "log", which makes the output a
+// live region. Output without a wrapper, such as AsHTML, doesn't include it.
+// If timestampLabels is true, lines that have a Buildkite timestamp are given
+// role="group" and an aria-label containing the timestamp, so that screen
+// readers can announce it. (ARIA doesn't allow labelling a span without a
+// role.)
+func WithAccessibility(role string, timestampLabels bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.role = role
+		s.render.timestampLabels = timestampLabels
+		return nil
+	}
+}
+
+// WithCSSVariables changes how colours are rendered in HTML output. When
+// enabled, the 16 base ANSI colours (whether set with basic SGR codes or as
+// the first 16 entries of the 256-colour palette) are rendered as inline CSS
+// custom properties, e.g. color:var(--ansi-red), instead of classes. This
+// allows a stylesheet to re-theme output by redefining the variables. Other
+// 256-colour palette entries and 24-bit colours are rendered as literal
+// #rrggbb values.
+func WithCSSVariables(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.cssVariables = enabled
+		return nil
+	}
+}
+
+// WithFontWeights renders the intensity of styled text as numeric CSS font
+// weights, as inline font-weight declarations: dim for faint text (SGR 2),
+// bold for bold text (SGR 1), and normal for other styled text. Text is never
+// both bold and faint, since SGR 1 and 2 each cancel the other: the later one
+// sets the weight, so "ESC [ 1 ; 2 m" is faint. Since the normal weight is the
+// CSS default, it is only declared if it isn't 400, and only on styled text;
+// unstyled text isn't wrapped in a span, so it takes its weight from the
+// surrounding page. The weights must be from 1 to 1000. By default, font
+// weights are left to the classes in the stylesheet.
+func WithFontWeights(dim, normal, bold int) ScreenOption {
+	return func(s *Screen) error {
+		for _, w := range []int{dim, normal, bold} {
+			if w < 1 || w > 1000 {
+				return fmt.Errorf("invalid font weight %d", w)
+			}
+		}
+		s.render.fontWeights = true
+		s.render.dimWeight, s.render.normalWeight, s.render.boldWeight = dim, normal, bold
+		return nil
+	}
+}
+
+// WithTrimLeadingBlankLines enables or disables skipping blank lines at the
+// start of the buffer in AsHTML and AsPlainText. A line is blank if it
+// contains nothing but unstyled spaces. Since the HTML output includes
+// Buildkite timestamps, blank lines with a timestamp are kept in HTML output
+// (but not plain text output). The buffer itself is unchanged.
+func WithTrimLeadingBlankLines(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.trimLeadingBlankLines = enabled
+		return nil
+	}
+}
+
+// WithLineIDs gives each line in the HTML output an id attribute: the prefix,
+// followed by the line's absolute line number. Line numbers start at 1 for the
+// first line ever written, and continue across lines scrolled out of the
+// buffer (see ScrollOutFunc), so a line keeps the same id from one render to
+// the next, even once lines above it have scrolled out. The number is the
+// same as ScrollOutLine.Number when the line is scrolled out. If prefix is
+// empty (the default), no ids are added.
+func WithLineIDs(prefix string) ScreenOption {
+	return func(s *Screen) error {
+		s.render.lineIDPrefix = prefix
+		return nil
+	}
+}
+
+// WithLineNumbers adds a gutter to each line in the HTML output, showing the
+// line's absolute line number: start for the first line ever written, and
+// continuing across lines scrolled out of the buffer, like the numbers of
+// WithLineIDs. The gutter is a <span class="term-lineno"> at the start of the
+// line, which the bundled stylesheet right-aligns and makes unselectable, so
+// that the numbers aren't copied along with the text. It is also hidden from
+// screen readers. Plain text output is unaffected.
+func WithLineNumbers(start int) ScreenOption {
+	return func(s *Screen) error {
+		s.render.lineNumbers = true
+		s.render.lineNumberStart = start
+		return nil
+	}
+}
+
+// WithElementPlaceholder sets a function giving the text that elements (such
+// as inline images) are rendered as in plain text output, such as
+// AsPlainText. For example, it might return the alt text of an image, or
+// "[image]". Without it, elements are left out of plain text.
+func WithElementPlaceholder(f func(ElementInfo) string) ScreenOption {
+	return func(s *Screen) error {
+		s.render.elementPlaceholder = f
+		return nil
+	}
+}
+
+// WithCurrentLineClass gives the line containing the cursor a class in the
+// HTML output, such as "term-current", so that a viewer can style the line
+// that is being written. After a final newline, the cursor is on a line below
+// the content that hasn't been written yet, so no line has the class. If class
+// is empty (the default), no class is added.
+func WithCurrentLineClass(class string) ScreenOption {
+	return func(s *Screen) error {
+		s.render.currentLineClass = class
+		return nil
+	}
+}
+
+// WithLineClassifier sets a func that is called with the plain text of each
+// line when rendering HTML, and returns a class to give the line, such as
+// "level-error" for a line containing "ERROR", or "" for none. This lets a
+// viewer highlight lines by their content, even if the program didn't use
+// colour. The classes are added to those from other options.
+func WithLineClassifier(f func(plainText string) string) ScreenOption {
+	return func(s *Screen) error {
+		s.render.lineClassifier = f
+		return nil
+	}
+}
+
+// WithBlankChar sets the character that empty cells (those that haven't been
+// written to, or have been erased) are rendered as in HTML and plain text
+// output, instead of a space. For example, '·' makes the layout visible, and
+// '\u00a0' (a non-breaking space) stops the cells collapsing in HTML that isn't
+// preformatted. Spaces that were written are still rendered as spaces, and
+// empty cells at the end of a line are not rendered at all. Control
+// characters are not allowed.
+func WithBlankChar(r rune) ScreenOption {
+	return func(s *Screen) error {
+		if !unicode.IsPrint(r) && r != '\u00a0' {
+			return fmt.Errorf("blank character %q is not printable", r)
+		}
+		s.render.blankChar = r
+		return nil
+	}
+}
+
+// contentNodes returns the nodes of the line to render: all of them, unless
+// a blank character is set, in which case trailing empty cells are left out.
+func (l *screenLine) contentNodes(opts *renderOptions) []node {
+	if opts.blankChar == 0 {
+		return l.nodes
+	}
+	end := len(l.nodes)
+	for end > 0 && l.nodes[end-1].style.empty() {
+		end--
+	}
+	return l.nodes[:end]
+}
+
+// nodeRune returns the rune to render for a (non-element, non-cluster) node.
+func (opts *renderOptions) nodeRune(n node) rune {
+	if n.style.empty() && opts.blankChar != 0 {
+		return opts.blankChar
+	}
+	return n.blob
+}
+
+// WithTrailingNewline sets whether HTML and plain text output ends with a
+// newline after the last line, like a text file. By default lines are only
+// separated by newlines, so the output doesn't end with one, whether or not
+// the input did. An empty screen renders as "" either way.
+func WithTrailingNewline(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.trailingNewline = enabled
+		return nil
+	}
+}
+
+// joinLines joins rendered plain text lines with newlines, adding a trailing
+// newline if enabled (see WithTrailingNewline).
+func (opts *renderOptions) joinLines(lines []string) string {
+	text := strings.Join(lines, "\n")
+	if opts.trailingNewline && len(lines) > 0 {
+		text += "\n"
+	}
+	return text
+}
+
+// WithMaxHTMLBytes limits the size of the HTML output of AsHTML, WriteHTMLTo,
+// TailHTML and AsHTMLDocument to about n bytes. Once the next line would
+// take the output over n bytes, rendering stops, and htmlTruncationNotice is
+// written (on its own line) instead. So the output is never more than
+// len(htmlTruncationNotice)+2 bytes over n: the notice, a newline before it,
+// and one after it with WithTrailingNewline (and, if the notice cuts a fold
+// short, the fold's closing tag). If n is 0 or negative (the default), the
+// output size is unlimited. The buffer itself is unchanged.
+func WithMaxHTMLBytes(n int) ScreenOption {
+	return func(s *Screen) error {
+		s.render.maxHTMLBytes = n
+		return nil
+	}
+}
+
+// htmlTruncationNotice is written in place of the rest of the HTML output once
+// the limit set by WithMaxHTMLBytes is reached.
+const htmlTruncationNotice = `<span class="term-truncated">[output truncated]</span>`
+
+// htmlLinesOptions vary how writeHTMLLines renders lines, for the variants of
+// AsHTML. If runs, marks or clip are set, lines are rendered as they are in
+// the buffer, even with WrapWord, since they all refer to the cells of the
+// buffer.
+type htmlLinesOptions struct {
+	// If not nil, the runs of text in the output are appended to runs (see
+	// WriteHTMLWithRuns).
+	runs *[]HTMLRun
+
+	// Ranges of cells to highlight, merged, by line (see
+	// HTMLWithHighlights).
+	marks map[int][]Range
+
+	// If clip is set, lines are clipped to the columns from clipStart up to
+	// (not including) clipEnd (see HTMLViewport).
+	clip               bool
+	clipStart, clipEnd int
+
+	// If renumber is set, line i of the buffer is numbered numberBase+i in
+	// its gutter (with WithLineNumbers), and in its id if idPrefix isn't
+	// empty (see Transcript). Otherwise lines are numbered by their position
+	// in the whole output.
+	renumber   bool
+	numberBase int
+	idPrefix   string
+}
+
+// lineLabels returns the id attribute and line number gutter of line i of the
+// buffer.
+func (s *Screen) lineLabels(i int, o *htmlLinesOptions) (id, gutter string) {
+	if !o.renumber {
+		return s.lineID(i), s.lineGutter(i)
+	}
+	number := strconv.Itoa(o.numberBase + i)
+	id = s.lineID(i)
+	if o.idPrefix != "" {
+		id = o.idPrefix + number
+	}
+	if s.render.lineNumbers {
+		gutter = gutterHTML(number)
+	}
+	return id, gutter
+}
+
+// writeHTMLLines writes the lines of the screen buffer from start onwards to
+// w as HTML, separated by newlines, stopping early if the output limit is
+// reached. It returns the number of bytes written.
+func (s *Screen) writeHTMLLines(w io.Writer, start int, o htmlLinesOptions) (int64, error) {
+	var written int64
+	write := func(str string) error {
+		n, err := io.WriteString(w, str)
+		written += int64(n)
+		return err
+	}
+
+	folds := s.findFolds(start)
+	inFold := false
+	var lineRuns []htmlRun
+	for i := start; i < len(s.screen); i++ {
+		// The last line of the buffer rendered here: i, unless the lines
+		// after it are rewrapped along with it.
+		end := i
+		var line string
+		switch {
+		case o.clip:
+			line = s.clippedLineHTML(i, o.clipStart, o.clipEnd)
+		case o.runs != nil:
+			line, lineRuns = s.lineHTMLWithRuns(i, o.marks[i], lineRuns[:0])
+		case o.marks != nil:
+			line = s.lineHTML(i, o.marks[i])
+		case s.render.wrapMode == WrapWord:
+			end = s.logicalEnd(i)
+			line = s.wordWrappedHTML(i, end, &o)
+		default:
+			id, gutter := s.lineLabels(i, &o)
+			line = s.wrapLineHTML(i, id, gutter, s.screen[i].asHTML(&s.render, nil))
+		}
+		// Anything written before the line, which offsets its runs.
+		var prefix string
+		if i > start {
+			prefix = "\n"
+		}
+		if len(folds) > 0 && i <= folds[0].start && folds[0].start <= end {
+			prefix += folds[0].open()
+			inFold = true
+		}
+		line = prefix + line
+		if inFold && folds[0].end-1 <= end {
+			line += foldClose
+		}
+		size := int64(len(line))
+		if s.render.trailingNewline && end == len(s.screen)-1 {
+			// The trailing newline is part of the last line's budget.
+			size++
+		}
+		if limit := s.render.maxHTMLBytes; limit > 0 && written+size > int64(limit) {
+			notice := htmlTruncationNotice
+			if i > start {
+				notice = "\n" + notice
+			}
+			if inFold && i > folds[0].start {
+				// Close the fold, so the notice isn't hidden in it.
+				notice = foldClose + notice
+			}
+			if s.render.trailingNewline {
+				notice += "\n"
+			}
+			return written, write(notice)
+		}
+		for _, r := range lineRuns {
+			*o.runs = append(*o.runs, HTMLRun{
+				Offset: written + int64(len(prefix)+r.offset),
+				Range:  Range{Line: i, Start: r.start, End: r.end},
+			})
+		}
+		if err := write(line); err != nil {
+			return written, err
+		}
+		if inFold && folds[0].end-1 <= end {
+			folds, inFold = folds[1:], false
+		}
+		i = end
+	}
+	if s.render.trailingNewline && start < len(s.screen) {
+		return written, write("\n")
+	}
+	return written, nil
+}
+
+// firstRenderedLine returns the index of the first line to render in AsHTML
+// (if html is true) or AsPlainText.
+func (s *Screen) firstRenderedLine(html bool) int {
+	if !s.render.trimLeadingBlankLines {
+		return 0
+	}
+	for i := range s.screen {
+		line := &s.screen[i]
+		if !line.isBlank() {
+			return i
+		}
+		if _, ok := line.metadata[bkNamespace]; ok && html {
+			return i
+		}
+	}
+	return len(s.screen)
+}
 
 type outputBuffer struct {
-	buf strings.Builder
+	buf  strings.Builder
+	opts *renderOptions
 }
 
 func (b *outputBuffer) appendNodeStyle(n node) {
-	openSpanTagTmpl.Execute(&b.buf, strings.Join(n.style.asClasses(), " "))
+	b.buf.WriteString("<span")
+	if classes := n.style.asClasses(b.opts); len(classes) > 0 {
+		b.appendAttr("class", strings.Join(classes, " "))
+	}
+	if css := n.style.asInlineCSS(b.opts); css != "" {
+		b.appendAttr("style", css)
+	}
+	b.buf.WriteString(">")
 }
 
 func (b *outputBuffer) closeStyle() {
@@ -41,21 +482,75 @@ func (b *outputBuffer) closeAnchor() {
 	b.buf.WriteString("</a>")
 }
 
+func (b *outputBuffer) appendMark() {
+	b.buf.WriteString(`<mark class="term-highlight">`)
+}
+
+func (b *outputBuffer) closeMark() {
+	b.buf.WriteString("</mark>")
+}
+
+func (b *outputBuffer) appendTooltip(title string) {
+	b.buf.WriteString("<span")
+	b.appendAttr("title", title)
+	b.buf.WriteString(">")
+}
+
+func (b *outputBuffer) closeTooltip() {
+	b.buf.WriteString("</span>")
+}
+
 func (b *outputBuffer) appendMeta(namespace string, data map[string]string) {
 	// We only support the bk namespace and a well-formed millisecond epoch.
 	if namespace != bkNamespace {
 		return
 	}
-	millis, err := strconv.ParseInt(data["t"], 10, 64)
-	if err != nil {
+	datetime, ok := bkDatetime(data)
+	if !ok {
 		return
 	}
-	time := time.Unix(millis/1000, (millis%1000)*1_000_000).UTC()
-	// One of the formats accepted by the <time> tag:
-	datetime := time.Format("2006-01-02T15:04:05.999Z")
 	timeTagImpl.Execute(&b.buf, datetime)
 }
 
+// bkDatetime formats the Buildkite timestamp in data (a millisecond epoch) in
+// one of the formats accepted by the <time> tag.
+func bkDatetime(data map[string]string) (string, bool) {
+	millis, ok := bkMillis(data)
+	if !ok {
+		return "", false
+	}
+	time := time.Unix(millis/1000, (millis%1000)*1_000_000).UTC()
+	return time.Format("2006-01-02T15:04:05.999Z"), true
+}
+
+// bkMillis returns the Buildkite timestamp in data, a millisecond epoch.
+func bkMillis(data map[string]string) (int64, bool) {
+	millis, err := strconv.ParseInt(data["t"], 10, 64)
+	return millis, err == nil
+}
+
+// commentEscaper escapes text to go in an HTML comment. Escaping < and >
+// means it can't end the comment early (-->, --!>) or look like the start of
+// a nested one (<!--).
+var commentEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// appendComment appends text to the buffer as an HTML comment.
+func (b *outputBuffer) appendComment(text string) {
+	b.buf.WriteString("<!-- ")
+	commentEscaper.WriteString(&b.buf, text)
+	b.buf.WriteString(" -->")
+}
+
+// appendAttr appends an attribute to the buffer (including a leading space),
+// escaping the value.
+func (b *outputBuffer) appendAttr(name, value string) {
+	b.buf.WriteByte(' ')
+	b.buf.WriteString(name)
+	b.buf.WriteString(`="`)
+	b.buf.WriteString(html.EscapeString(value))
+	b.buf.WriteByte('"')
+}
+
 // Append a character to our outputbuffer, escaping HTML bits as necessary.
 func (b *outputBuffer) appendChar(char rune) {
 	switch char {
@@ -76,23 +571,166 @@ func (b *outputBuffer) appendChar(char rune) {
 	}
 }
 
-// asHTML returns the line with HTML formatting.
-func (l *screenLine) asHTML() string {
-	var lineBuf outputBuffer
+// lineHTML returns the line at index i in the screen buffer with HTML
+// formatting, wrapped in a span if any per-line attributes are needed. marks
+// are the (sorted, non-overlapping) ranges within the line to highlight.
+func (s *Screen) lineHTML(i int, marks []Range) string {
+	return s.lineHTMLWithID(i, s.lineID(i), marks)
+}
+
+// lineID returns the id attribute of the line at row i from WithLineIDs, or ""
+// if line ids are off.
+func (s *Screen) lineID(i int) string {
+	if s.render.lineIDPrefix == "" {
+		return ""
+	}
+	return s.render.lineIDPrefix + strconv.Itoa(s.LinesScrolledOut+i+1)
+}
+
+// lineHTMLWithID is lineHTML, but with the given id attribute (if not empty)
+// instead of the one from WithLineIDs.
+func (s *Screen) lineHTMLWithID(i int, id string, marks []Range) string {
+	return s.wrapLineHTML(i, id, s.lineGutter(i), s.screen[i].asHTML(&s.render, marks))
+}
+
+// wrapLineHTML wraps the rendered contents of the line at row i, after the
+// gutter, in a span with the line's attributes (id, classes, and so on), if it
+// has any.
+func (s *Screen) wrapLineHTML(i int, id, gutter, contents string) string {
+	open := s.lineOpenTag(i, id)
+	if open == "" {
+		return gutter + contents
+	}
+	return open + gutter + contents + "</span>"
+}
+
+// lineGutter returns the line number gutter of the line at row i (see
+// WithLineNumbers), or "" if line numbers are off.
+func (s *Screen) lineGutter(i int) string {
+	if !s.render.lineNumbers {
+		return ""
+	}
+	return gutterHTML(strconv.Itoa(s.render.lineNumberStart + s.LinesScrolledOut + i))
+}
+
+// gutterHTML returns a line number gutter showing number.
+func gutterHTML(number string) string {
+	return `<span class="term-lineno" aria-hidden="true">` + number + "</span>"
+}
+
+// lineOpenTag returns the opening tag of the span wrapping the line at row i
+// (see wrapLineHTML), or "" if the line has no attributes.
+func (s *Screen) lineOpenTag(i int, id string) string {
+	line := &s.screen[i]
+
+	var attrs outputBuffer
+	if id != "" {
+		attrs.appendAttr("id", id)
+	}
+	var classes []string
+	if s.render.currentLineClass != "" && i == s.top()+s.y {
+		classes = append(classes, s.render.currentLineClass)
+	}
+	if s.render.shellRegionClasses {
+		if class := line.shellRegionClass(); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	if s.render.lineClassifier != nil {
+		if class := s.render.lineClassifier(line.asPlain(&s.render)); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	if len(classes) > 0 {
+		attrs.appendAttr("class", strings.Join(classes, " "))
+	}
+	if s.render.timestampLabels {
+		if datetime, ok := bkDatetime(line.metadata[bkNamespace]); ok {
+			attrs.appendAttr("role", "group")
+			attrs.appendAttr("aria-label", datetime)
+		}
+	}
+	attrs.appendMetadataAttrs(line.metadata, s.render.metadataAttrs)
+
+	if attrs.buf.Len() == 0 {
+		return ""
+	}
+	return "<span" + attrs.buf.String() + ">"
+}
+
+// asHTML returns the line with HTML formatting. Nodes within marks (which must
+// be sorted and non-overlapping) are highlighted.
+func (l *screenLine) asHTML(opts *renderOptions, marks []Range) string {
+	return l.asHTMLWithRuns(opts, marks, nil)
+}
+
+// htmlRun is a run of nodes rendered as contiguous text (with no tags in
+// between) in the HTML of a line.
+type htmlRun struct {
+	offset     int // byte offset of the start of the run in the line's HTML
+	start, end int // nodes in the run, from start up to (not including) end
+}
+
+// asHTMLWithRuns is asHTML, but if runs is not nil, it also appends the runs
+// of text in the output to it.
+func (l *screenLine) asHTMLWithRuns(opts *renderOptions, marks []Range, runs *[]htmlRun) string {
+	lineBuf := outputBuffer{opts: opts}
 
 	if data, ok := l.metadata[bkNamespace]; ok {
 		lineBuf.appendMeta(bkNamespace, data)
 	}
+	for _, seq := range l.ignored {
+		lineBuf.appendComment(seq)
+	}
 
 	// tagStack is used as a stack of open tags, so they can be closed in the
-	// right order. We only have two kinds of tag, so the stack should be tiny,
-	// but the algorithm can be extended later if needed.
-	tagStack := make([]int, 0, 2)
+	// right order. We only have a few kinds of tag, so the stack should be
+	// tiny, but the algorithm can be extended later if needed.
+	tagStack := make([]int, 0, 4)
 	const (
 		tagAnchor = iota
 		tagSpan
+		tagMark
+		tagTooltip
 	)
 
+	autoLinks := l.autoLinks(opts)
+
+	// linkAt returns the target of the link (either an OSC 8 link or an
+	// automatic link) covering x, or "" if x isn't linked.
+	linkAt := func(x int) string {
+		if x < 0 {
+			return ""
+		}
+		if l.nodes[x].style.hyperlink() {
+			return l.hyperlinks[x]
+		}
+		for _, al := range autoLinks {
+			if al.start <= x && x < al.end {
+				return al.url
+			}
+		}
+		return ""
+	}
+
+	// tooltipAt returns the tooltip text covering x, or "" if x has no
+	// tooltip.
+	tooltipAt := func(x int) string {
+		if x < 0 || !l.nodes[x].style.tooltip() {
+			return ""
+		}
+		return l.tooltips[x]
+	}
+
+	// markAt returns the index of the mark containing x, or -1 if x is not
+	// highlighted.
+	markAt := func(x int) int {
+		if x < 0 {
+			return -1
+		}
+		return slices.IndexFunc(marks, func(r Range) bool { return r.Start <= x && x < r.End })
+	}
+
 	// Close tags in the stack, starting at idx. They're closed in the reverse
 	// order they were opened.
 	closeFrom := func(idx int) {
@@ -102,12 +740,29 @@ func (l *screenLine) asHTML() string {
 				lineBuf.closeAnchor()
 			case tagSpan:
 				lineBuf.closeStyle()
+			case tagMark:
+				lineBuf.closeMark()
+			case tagTooltip:
+				lineBuf.closeTooltip()
 			}
 		}
 		tagStack = tagStack[:idx]
 	}
 
-	for x, current := range l.nodes {
+	// Where the text of the current run ends in the output, if a new run
+	// hasn't been started since (by writing a tag or an element).
+	runEnd := -1
+
+	for x, current := range l.contentNodes(opts) {
+		// Continuation nodes (the second half of a wide character, or the
+		// rest of a tab) are rendered with the node they continue.
+		if current.style.cont() {
+			if runs != nil && len(*runs) > 0 && (*runs)[len(*runs)-1].end == x {
+				(*runs)[len(*runs)-1].end = x + 1
+			}
+			continue
+		}
+
 		// The zero value for node has a plain style and no hyperlink.
 		var previous node
 		// If we're past the first node in the line, there is a previous node
@@ -118,14 +773,19 @@ func (l *screenLine) asHTML() string {
 
 		// A set of flags for which tags need changing.
 		tagChanged := []bool{
-			// The anchor tag needs changing if the link "style" has changed,
-			// or if they are both links the link URLs are different.
-			// (Note that the x-1 index into the hyperlinks map returns "".)
-			tagAnchor: current.style.hyperlink() != previous.style.hyperlink() ||
-				(current.style.hyperlink() && l.hyperlinks[x-1] != l.hyperlinks[x]),
+			// The anchor tag needs changing if the link target has changed
+			// (including to or from not being linked).
+			tagAnchor: linkAt(x) != linkAt(x-1),
 
 			// The span tag needs changing if the style has changed.
 			tagSpan: !current.hasSameStyle(previous),
+
+			// The mark tag needs changing if the node is in a different mark
+			// (or is no longer highlighted).
+			tagMark: markAt(x) != markAt(x-1),
+
+			// The tooltip tag needs changing if the tooltip text has changed.
+			tagTooltip: tooltipAt(x) != tooltipAt(x-1),
 		}
 
 		// Go forward through the stack of open tags, looking for the first
@@ -143,12 +803,24 @@ func (l *screenLine) asHTML() string {
 		closeFrom(closeFromIdx)
 
 		// Now open new tags as needed.
+		// Open a new mark tag, if one is not already open and this node is
+		// highlighted.
+		if !slices.Contains(tagStack, tagMark) && markAt(x) >= 0 {
+			lineBuf.appendMark()
+			tagStack = append(tagStack, tagMark)
+		}
 		// Open a new anchor tag, if one is not already open and this node is
-		// hyperlinked.
-		if !slices.Contains(tagStack, tagAnchor) && current.style.hyperlink() {
-			lineBuf.appendAnchor(l.hyperlinks[x])
+		// linked.
+		if url := linkAt(x); !slices.Contains(tagStack, tagAnchor) && url != "" {
+			lineBuf.appendAnchor(url)
 			tagStack = append(tagStack, tagAnchor)
 		}
+		// Open a new tooltip tag, if one is not already open and this node
+		// has a tooltip.
+		if title := tooltipAt(x); !slices.Contains(tagStack, tagTooltip) && title != "" {
+			lineBuf.appendTooltip(title)
+			tagStack = append(tagStack, tagTooltip)
+		}
 		// Open a new span tag, if one is not already open and this node has
 		// style.
 		if !slices.Contains(tagStack, tagSpan) && !current.style.isPlain() {
@@ -156,11 +828,29 @@ func (l *screenLine) asHTML() string {
 			tagStack = append(tagStack, tagSpan)
 		}
 
-		// Write a standalone element or a rune.
-		if current.style.element() {
+		if runs != nil {
+			if offset := lineBuf.buf.Len(); offset == runEnd && !current.style.element() {
+				(*runs)[len(*runs)-1].end = x + 1
+			} else {
+				*runs = append(*runs, htmlRun{offset: offset, start: x, end: x + 1})
+			}
+		}
+
+		// Write a standalone element, a grapheme cluster, or a rune.
+		switch {
+		case current.style.element():
 			lineBuf.buf.WriteString(l.elements[current.blob].asHTML())
-		} else {
-			lineBuf.appendChar(current.blob)
+		case current.style.cluster():
+			for _, r := range l.graphemes[current.blob] {
+				lineBuf.appendChar(r)
+			}
+		default:
+			lineBuf.appendChar(opts.nodeRune(current))
+		}
+		runEnd = lineBuf.buf.Len()
+		if current.style.element() {
+			// The next node starts a new run.
+			runEnd = -1
 		}
 	}
 
@@ -168,20 +858,54 @@ func (l *screenLine) asHTML() string {
 	closeFrom(0)
 
 	line := strings.TrimRight(lineBuf.buf.String(), " \t")
+	if runs != nil {
+		// Drop runs that were entirely trimmed.
+		for len(*runs) > 0 && (*runs)[len(*runs)-1].offset >= len(line) {
+			*runs = (*runs)[:len(*runs)-1]
+		}
+	}
 	if line == "" {
 		return "&nbsp;"
 	}
 	return line
 }
 
+// isBlank reports if the line contains nothing but unstyled spaces and tabs
+// (including if it contains nothing at all).
+func (l *screenLine) isBlank() bool {
+	for _, n := range l.nodes {
+		if !n.style.isPlain() || n.style.flags&(sbElement|sbCluster|sbHyperlink) != 0 {
+			return false
+		}
+		if n.blob != ' ' && n.blob != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// writePlainNode writes the plain text of a node.
+func (l *screenLine) writePlainNode(buf *strings.Builder, n node, opts *renderOptions) {
+	switch {
+	case n.style.element():
+		if opts.elementPlaceholder != nil {
+			buf.WriteString(opts.elementPlaceholder(l.elements[n.blob].info()))
+		}
+	case n.style.cont():
+		// nothing
+	case n.style.cluster():
+		buf.WriteString(l.graphemes[n.blob])
+	default:
+		buf.WriteRune(opts.nodeRune(n))
+	}
+}
+
 // asPlain returns the line contents without any added HTML.
-func (l *screenLine) asPlain() string {
+func (l *screenLine) asPlain(opts *renderOptions) string {
 	var buf strings.Builder
 
-	for _, node := range l.nodes {
-		if !node.style.element() {
-			buf.WriteRune(node.blob)
-		}
+	for _, node := range l.contentNodes(opts) {
+		l.writePlainNode(&buf, node, opts)
 	}
 
 	return strings.TrimRight(buf.String(), " \t")
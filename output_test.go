@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -38,10 +39,741 @@ func TestScreenLineAsHTML_Interleaving(t *testing.T) {
 				t.Fatalf("len(s.screen) = %d, want 1", len(s.screen))
 			}
 
-			got := s.screen[0].asHTML()
+			got := s.screen[0].asHTML(&s.render, nil)
 			if diff := cmp.Diff(got, test.want); diff != "" {
 				t.Errorf("s.screen[0].asHTML diff (-got +want):\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestScreenAccessibility(t *testing.T) {
+	input := "\x1b_bk;t=123\x07hello\nworld"
+	timestamp := `<time datetime="1970-01-01T00:00:00.123Z">1970-01-01T00:00:00.123Z</time>`
+
+	tests := []struct {
+		name    string
+		opts    []ScreenOption
+		wrapper WrapperOptions
+		want    string
+	}{
+		{
+			name: "off by default",
+			want: `<pre class="term-container">` + timestamp + "hello\nworld</pre>",
+		},
+		{
+			name: "role only",
+			opts: []ScreenOption{WithAccessibility("log", false)},
+			want: `<pre class="term-container" role="log">` + timestamp + "hello\nworld</pre>",
+		},
+		{
+			name: "role and timestamp labels",
+			opts: []ScreenOption{WithAccessibility("log", true)},
+			want: `<pre class="term-container" role="log">` +
+				`<span role="group" aria-label="1970-01-01T00:00:00.123Z">` + timestamp + "hello</span>\nworld</pre>",
+		},
+		{
+			name: "timestamp labels only",
+			opts: []ScreenOption{WithAccessibility("", true)},
+			want: `<pre class="term-container">` +
+				`<span role="group" aria-label="1970-01-01T00:00:00.123Z">` + timestamp + "hello</span>\nworld</pre>",
+		},
+		{
+			name:    "wrapper role",
+			opts:    []ScreenOption{WithAccessibility("log", false)},
+			wrapper: WrapperOptions{Role: "region"},
+			want:    `<pre class="term-container" role="region">` + timestamp + "hello\nworld</pre>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.AsHTMLDocument(test.wrapper), test.want); diff != "" {
+				t.Errorf("AsHTMLDocument diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCSSVariables(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "basic colors",
+			input: "\x1b[31mred\x1b[0m \x1b[94;41mbright blue on red",
+			want:  `<span style="color:var(--ansi-red)">red</span> <span style="color:var(--ansi-bright-blue);background-color:var(--ansi-red)">bright blue on red</span>`,
+		},
+		{
+			name:  "base palette via 256 colors",
+			input: "\x1b[38;5;1mred\x1b[48;5;15m on white",
+			want:  `<span style="color:var(--ansi-red)">red</span><span style="color:var(--ansi-red);background-color:var(--ansi-bright-white)"> on white</span>`,
+		},
+		{
+			name:  "256 colors outside the base palette",
+			input: "\x1b[38;5;196mred\x1b[38;5;244m grey",
+			want:  `<span style="color:#ff0000">red</span><span style="color:#808080"> grey</span>`,
+		},
+		{
+			name:  "24-bit colors",
+			input: "\x1b[38;2;18;52;86mhex",
+			want:  `<span style="color:#123456">hex</span>`,
+		},
+		{
+			name:  "other attributes remain classes",
+			input: "\x1b[1;32mbold green",
+			want:  `<span class="term-fg1" style="color:var(--ansi-green)">bold green</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithCSSVariables(true))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFontWeights(t *testing.T) {
+	tests := []struct {
+		name              string
+		dim, normal, bold int
+		input, want       string
+	}{
+		{
+			name: "dim and bold",
+			dim:  300, normal: 400, bold: 700,
+			input: "\x1b[2mdim\x1b[0m plain \x1b[1mbold",
+			want:  `<span class="term-fg2" style="font-weight:300">dim</span> plain <span class="term-fg1" style="font-weight:700">bold</span>`,
+		},
+		{
+			name: "later of bold and dim wins",
+			dim:  300, normal: 400, bold: 700,
+			input: "\x1b[1;2mdim\x1b[2;1m bold\x1b[22;31m red",
+			want:  `<span class="term-fg2" style="font-weight:300">dim</span><span class="term-fg1" style="font-weight:700"> bold</span><span class="term-fg31"> red</span>`,
+		},
+		{
+			name: "normal weight on styled text",
+			dim:  200, normal: 450, bold: 800,
+			input: "\x1b[31mred\x1b[0m plain \x1b[2;38;2;18;52;86mhex",
+			want:  `<span class="term-fg31" style="font-weight:450">red</span> plain <span class="term-fg2" style="color:#123456;font-weight:200">hex</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithFontWeights(test.dim, test.normal, test.bold))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := NewScreen(WithFontWeights(0, 400, 700)); err == nil {
+		t.Error("NewScreen(WithFontWeights(0, 400, 700)) error = nil, want an error")
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		n        int
+		wantHTML string
+		wantText string
+	}{
+		{n: 0, wantHTML: "", wantText: ""},
+		{n: -1, wantHTML: "", wantText: ""},
+		{n: 1, wantHTML: `<span class="term-fg31">three</span>`, wantText: "three"},
+		{n: 2, wantHTML: "&nbsp;\n" + `<span class="term-fg31">three</span>`, wantText: "\nthree"},
+		{n: 3, wantHTML: "two\n&nbsp;\n" + `<span class="term-fg31">three</span>`, wantText: "two\n\nthree"},
+		{n: 10, wantHTML: "one\ntwo\n&nbsp;\n" + `<span class="term-fg31">three</span>`, wantText: "one\ntwo\n\nthree"},
+	}
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("one\ntwo\n\n\x1b[31mthree"))
+
+	for _, test := range tests {
+		if got := s.TailHTML(test.n); got != test.wantHTML {
+			t.Errorf("TailHTML(%d) = %q, want %q", test.n, got, test.wantHTML)
+		}
+		if got := s.TailText(test.n); got != test.wantText {
+			t.Errorf("TailText(%d) = %q, want %q", test.n, got, test.wantText)
+		}
+	}
+}
+
+func TestLineHTMLAndText(t *testing.T) {
+	tests := []struct {
+		row       int
+		wantHTML  string
+		wantText  string
+		wantFound bool
+	}{
+		{row: 0, wantHTML: "one", wantText: "one", wantFound: true},
+		{row: 1, wantHTML: "&nbsp;", wantText: "", wantFound: true},
+		{row: 2, wantHTML: `<span class="term-fg31">three</span>`, wantText: "three", wantFound: true},
+		{row: 3},
+		{row: -1},
+	}
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("one\n\n\x1b[31mthree"))
+
+	for _, test := range tests {
+		if got, found := s.LineHTML(test.row); got != test.wantHTML || found != test.wantFound {
+			t.Errorf("LineHTML(%d) = (%q, %t), want (%q, %t)", test.row, got, found, test.wantHTML, test.wantFound)
+		}
+		if got, found := s.LineText(test.row); got != test.wantText || found != test.wantFound {
+			t.Errorf("LineText(%d) = (%q, %t), want (%q, %t)", test.row, got, found, test.wantText, test.wantFound)
+		}
+	}
+}
+
+func TestTrimLeadingBlankLines(t *testing.T) {
+	input := "\n   \n\x1b_bk;t=1700000000000\x07\n\t\nhello\n\nworld\n"
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		wantHTML string
+		wantText string
+	}{
+		{
+			name:     "disabled",
+			wantHTML: "&nbsp;\n&nbsp;\n" + `<time datetime="2023-11-14T22:13:20Z">2023-11-14T22:13:20Z</time>` + "\n&nbsp;\nhello\n&nbsp;\nworld",
+			wantText: "\n\n\n\nhello\n\nworld",
+		},
+		{
+			name:    "enabled",
+			enabled: true,
+			// The timestamped line is kept in HTML, where it isn't blank.
+			wantHTML: `<time datetime="2023-11-14T22:13:20Z">2023-11-14T22:13:20Z</time>` + "\n&nbsp;\nhello\n&nbsp;\nworld",
+			wantText: "hello\n\nworld",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithTrimLeadingBlankLines(test.enabled))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.AsHTML(), test.wantHTML); diff != "" {
+				t.Errorf("AsHTML diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(s.AsPlainText(), test.wantText); diff != "" {
+				t.Errorf("AsPlainText diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTrimLeadingBlankLinesKeepsStyledSpaces(t *testing.T) {
+	s, err := NewScreen(WithTrimLeadingBlankLines(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\n\x1b[41m  \x1b[0m\nhello"))
+	want := `<span class="term-bg41">  </span>` + "\nhello"
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWithMaxHTMLBytes(t *testing.T) {
+	input := strings.Repeat("\x1b[32mline of output\x1b[0m\n", 50)
+
+	unlimited, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	unlimited.Write([]byte(input))
+	full := unlimited.AsHTML()
+
+	for _, limit := range []int{1, 10, 40, 100, 1000, len(full) - 1, len(full)} {
+		s, err := NewScreen(WithMaxHTMLBytes(limit))
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte(input))
+
+		got := s.AsHTML()
+		if bound := limit + len(htmlTruncationNotice) + 1; len(got) > bound {
+			t.Errorf("WithMaxHTMLBytes(%d): len(AsHTML()) = %d, want at most %d", limit, len(got), bound)
+		}
+		if limit == len(full) {
+			if got != full {
+				t.Errorf("WithMaxHTMLBytes(%d): AsHTML() = %q, want %q", limit, got, full)
+			}
+			continue
+		}
+		if !strings.HasSuffix(got, htmlTruncationNotice) {
+			t.Errorf("WithMaxHTMLBytes(%d): AsHTML() = %q, want suffix %q", limit, got, htmlTruncationNotice)
+		}
+		if kept := strings.TrimSuffix(strings.TrimSuffix(got, htmlTruncationNotice), "\n"); !strings.HasPrefix(full, kept) {
+			t.Errorf("WithMaxHTMLBytes(%d): AsHTML() = %q, want a prefix of the full output", limit, got)
+		}
+	}
+}
+
+func TestWithMaxHTMLBytesTrailingNewline(t *testing.T) {
+	input := "one\ntwo\nthree"
+	full := "one\ntwo\nthree\n"
+
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{limit: len(full), want: full},
+		{limit: len(full) - 1, want: "one\ntwo\n" + htmlTruncationNotice + "\n"},
+	}
+	for _, test := range tests {
+		s, err := NewScreen(WithMaxHTMLBytes(test.limit), WithTrailingNewline(true))
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte(input))
+		got := s.AsHTML()
+		if got != test.want {
+			t.Errorf("WithMaxHTMLBytes(%d): AsHTML() = %q, want %q", test.limit, got, test.want)
+		}
+		if bound := test.limit + len(htmlTruncationNotice) + 2; len(got) > bound {
+			t.Errorf("WithMaxHTMLBytes(%d): len(AsHTML()) = %d, want at most %d", test.limit, len(got), bound)
+		}
+	}
+}
+
+func TestWriteHTMLTo(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("one\n\x1b[31mtwo\x1b[0m\n\nfour"))
+
+	var buf strings.Builder
+	n, err := s.WriteHTMLTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteHTMLTo() error = %v", err)
+	}
+	if got, want := buf.String(), s.AsHTML(); got != want {
+		t.Errorf("WriteHTMLTo() wrote %q, want %q", got, want)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteHTMLTo() = %d, want %d", n, buf.Len())
+	}
+}
+
+func TestWithBlankChar(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantHTML string
+		wantText string
+	}{
+		{
+			name:     "skipped cells",
+			input:    "a\x1b[3Cb c",
+			wantHTML: "a···b c",
+			wantText: "a···b c",
+		},
+		{
+			name:     "erased cells",
+			input:    "abcdef\x1b[4G\x1b[1K\x1b[6G\x1b[31m!",
+			wantHTML: `····e<span class="term-fg31">!</span>`,
+			wantText: "····e!",
+		},
+		{
+			name:     "trailing empty cells",
+			input:    "abc\x1b[5Cx\x1b[1D\x1b[?K",
+			wantHTML: "abc",
+			wantText: "abc",
+		},
+		{
+			name:     "written spaces",
+			input:    "  \x1b[2C",
+			wantHTML: "&nbsp;",
+			wantText: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithBlankChar('·'))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.wantHTML {
+				t.Errorf("AsHTML() = %q, want %q", got, test.wantHTML)
+			}
+			if got := s.AsPlainText(); got != test.wantText {
+				t.Errorf("AsPlainText() = %q, want %q", got, test.wantText)
+			}
+		})
+	}
+}
+
+func TestWithBlankCharInvalid(t *testing.T) {
+	if _, err := NewScreen(WithBlankChar('\n')); err == nil {
+		t.Error("NewScreen(WithBlankChar('\\n')) error = nil, want an error")
+	}
+	if _, err := NewScreen(WithBlankChar('\u00a0')); err != nil {
+		t.Errorf("NewScreen(WithBlankChar('\\u00a0')) error = %v", err)
+	}
+}
+
+func TestWithLineIDs(t *testing.T) {
+	s, err := NewScreen(WithMaxSize(0, 3), WithLineIDs("L"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var scrolled []string
+	s.ScrollOutFunc = func(line string) { scrolled = append(scrolled, line) }
+
+	s.Write([]byte("one\ntwo\nthree"))
+	if got, want := s.AsHTML(), `<span id="L1">one</span>`+"\n"+`<span id="L2">two</span>`+"\n"+`<span id="L3">three</span>`; got != want {
+		t.Errorf("before scroll out: AsHTML() = %q, want %q", got, want)
+	}
+
+	// Line three keeps its id once the lines above it scroll out.
+	s.Write([]byte("\nfour\nfive"))
+	if got, want := s.AsHTML(), `<span id="L3">three</span>`+"\n"+`<span id="L4">four</span>`+"\n"+`<span id="L5">five</span>`; got != want {
+		t.Errorf("after scroll out: AsHTML() = %q, want %q", got, want)
+	}
+	want := []string{`<span id="L1">one</span>`, `<span id="L2">two</span>`}
+	if diff := cmp.Diff(scrolled, want); diff != "" {
+		t.Errorf("scrolled out lines diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestWithLineNumbers(t *testing.T) {
+	s, err := NewScreen(WithMaxSize(0, 3), WithLineNumbers(1), WithLineIDs("L"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var scrolled []string
+	s.ScrollOutFunc = func(line string) { scrolled = append(scrolled, line) }
+
+	gutter := func(n string) string { return `<span class="term-lineno" aria-hidden="true">` + n + "</span>" }
+	s.Write([]byte("one\ntwo\n\x1b[31mthree\x1b[0m\nfour\nfive"))
+	want := strings.Join([]string{
+		`<span id="L3">` + gutter("3") + `<span class="term-fg31">three</span></span>`,
+		`<span id="L4">` + gutter("4") + `four</span>`,
+		`<span id="L5">` + gutter("5") + `five</span>`,
+	}, "\n")
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+	wantScrolled := []string{
+		`<span id="L1">` + gutter("1") + `one</span>`,
+		`<span id="L2">` + gutter("2") + `two</span>`,
+	}
+	if diff := cmp.Diff(scrolled, wantScrolled); diff != "" {
+		t.Errorf("scrolled out lines diff (-got +want):\n%s", diff)
+	}
+	// The gutter is only in the HTML.
+	if got, want := s.AsPlainText(), "three\nfour\nfive"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
+
+func TestWithLineNumbersStart(t *testing.T) {
+	s, err := NewScreen(WithLineNumbers(0))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("zero\none"))
+	want := `<span class="term-lineno" aria-hidden="true">0</span>zero` + "\n" +
+		`<span class="term-lineno" aria-hidden="true">1</span>one`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWithElementPlaceholder(t *testing.T) {
+	input := "see \x1b]1338;url=http://example.com/a.gif;alt=a cat\x07 and \x1b]1339;url=http://example.com;content=docs\x07!"
+	placeholder := func(e ElementInfo) string {
+		if e.Image {
+			return "[image: " + e.Alt + "]"
+		}
+		return e.Text + " <" + e.URL + ">"
+	}
+
+	tests := []struct {
+		name string
+		opts []ScreenOption
+		want string
+	}{
+		{
+			name: "default",
+			want: "see\n\n and !",
+		},
+		{
+			name: "placeholder",
+			opts: []ScreenOption{WithElementPlaceholder(placeholder)},
+			// Images are rendered on a line of their own.
+			want: "see\n[image: a cat]\n and docs <http://example.com>!",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if got := s.AsPlainText(); got != test.want {
+				t.Errorf("AsPlainText() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTooltips(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "tooltip on a run",
+			input: "a \x1b]1340;title=added in abc123\x07tip\x1b]1340;\x07 b",
+			want:  `a <span title="added in abc123">tip</span> b`,
+		},
+		{
+			name:  "title is escaped",
+			input: "\x1b]1340;title=<b> & \\\"c\\\"\x07x",
+			want:  `<span title="&lt;b&gt; &amp; &#34;c&#34;">x</span>`,
+		},
+		{
+			name:  "quoted value with semicolon",
+			input: "\x1b]1340;title='one; two'\x07x\x1b]1340;title=\x07y",
+			want:  `<span title="one; two">x</span>y`,
+		},
+		{
+			name:  "styles change within a tooltip",
+			input: "\x1b]1340;title=t\x07a\x1b[31mb\x1b[0mc\x1b]1340;\x07",
+			want:  `<span title="t">a<span class="term-fg31">b</span>c</span>`,
+		},
+		{
+			name:  "tooltip changes within a style",
+			input: "\x1b[31m\x1b]1340;title=one\x07a\x1b]1340;title=two\x07b",
+			want:  `<span title="one"><span class="term-fg31">a</span></span><span title="two"><span class="term-fg31">b</span></span>`,
+		},
+		{
+			name:  "tooltip inside a link",
+			input: "\x1b]8;;http://example.com\x1b\\\x1b]1340;title=t\x07a\x1b]1340;\x07b\x1b]8;;\x1b\\",
+			want:  `<a href="http://example.com"><span title="t">a</span>b</a>`,
+		},
+		{
+			name:  "SGR 0 doesn't end a tooltip",
+			input: "\x1b]1340;title=t\x07a\x1b[0mb",
+			want:  `<span title="t">ab</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithCurrentLineClass(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "last line",
+			input: "one\ntwo",
+			want:  "one\n" + `<span class="term-current">two</span>`,
+		},
+		{
+			name:  "cursor moved up",
+			input: "one\ntwo\nthree\x1b[2A",
+			want:  `<span class="term-current">one</span>` + "\ntwo\nthree",
+		},
+		{
+			name:  "after a final newline",
+			input: "one\ntwo\n",
+			want:  "one\ntwo",
+		},
+		{
+			name:  "blank current line",
+			input: "one\n\n\nfour\x1b[2A",
+			want:  "one\n" + `<span class="term-current">&nbsp;</span>` + "\n&nbsp;\nfour",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithCurrentLineClass("term-current"))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithIgnoredAsComments(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "unsupported OSC",
+			input: "\x1b]777;notify;Build;done\x07hello",
+			want:  "<!-- osc:777;notify;Build;done -->hello",
+		},
+		{
+			name:  "comment end is escaped",
+			input: "\x1b]777;a-->b<!--c--!>\x07hello",
+			want:  "<!-- osc:777;a--&gt;b&lt;!--c--!&gt; -->hello",
+		},
+		{
+			name:  "markup is escaped",
+			input: "\x1b]777;<script>&amp;\x07hello",
+			want:  "<!-- osc:777;&lt;script&gt;&amp;amp; -->hello",
+		},
+		{
+			name:  "DCS",
+			input: "one\n\x1bPq#0;2;0;0;0\x1b\\two",
+			want:  "one\n<!-- dcs:q#0;2;0;0;0 -->two",
+		},
+		{
+			name:  "DECRQSS is answered, not kept",
+			input: "\x1bP$qm\x1b\\hello",
+			want:  "hello",
+		},
+		{
+			name:  "mouse reports",
+			input: "\x1b[?1000;1006h\x1b[<0;1;2M\x1b[0;1;2Mhello",
+			want:  "<!-- mouse:&lt;0;1;2 --><!-- mouse:0;1;2 -->hello",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithIgnoredAsComments(true))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+			if got := s.AsPlainText(); strings.Contains(got, "<!--") {
+				t.Errorf("AsPlainText() = %q, want no comments", got)
+			}
+		})
+	}
+}
+
+func TestIgnoredSequencesDroppedByDefault(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b]777;notify;Build;done\x07\x1bPq#0\x1b\\hello"))
+	if got, want := s.AsHTML(), "hello"; got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWithTrailingNewline(t *testing.T) {
+	tests := []struct {
+		name, input         string
+		trailing            bool
+		wantHTML, wantPlain string
+	}{
+		{name: "single line", input: "\x1b[31mone\x1b[0m", wantHTML: `<span class="term-fg31">one</span>`, wantPlain: "one"},
+		{name: "single line, trailing", input: "\x1b[31mone\x1b[0m", trailing: true, wantHTML: `<span class="term-fg31">one</span>` + "\n", wantPlain: "one\n"},
+		{name: "multi-line", input: "one\ntwo", wantHTML: "one\ntwo", wantPlain: "one\ntwo"},
+		{name: "multi-line, trailing", input: "one\ntwo", trailing: true, wantHTML: "one\ntwo\n", wantPlain: "one\ntwo\n"},
+		{name: "input ending in a newline", input: "one\ntwo\n", wantHTML: "one\ntwo", wantPlain: "one\ntwo"},
+		{name: "input ending in a newline, trailing", input: "one\ntwo\n", trailing: true, wantHTML: "one\ntwo\n", wantPlain: "one\ntwo\n"},
+		{name: "empty, trailing", input: "", trailing: true, wantHTML: "", wantPlain: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithTrailingNewline(test.trailing))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.wantHTML {
+				t.Errorf("AsHTML() = %q, want %q", got, test.wantHTML)
+			}
+			if got := s.TailHTML(10); got != test.wantHTML {
+				t.Errorf("TailHTML(10) = %q, want %q", got, test.wantHTML)
+			}
+			if got := s.AsPlainText(); got != test.wantPlain {
+				t.Errorf("AsPlainText() = %q, want %q", got, test.wantPlain)
+			}
+			if got := s.TailText(10); got != test.wantPlain {
+				t.Errorf("TailText(10) = %q, want %q", got, test.wantPlain)
+			}
+		})
+	}
+}
+
+func TestWithLineClassifier(t *testing.T) {
+	classify := func(text string) string {
+		switch {
+		case strings.Contains(text, "ERROR"):
+			return "level-error"
+		case strings.Contains(text, "WARN"):
+			return "level-warn"
+		}
+		return ""
+	}
+	s, err := NewScreen(WithLineClassifier(classify), WithCurrentLineClass("term-current"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("INFO starting\n\x1b[31mERROR\x1b[0m <disk> full\nWARN retrying\nERROR gave up"))
+
+	want := "INFO starting\n" +
+		`<span class="level-error"><span class="term-fg31">ERROR</span> &lt;disk&gt; full</span>` + "\n" +
+		`<span class="level-warn">WARN retrying</span>` + "\n" +
+		`<span class="term-current level-error">ERROR gave up</span>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+	if got, want := s.AsPlainText(), "INFO starting\nERROR <disk> full\nWARN retrying\nERROR gave up"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
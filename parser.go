@@ -1,6 +1,8 @@
 package terminal
 
 import (
+	"slices"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -14,12 +16,36 @@ const (
 	parserModeCharset
 	parserModeAPC
 	parserModeAPCEsc // within APC and just read an escape
+	parserModeHash   // just read ESC #
+	parserModeDCS
+	parserModeDCSEsc // within DCS and just read an escape
+	parserModeSS3    // just read ESC O
 )
 
+// Names of the parser modes, for DebugState.
+var parserModeNames = [...]string{
+	parserModeNormal:  "normal",
+	parserModeEscape:  "escape",
+	parserModeControl: "CSI",
+	parserModeOSC:     "OSC",
+	parserModeOSCEsc:  "OSC escape",
+	parserModeCharset: "charset",
+	parserModeAPC:     "APC",
+	parserModeAPCEsc:  "APC escape",
+	parserModeHash:    "ESC #",
+	parserModeDCS:     "DCS",
+	parserModeDCSEsc:  "DCS escape",
+	parserModeSS3:     "SS3",
+}
+
 type position struct {
 	x, y int
 }
 
+// byteOrderMark is U+FEFF, which is a byte order mark (EF BB BF in UTF-8) at
+// the start of a stream.
+const byteOrderMark = '\uFEFF'
+
 // Stateful ANSI parser
 type parser struct {
 	screen               *Screen
@@ -30,7 +56,10 @@ type parser struct {
 	escapeStartedAt      int
 	instructions         []string
 	instructionStartedAt int
-	savePosition         position
+	intermediates        []byte
+
+	// offset of the start of buffer in the whole input
+	offset int
 
 	// Buildkite-specific state
 	lastTimestamp int64
@@ -48,7 +77,7 @@ type parser struct {
  * parserModeEscape. The following character could start an escape sequence, a
  * control sequence, an operating system command, or be invalid or not understood.
  *
- * If we're in parserModeEscape we look for ~~three~~ eight possible characters:
+ * If we're in parserModeEscape we look for ~~three~~ nine possible characters:
  *
  * 1. For `[` we enter parserModeControl and start looking for a control sequence.
  * 2. For `]` we enter parserModeOSC and look for an operating system command.
@@ -56,6 +85,11 @@ type parser struct {
  * 4. For `_` we enter parserModeAPC and parse the rest of the custom control sequence
  * 5. For `M`, `7`, or `8`, we run an instruction directly (reverse newline,
  *    or save/restore cursor).
+ * 6. For `#` we enter parserModeHash and run the instruction given by the
+ *    next character (only `8`, the screen alignment test, does anything).
+ * 7. For `P` we enter parserModeDCS and read a device control string.
+ * 8. For `O` we enter parserModeSS3, and discard the next character if it
+ *    makes a key sequence (such as ESC O A, the up arrow key).
  *
  * In all cases we start our instruction buffer. The instruction buffer is used
  * to store the individual characters that make up ANSI instructions before
@@ -76,7 +110,7 @@ type parser struct {
  * up to the terminator to parseElementSequence and return to parserModeNormal.
  *
  * parserModeAPC is just like parserModeOSC, except the contents should be processed
- * differently.
+ * differently. parserModeDCS is similar again, but is only terminated by ST.
  *
  * If we're in parserModeCharset we simply discard the next character which would
  * normally designate the character set.
@@ -88,10 +122,35 @@ func (p *parser) parseToScreen(input []byte) {
 	p.buffer = join{p.remainder, input}
 
 	for p.cursor < p.buffer.len() {
+		// Anything consumed might change the screen.
+		p.screen.dirty = true
+		p.screen.writeOffset = p.offset + p.cursor
+
 		// UTF-8 runes are 1-4 bytes, so slice ahead +4.
 		charBytes := p.buffer.slice(p.cursor, min(p.cursor+4, p.buffer.len()))
+		if !utf8.FullRune(charBytes) {
+			// The input ends part way through a multibyte rune. Keep it
+			// until the rest arrives, rather than decoding it as invalid.
+			break
+		}
 		char, charLen := utf8.DecodeRune(charBytes)
 
+		if char == byteOrderMark && p.offset+p.cursor == 0 {
+			// A UTF-8 byte order mark at the very start of the stream says
+			// how the rest is encoded, and isn't part of the text. Anywhere
+			// else, U+FEFF is a zero width no-break space, and is kept.
+			p.cursor += charLen
+			continue
+		}
+
+		if char == utf8.RuneError && charLen == 1 && p.mode == parserModeNormal && p.screen.c1Controls {
+			// Not valid UTF-8, but possibly an 8-bit control.
+			if p.handleC1(charBytes[0]) {
+				p.cursor += charLen
+				continue
+			}
+		}
+
 		switch p.mode {
 		case parserModeEscape:
 			// We've received an escape character but aren't inside an escape sequence yet
@@ -113,6 +172,22 @@ func (p *parser) parseToScreen(input []byte) {
 			// We're inside a charset sequence, capture the next character.
 			p.handleCharset(char)
 
+		case parserModeHash:
+			// We've received ESC #, the next character is the instruction.
+			p.handleHash(char)
+
+		case parserModeDCS:
+			// We're inside a device control string, capture until we hit ESC \ (ST)
+			p.handleDeviceControlString(char)
+
+		case parserModeDCSEsc:
+			// We're inside a DCS, and just hit an ESC (which might be ST)
+			p.handleDCSEscape(char)
+
+		case parserModeSS3:
+			// We've received ESC O, the next character might be a key.
+			p.handleSS3(char)
+
 		case parserModeAPC:
 			// We're inside a custom escape sequence, capture until we hit BEL or ESC \ (ST)
 			p.handleApplicationProgramCommand(char)
@@ -123,6 +198,15 @@ func (p *parser) parseToScreen(input []byte) {
 
 		case parserModeNormal:
 			// Outside of an escape sequence entirely, normal input
+			if isPlainASCII(char) && p.screen.widthFunc == nil && !p.screen.insertMode {
+				// Fast path: write a run of plain text in one go. This
+				// assumes the built-in widths, where ASCII is 1 cell, and
+				// that characters replace what is under the cursor.
+				run := p.buffer.plainRun(p.cursor)
+				p.screen.appendRun(run)
+				p.cursor += len(run)
+				continue
+			}
 			p.handleNormal(char)
 		}
 
@@ -130,9 +214,12 @@ func (p *parser) parseToScreen(input []byte) {
 	}
 
 	// If we're in normal mode, everything up to the cursor has been procesed.
+	// Anything after it is the start of a rune.
 	if p.mode == parserModeNormal {
+		done := p.cursor
+		p.remainder = append(p.remainder[:0], p.buffer.slice(done, p.buffer.len())...)
+		p.offset += done
 		p.cursor = 0
-		p.remainder = p.remainder[:0]
 		return
 	}
 
@@ -141,6 +228,7 @@ func (p *parser) parseToScreen(input []byte) {
 	// don't want to retain (see io.Writer docs), so copy it using append.
 	done := p.escapeStartedAt
 	p.remainder = append(p.remainder[:0], p.buffer.slice(done, p.buffer.len())...)
+	p.offset += done
 
 	// Adjust the buffer indices accordingly.
 	p.cursor -= done
@@ -150,7 +238,22 @@ func (p *parser) parseToScreen(input []byte) {
 
 // handleCharset is called for each character consumed while in parserModeCharset.
 // It ignores the character and transitions back to parserModeNormal.
-func (p *parser) handleCharset(rune) {
+func (p *parser) handleCharset(char rune) {
+	if p.screen.TraceFunc != nil {
+		designator := string(p.buffer.slice(p.instructionStartedAt-1, p.instructionStartedAt))
+		p.trace("ESC", char, []string{designator})
+	}
+	p.mode = parserModeNormal
+}
+
+// handleHash is called for the character after ESC #.
+// ESC # 8 is the screen alignment test (DECALN). The others (ESC # 3, 4, 5, 6)
+// change the line to double height or width, which is ignored.
+func (p *parser) handleHash(char rune) {
+	p.trace("ESC", char, []string{"#"})
+	if char == '8' {
+		p.screen.alignmentTest()
+	}
 	p.mode = parserModeNormal
 }
 
@@ -186,43 +289,120 @@ func (p *parser) handleOperatingSystemCommand(char rune) {
 	}
 }
 
+// Kinds of OSC sequence, as classified by classifyOSC.
+const (
+	oscUnsupported = iota
+	oscTitle       // 0 or 2: set window title
+	oscHyperlink   // 8: iTerm-style hyperlink
+	oscElement     // 1337 File=, 1338, 1339: inline images and links
+	oscTooltip     // 1340: tooltip for the following text
+	oscShellMark   // 133: shell integration marks
+)
+
+// classifyOSC returns the kind of OSC sequence, based on its number (and for
+// 1337, which has many uses, the prefix of the arguments). It doesn't check
+// the remainder of the sequence is well-formed.
+func classifyOSC(sequence string) int {
+	switch num, rest, _ := strings.Cut(sequence, ";"); num {
+	case "0", "2":
+		return oscTitle
+	case "8":
+		return oscHyperlink
+	case "1337":
+		if strings.HasPrefix(rest, "File=") {
+			return oscElement
+		}
+	case "1338", "1339":
+		return oscElement
+	case "1340":
+		return oscTooltip
+	case "133":
+		return oscShellMark
+	}
+	return oscUnsupported
+}
+
 // processOperatingSystemCommand processes the contents of the OSC that was just read.
 func (p *parser) processOperatingSystemCommand(end int) {
 	p.mode = parserModeNormal
-	element, err := parseElementSequence(string(p.buffer.slice(p.instructionStartedAt, end)))
-	// Errors are rendered into the screen (see below).
+	// Anything written for the OSC comes from the start of it.
+	p.screen.writeOffset = p.offset + p.escapeStartedAt
+	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
+	if p.screen.TraceFunc != nil {
+		p.trace("OSC", 0, strings.SplitN(sequence, ";", 2))
+	}
 
-	if element == nil && err == nil {
-		// No element & no error, nothing to render
+	// Classify the sequence first. Disabled and unsupported sequences are
+	// dropped without being parsed.
+	switch classifyOSC(sequence) {
+	case oscTitle:
+		// Set window title (0 also sets the icon name).
+		_, p.screen.title, _ = strings.Cut(sequence, ";")
+		return
+
+	case oscTooltip:
+		p.setTooltip(sequence)
 		return
-	}
 
-	ownLine := element == nil || element.elementType == elementImage || element.elementType == elementITermImage
+	case oscShellMark:
+		_, args, _ := strings.Cut(sequence, ";")
+		p.screen.shellMark(args)
+		return
 
-	if ownLine {
-		// Images (or the error encountered) should appear on their own line
-		if p.screen.x != 0 {
-			p.screen.newLine()
+	case oscHyperlink:
+		if p.screen.noHyperlinks {
+			return
+		}
+
+	case oscElement:
+		if p.screen.noElements {
+			return
 		}
-		p.screen.currentLine().clear(screenStartOfLine, screenEndOfLine)
+
+	default:
+		// Titles for other things, clipboard access, colour changes, ...
+		// Only the number is reported, since the rest could be large or
+		// sensitive (e.g. clipboard contents).
+		if p.screen.debugOSC {
+			num, _, _ := strings.Cut(sequence, ";")
+			p.appendError("*** Unsupported OSC escape sequence: " + num)
+		}
+		p.screen.keepIgnoredSequence("osc", sequence)
+		return
 	}
 
+	element, err := parseElementSequence(sequence)
 	if err != nil {
-		p.screen.appendMany([]rune("*** Error parsing custom element escape sequence: "))
-		p.screen.appendMany([]rune(err.Error()))
-		p.screen.newLine()
+		// The sequence is malformed. Render the error into the screen.
+		p.appendError("*** Error parsing custom element escape sequence: " + err.Error())
+		return
+	}
+	if element == nil {
+		// No element & no error, nothing to render
 		return
 	}
 
-	if element != nil && element.elementType == elementITermLink {
+	if element.elementType == elementITermLink {
 		// OSC 8 (iTerm-style) links work like a style. iTerm2 behaves this way.
 		// Instead of appending an "element" node, store the URL to apply like a
 		// colour. If the URL is empty, the text is no longer linked.
+		if limit := p.screen.maxURLLength; limit > 0 && len(element.url) > limit {
+			// Too long to keep, so the text isn't linked.
+			p.screen.LinksTooLong++
+			element.url = ""
+		}
 		p.screen.urlBrush = element.url
 		p.screen.style.setHyperlink(element.url != "")
 		return
 	}
 
+	ownLine := element.elementType == elementImage || element.elementType == elementITermImage
+
+	if ownLine {
+		// Images should appear on their own line
+		p.startOwnLine()
+	}
+
 	p.screen.appendElement(element)
 
 	if ownLine {
@@ -230,6 +410,42 @@ func (p *parser) processOperatingSystemCommand(end int) {
 	}
 }
 
+// setTooltip handles OSC 1340, which sets the tooltip for the text that
+// follows, like an OSC 8 link: 1340;title=… starts it, and 1340; (or an empty
+// title) ends it. Values may be quoted, as in OSC 1338 and 1339.
+func (p *parser) setTooltip(sequence string) {
+	_, args, _ := strings.Cut(sequence, ";")
+	tokens, err := tokenizeString(args, ';', '\\')
+	if err != nil {
+		p.appendError("*** Error parsing tooltip escape sequence: " + err.Error())
+		return
+	}
+	title := ""
+	for _, token := range tokens {
+		if key, val, ok := strings.Cut(token, "="); ok && strings.ToLower(key) == "title" {
+			title = val
+		}
+	}
+	p.screen.tooltipBrush = title
+	p.screen.style.setTooltip(title != "")
+}
+
+// appendError renders an error message on its own line.
+func (p *parser) appendError(msg string) {
+	p.startOwnLine()
+	p.screen.appendMany([]rune(msg))
+	p.screen.newLine()
+}
+
+// startOwnLine moves to a new line, if the cursor isn't at the start of one,
+// and clears it.
+func (p *parser) startOwnLine() {
+	if p.screen.x != 0 {
+		p.screen.newLine()
+	}
+	p.screen.currentLine().clear(screenStartOfLine, screenEndOfLine)
+}
+
 // handleAPCEscape is called for the character after an ESC when reading an APC.
 // It either returns to APC mode, or terminates the APC and processes it.
 func (p *parser) handleAPCEscape(char rune) {
@@ -245,6 +461,43 @@ func (p *parser) handleAPCEscape(char rune) {
 	}
 }
 
+// handleDeviceControlString is called for each character consumed while in
+// parserModeDCS. It does nothing until the DCS is terminated with ESC \ (ST).
+func (p *parser) handleDeviceControlString(char rune) {
+	if char == '\x1b' {
+		// Next char _could_ be \ which makes the combination ST
+		p.mode = parserModeDCSEsc
+	}
+}
+
+// handleDCSEscape is called for the character after an ESC when reading a DCS.
+// It either returns to DCS mode, or terminates the DCS and processes it.
+func (p *parser) handleDCSEscape(char rune) {
+	if char == '\\' {
+		// Don't include the ESC in the DCS contents.
+		p.processDeviceControlString(p.cursor - 1)
+		return
+	}
+	p.mode = parserModeDCS
+}
+
+// processDeviceControlString processes the contents of the DCS that was just
+// read. DECRQSS (request status string) queries are answered, and everything
+// else (Sixel graphics, terminal multiplexer passthrough, ...) is discarded.
+func (p *parser) processDeviceControlString(end int) {
+	p.mode = parserModeNormal
+	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
+	if p.screen.TraceFunc != nil {
+		p.trace("DCS", 0, strings.Split(sequence, ";"))
+	}
+
+	if setting, ok := strings.CutPrefix(sequence, "$q"); ok {
+		p.screen.requestStatusString(setting)
+		return
+	}
+	p.screen.keepIgnoredSequence("dcs", sequence)
+}
+
 // handleApplicationProgramCommand is called for each character consumed while
 // in parserModeAPC, but does nothing until the APC is terminated with BEL (0x07)
 // or the two-byte form of ST (ESC \).
@@ -281,6 +534,9 @@ func (p *parser) handleApplicationProgramCommand(char rune) {
 func (p *parser) processApplicationProgramCommand(end int) {
 	p.mode = parserModeNormal
 	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
+	if p.screen.TraceFunc != nil {
+		p.trace("APC", 0, strings.Split(sequence, ";"))
+	}
 
 	// this might be a Buildkite Application Program Command sequence...
 	data, err := p.parseBuildkiteAPC(sequence)
@@ -296,28 +552,102 @@ func (p *parser) processApplicationProgramCommand(end int) {
 	p.screen.setLineMetadata(bkNamespace, data)
 }
 
+// The characters of control sequences (after CSI) that handleControlSequence
+// recognises, shared with StripANSI.
+const (
+	// Final characters with different meanings to their other-case
+	// counterparts, so they are dispatched before the case-insensitive
+	// handling of the others.
+	csiCaseSensitiveFinals = "acfhlnrstu@PX`"
+
+	// Final characters of supported sequences, in either case.
+	csiFinals = "ABCDEFGHJKMQ"
+
+	// Final characters of sequences that are recognised but not relevant to
+	// us, in either case, so they are swallowed:
+	// CSI i: Enable/disable AUX port
+	// CSI I, CSI O: Focus in and out reports (see mode 1004), which are
+	// input to the program, but can turn up in captured sessions
+	// CSI L: Insert lines (not implemented)
+	// CSI N: (not a standard sequence)
+	csiIgnoredFinals = "ILNO"
+
+	// Parameter characters, other than the ; separating instructions. ? < =
+	// > are private markers, which are kept as a prefix of the first
+	// instruction.
+	csiParamChars = "?<=>0123456789"
+)
+
 // handleControlSequence is called for each character consumed while in
 // parserModeControl.
 func (p *parser) handleControlSequence(char rune) {
-	char = unicode.ToUpper(char)
-	switch char {
-	case '?', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		// Part of an instruction
+	if char >= 0x20 && char <= 0x2f {
+		// An intermediate byte, e.g. the " in CSI 1 " q. These come after
+		// the parameters and before the final character.
+		p.addInstruction()
+		p.intermediates = append(p.intermediates, byte(char))
+		p.instructionStartedAt = p.cursor + 1
+		return
+	}
+	if len(p.intermediates) > 0 {
+		p.addInstruction()
+		if !p.screen.applyIntermediateEscape(string(p.intermediates), char, p.instructions) {
+			// unrecognized sequence, abort the escapeCode
+			p.cursor = p.escapeStartedAt
+		} else {
+			p.trace("CSI", char, p.instructions)
+		}
+		p.mode = parserModeNormal
+		return
+	}
+
+	if char == 'M' && p.screen.mouseTracking() {
+		// With several parameters, this is a urxvt or SGR mouse report, which
+		// is input to the program, but can turn up in captured sessions.
+		// Otherwise it is handled as usual, which (like other finals handled
+		// case-insensitively) makes it the same as CSI m, SGR. X10 reports
+		// (CSI M and three bytes) can't be told apart from that followed by
+		// text, so they are left alone.
+		p.addInstruction()
+		p.trace("CSI", char, p.instructions)
+		p.mode = parserModeNormal
+		if len(p.instructions) > 1 {
+			p.screen.keepIgnoredSequence("mouse", strings.Join(p.instructions, ";"))
+		} else {
+			p.screen.applyEscape(char, p.instructions)
+		}
+		return
+	}
+
+	if strings.ContainsRune(csiCaseSensitiveFinals, char) {
+		p.addInstruction()
+		p.trace("CSI", char, p.instructions)
+		p.screen.applyEscape(char, p.instructions)
+		p.mode = parserModeNormal
+		return
+	}
 
-	case ';':
+	final := char
+	char = unicode.ToUpper(char)
+	switch {
+	case char == ';':
 		p.addInstruction()
 		p.instructionStartedAt = p.cursor + utf8.RuneLen(';')
 
-	case 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'J', 'K', 'M', 'Q':
+	case strings.ContainsRune(csiParamChars, char):
+		// Part of an instruction.
+
+	case strings.ContainsRune(csiFinals, char):
 		p.addInstruction()
+		p.trace("CSI", final, p.instructions)
 		p.screen.applyEscape(char, p.instructions)
 		p.mode = parserModeNormal
 
-	case 'I', 'L', 'N':
-		// CSI i: Enable/disable AUX port
-		// CSI L: Set/reset mode (SM/RM)
-		// CSI n: Report cursor position
-		// All not relevant to us. Swallow the code and continue
+	case strings.ContainsRune(csiIgnoredFinals, char):
+		if p.screen.TraceFunc != nil {
+			p.addInstruction()
+			p.trace("CSI", final, p.instructions)
+		}
 		p.mode = parserModeNormal
 
 	default:
@@ -336,6 +666,12 @@ func (p *parser) handleNormal(char rune) {
 		p.screen.carriageReturn()
 	case '\b':
 		p.screen.backspace()
+	case '\t':
+		p.screen.tab()
+	case '\x07': // BEL: ring the bell, which prints nothing
+		if p.screen.BellFunc != nil {
+			p.screen.BellFunc()
+		}
 	case '\x1b':
 		p.escapeStartedAt = p.cursor
 		p.mode = parserModeEscape
@@ -344,12 +680,52 @@ func (p *parser) handleNormal(char rune) {
 	}
 }
 
+// handleSS3 is called for the character after ESC O (SS3). Keys such as the
+// arrows and F1-F4 are sent as SS3 sequences (and so is the mouse wheel with
+// alternate scroll mode, ?1007). These are input to the program, but can turn
+// up in captured sessions, so they are discarded. For other characters, ESC O
+// is treated as text, like other unrecognised escape sequences.
+func (p *parser) handleSS3(char rune) {
+	p.mode = parserModeNormal
+	if isSS3Key(char) {
+		p.trace("ESC", char, []string{"O"})
+		return
+	}
+	p.screen.append('O')
+	// Process the character again, in normal mode.
+	p.cursor -= utf8.RuneLen(char)
+}
+
+// isSS3Key reports whether char, after ESC O, makes a key sequence.
+func isSS3Key(char rune) bool {
+	return strings.ContainsRune("ABCDFHPQRS", char)
+}
+
+// handleC1 handles an 8-bit C1 control byte, returning false if b is not one
+// that is supported.
+func (p *parser) handleC1(b byte) bool {
+	switch b {
+	case 0x84: // IND
+		p.screen.index()
+	case 0x85: // NEL
+		p.screen.newLine()
+	case 0x8d: // RI
+		p.screen.revNewLine()
+	default:
+		return false
+	}
+	return true
+}
+
 // handleEscape is called for each character consumed while in parserModeEscape.
 func (p *parser) handleEscape(char rune) {
 	switch char {
 	case '[':
 		p.instructionStartedAt = p.cursor + utf8.RuneLen('[')
-		p.instructions = make([]string, 0, 1)
+		// Reuse the instructions slice, like intermediates. Nothing keeps it
+		// beyond the sequence (trace passes a copy).
+		p.instructions = p.instructions[:0]
+		p.intermediates = p.intermediates[:0]
 		p.mode = parserModeControl
 
 	case ']':
@@ -364,17 +740,30 @@ func (p *parser) handleEscape(char rune) {
 		p.instructionStartedAt = p.cursor + utf8.RuneLen('[')
 		p.mode = parserModeAPC
 
+	case '#':
+		p.mode = parserModeHash
+
+	case 'P':
+		p.instructionStartedAt = p.cursor + utf8.RuneLen('P')
+		p.mode = parserModeDCS
+
+	case 'O':
+		p.mode = parserModeSS3
+
 	case 'M':
 		p.screen.revNewLine()
 		p.mode = parserModeNormal
 
 	case '7':
-		p.savePosition = position{x: p.screen.x, y: p.screen.y}
+		p.screen.saveCursor()
 		p.mode = parserModeNormal
 
 	case '8':
-		p.screen.x = p.savePosition.x
-		p.screen.y = p.savePosition.y
+		p.screen.restoreCursor()
+		p.mode = parserModeNormal
+
+	case 'Z': // DECID: identify terminal, the same as CSI c
+		p.screen.reply(primaryDeviceAttributes)
 		p.mode = parserModeNormal
 
 	case '=', '>': // DECKPAM, DECKPNM
@@ -385,9 +774,31 @@ func (p *parser) handleEscape(char rune) {
 		p.mode = parserModeNormal
 
 	default:
-		// Not an escape code, false alarm
+		if char >= 0x30 && char <= 0x7e && p.screen.unknownEscapes == UnknownEscapesDiscard {
+			// A two-character sequence we don't support (such as ESC S),
+			// which a terminal would ignore.
+			p.mode = parserModeNormal
+			break
+		}
+		// Not an escape code (or unknown escapes are kept as text), false
+		// alarm
 		p.cursor = p.escapeStartedAt
 		p.mode = parserModeNormal
+		return
+	}
+
+	if p.mode == parserModeNormal {
+		// A complete two-character sequence
+		p.trace("ESC", char, nil)
+	}
+}
+
+// trace reports a completed escape sequence to the screen's TraceFunc, if
+// there is one.
+func (p *parser) trace(kind string, finalByte rune, params []string) {
+	if p.screen.TraceFunc != nil {
+		// params may be reused by the parser, so pass a copy.
+		p.screen.TraceFunc(kind, finalByte, slices.Clone(params))
 	}
 }
 
@@ -418,3 +829,21 @@ func (j join) slice(from, to int) []byte {
 }
 
 func (j join) len() int { return len(j.head) + len(j.tail) }
+
+// plainRun returns the run of printable ASCII bytes (see isPlainASCII)
+// starting at from, without copying. The run stops at the end of the head, so
+// it may be shorter than the whole run.
+func (j join) plainRun(from int) []byte {
+	b := j.head
+	if m := len(j.head); from >= m {
+		b, from = j.tail, from-m
+	}
+	end := from
+	for end < len(b) && isPlainASCII(rune(b[end])) {
+		end++
+	}
+	return b[from:end]
+}
+
+// isPlainASCII reports if r is a printable ASCII character.
+func isPlainASCII(r rune) bool { return r >= 0x20 && r < 0x7f }
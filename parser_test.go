@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestParseSimpleXY(t *testing.T) {
@@ -89,6 +91,1486 @@ func TestParseDECCursorSaveRestore(t *testing.T) {
 	}
 }
 
+func TestParseSCOCursorSaveRestore(t *testing.T) {
+	s := parsedScreen(t, "one\ntwo\x1b[s\nthree\x1b[u!")
+	if err := assertTextXY(s, "one\ntwo!\nthree", 4, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseKittyKeyboardProtocolIgnored(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		x, y  int
+	}{
+		{
+			name:  "push and pop",
+			input: "\x1b[>1uhello\x1b[<u",
+			want:  "hello",
+			x:     5,
+		},
+		{
+			name:  "set and query",
+			input: "\x1b[=1;1uhello\x1b[?u",
+			want:  "hello",
+			x:     5,
+		},
+		{
+			name:  "push does not restore the cursor",
+			input: "ab\x1b[scd\x1b[>3uef",
+			want:  "abcdef",
+			x:     6,
+		},
+		{
+			name:  "restore cursor after kitty sequences",
+			input: "ab\x1b[s\x1b[>1ucd\x1b[<u\x1b[uX",
+			want:  "abXd",
+			x:     3,
+		},
+		{
+			name:  "modifyOtherKeys is not SGR",
+			input: "\x1b[>4;1mhello",
+			want:  "hello",
+			x:     5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if err := assertTextXY(s, test.want, test.x, test.y); err != nil {
+				t.Error(err)
+			}
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseEraseToCursorPreservesContentAfterCursor(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		x     int
+	}{
+		{
+			name:  "cursor in the middle of the line",
+			input: "hello friend\x1b[8D\x1b[1K",
+			want:  "      friend",
+			x:     4,
+		},
+		{
+			name:  "cursor at the start of the line",
+			input: "hello\r\x1b[1K",
+			want:  " ello",
+			x:     0,
+		},
+		{
+			name:  "cursor on the last character of the line",
+			input: "hello\x1b[D\x1b[1K",
+			want:  "",
+			x:     4,
+		},
+		{
+			name:  "cursor past the end of the line",
+			input: "hello\x1b[1K!",
+			want:  "     !",
+			x:     6,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if err := assertTextXY(s, test.want, test.x, 0); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseEraseToCursorPreservesStyleAfterCursor(t *testing.T) {
+	s := parsedScreen(t, "\x1b[31mhello \x1b[32mfriend\x1b[0m\x1b[8D\x1b[1K")
+	want := `     <span class="term-fg31"> </span><span class="term-fg32">friend</span>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseTitleStack(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{name: "OSC 0", input: "\x1b]0;first\x07", want: "first"},
+		{name: "OSC 2", input: "\x1b]2;first\x1b\\", want: "first"},
+		{name: "OSC 1 is the icon name", input: "\x1b]2;first\x07\x1b]1;icon\x07", want: "first"},
+		{
+			name:  "save, change, restore",
+			input: "\x1b]2;first\x07\x1b[22;2t\x1b]2;second\x07\x1b[23;2t",
+			want:  "first",
+		},
+		{
+			name:  "save, change, restore both",
+			input: "\x1b]2;first\x07\x1b[22;0t\x1b]2;second\x07\x1b[23;0t",
+			want:  "first",
+		},
+		{
+			name:  "nested",
+			input: "\x1b]2;first\x07\x1b[22t\x1b]2;second\x07\x1b[22t\x1b]2;third\x07\x1b[23t",
+			want:  "second",
+		},
+		{
+			name:  "only icon name",
+			input: "\x1b]2;first\x07\x1b[22;1t\x1b]2;second\x07\x1b[23;1t",
+			want:  "second",
+		},
+		{
+			name:  "underflow",
+			input: "\x1b]2;first\x07\x1b[22t\x1b]2;second\x07\x1b[23t\x1b[23t\x1b[23t",
+			want:  "first",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input+"hello")
+			if got := s.Title(); got != test.want {
+				t.Errorf("Title() = %q, want %q", got, test.want)
+			}
+			if err := assertText(s, "hello"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseWideCharactersAndClusters(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantText string
+		wantX    int
+	}{
+		{name: "wide characters", input: "漢字!", wantText: "漢字!", wantX: 5},
+		{name: "combining mark", input: "cafe\u0301!", wantText: "cafe\u0301!", wantX: 5},
+		{name: "flag", input: "\U0001F1F3\U0001F1FF!", wantText: "\U0001F1F3\U0001F1FF!", wantX: 3},
+		{name: "two flags", input: "\U0001F1F3\U0001F1FF\U0001F1E6\U0001F1FA", wantText: "\U0001F1F3\U0001F1FF\U0001F1E6\U0001F1FA", wantX: 4},
+		{
+			name:     "ZWJ family",
+			input:    "\U0001F468\u200d\U0001F469\u200d\U0001F467!",
+			wantText: "\U0001F468\u200d\U0001F469\u200d\U0001F467!",
+			wantX:    3,
+		},
+		{name: "skin tone", input: "\U0001F44D\U0001F3FD!", wantText: "\U0001F44D\U0001F3FD!", wantX: 3},
+		{name: "overwrite first half", input: "漢字\x1b[4Dab", wantText: "ab字", wantX: 2},
+		{name: "overwrite second half", input: "漢字\x1b[3Dab", wantText: " ab", wantX: 3},
+		{name: "overwrite with wide", input: "abc\x1b[2D漢", wantText: "a漢", wantX: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if err := assertTextXY(s, test.wantText, test.wantX, 0); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseWideCharacterWrapsAtLastColumn(t *testing.T) {
+	s, err := NewScreen(WithSize(5, 3))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("abcd漢"))
+	if err := assertTextXY(s, "abcd\n漢", 2, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseAutowrapMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantText string
+		wantX    int
+		wantY    int
+	}{
+		{
+			name:     "on by default, wrap is deferred",
+			input:    "abcde",
+			wantText: "abcde",
+			wantX:    5,
+		},
+		{
+			name:     "on, wraps at next character",
+			input:    "abcdef",
+			wantText: "abcde\nf",
+			wantX:    1,
+			wantY:    1,
+		},
+		{
+			name:     "on, wraps at next character written singly",
+			input:    "abcde\x1b[?7h\u00e9",
+			wantText: "abcde\n\u00e9",
+			wantX:    1,
+			wantY:    1,
+		},
+		{
+			name:     "off, overwrites last column",
+			input:    "\x1b[?7labcdefgh",
+			wantText: "abcdh",
+			wantX:    5,
+		},
+		{
+			name:     "off, overwrites last column written singly",
+			input:    "\x1b[?7labcde\u00e9\u00e8",
+			wantText: "abcd\u00e8",
+			wantX:    5,
+		},
+		{
+			name:     "off, wide character overwrites last two columns",
+			input:    "\x1b[?7labcde漢",
+			wantText: "abc漢",
+			wantX:    5,
+		},
+		{
+			name:     "off after deferred wrap",
+			input:    "abcde\x1b[?7lf",
+			wantText: "abcdf",
+			wantX:    5,
+		},
+		{
+			name:     "back on",
+			input:    "\x1b[?7labcdefg\x1b[?7hh",
+			wantText: "abcdg\nh",
+			wantX:    1,
+			wantY:    1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(5, 3))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.wantText, test.wantX, test.wantY); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResizeFixesCutWideCharacters(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     WideCharResizeMode
+		wantHTML string
+	}{
+		{
+			name:     "blank",
+			mode:     WideCharResizeBlank,
+			wantHTML: "abcd漢\n" + `abc<a href="http://example.com">d</a>`,
+		},
+		{
+			name:     "shift",
+			mode:     WideCharResizeShift,
+			wantHTML: "abcd漢\n" + `abc<a href="http://example.com">漢</a>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 1), WithWideCharResize(test.mode))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			// The line above the window is left as it is.
+			s.Write([]byte("abcd漢\nabc\x1b]8;;http://example.com\x1b\\d漢\x1b]8;;\x1b\\"))
+			if err := s.SetSize(5, 1); err != nil {
+				t.Fatalf("SetSize(5, 1) = %v", err)
+			}
+			if got := s.AsHTML(); got != test.wantHTML {
+				t.Errorf("AsHTML() = %q, want %q", got, test.wantHTML)
+			}
+			for x, n := range s.currentLine().nodes {
+				if n.style.cont() && x >= s.cols {
+					t.Errorf("continuation node at column %d, beyond the window width %d", x, s.cols)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderClustersAsSingleSpans(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "flag",
+			input: "\x1b[31m\U0001F1F3\U0001F1FF\x1b[0m",
+			want:  `<span class="term-fg31">` + "\U0001F1F3\U0001F1FF" + `</span>`,
+		},
+		{
+			name:  "ZWJ family",
+			input: "\x1b[31m\U0001F468\u200d\U0001F469\u200d\U0001F467\x1b[0m!",
+			want:  `<span class="term-fg31">` + "\U0001F468\u200d\U0001F469\u200d\U0001F467" + `</span>!`,
+		},
+		{
+			name:  "escaped base",
+			input: "<\u0338",
+			want:  "&lt;\u0338",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseScreenAlignmentTest(t *testing.T) {
+	s, err := NewScreen(WithSize(5, 3))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("hello\nworld\x1b[31m\x1b#8"))
+	if err := assertTextXY(s, "EEEEE\nEEEEE\nEEEEE", 0, 0); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.AsHTML(), "EEEEE\nEEEEE\nEEEEE"; got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseScreenAlignmentTestCountsCells(t *testing.T) {
+	s, err := NewScreen(WithSize(7, 4))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b#8"))
+	grid := s.AsGrid()
+	count := 0
+	for _, row := range grid {
+		for _, cell := range row {
+			if cell.Rune == 'E' {
+				count++
+			}
+		}
+	}
+	if want := 7 * 4; count != want || len(s.screen) != 4 {
+		t.Errorf("after ESC # 8: %d cells filled across %d lines, want %d across 4", count, len(s.screen), want)
+	}
+}
+
+func TestParseLineSizeEscapesIgnored(t *testing.T) {
+	s := parsedScreen(t, "a\x1b#3b\x1b#4c\x1b#5d\x1b#6e")
+	if err := assertTextXY(s, "abcde", 5, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseEraseBelowFromUnallocatedLine(t *testing.T) {
+	s := parsedScreen(t, "hello\nworld"+csi(5, "B")+"\x1b[J")
+	if err := assertTextXY(s, "hello\nworld", 5, 6); err != nil {
+		t.Error(err)
+	}
+	if got, want := len(s.screen), 2; got != want {
+		t.Errorf("len(s.screen) = %d, want %d", got, want)
+	}
+}
+
+func TestParseTabs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ScreenOption
+		input    string
+		wantText string
+		wantHTML string
+		wantX    int
+	}{
+		{
+			name:     "preserve",
+			input:    "a\tb\tc",
+			wantText: "a\tb\tc",
+			wantHTML: "a\tb\tc",
+			wantX:    17,
+		},
+		{
+			name:     "expand",
+			opts:     []ScreenOption{WithTabMode(TabExpand)},
+			input:    "a\tb\tc",
+			wantText: "a       b       c",
+			wantHTML: "a       b       c",
+			wantX:    17,
+		},
+		{
+			name:     "preserve with tab width",
+			opts:     []ScreenOption{WithTabWidth(4)},
+			input:    "ab\tc",
+			wantText: "ab\tc",
+			wantHTML: "ab\tc",
+			wantX:    5,
+		},
+		{
+			name:     "expand with tab width",
+			opts:     []ScreenOption{WithTabMode(TabExpand), WithTabWidth(4)},
+			input:    "ab\tc",
+			wantText: "ab  c",
+			wantHTML: "ab  c",
+			wantX:    5,
+		},
+		{
+			name:     "preserve then overwrite within tab",
+			input:    "a\tb\r" + csi(3, "C") + "X",
+			wantText: "a  X    b",
+			wantHTML: "a  X    b",
+			wantX:    4,
+		},
+		{
+			name:     "expand does not overwrite",
+			opts:     []ScreenOption{WithTabMode(TabExpand)},
+			input:    "abcdefghij\r\tX",
+			wantText: "abcdefghXj",
+			wantHTML: "abcdefghXj",
+			wantX:    9,
+		},
+		{
+			name:     "preserve styled",
+			input:    "a\x1b[31m\tb\x1b[0m",
+			wantText: "a\tb",
+			wantHTML: `a<span class="term-fg31">` + "\tb</span>",
+			wantX:    9,
+		},
+		{
+			name:     "stops at last column",
+			opts:     []ScreenOption{WithSize(10, 2)},
+			input:    "abcdef\tX\tY",
+			wantText: "abcdef\tXY",
+			wantHTML: "abcdef\tXY",
+			wantX:    10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.wantText, test.wantX, 0); err != nil {
+				t.Error(err)
+			}
+			if got := s.AsHTML(); got != test.wantHTML {
+				t.Errorf("AsHTML() = %q, want %q", got, test.wantHTML)
+			}
+		})
+	}
+}
+
+func TestWithTabWidthInvalid(t *testing.T) {
+	if _, err := NewScreen(WithTabWidth(0)); err == nil {
+		t.Error("NewScreen(WithTabWidth(0)) error = nil, want error")
+	}
+}
+
+func TestParseQueryReplies(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{name: "device status", input: "\x1b[5n", want: "\x1b[0n"},
+		{name: "cursor position", input: "hi\nyou\x1b[6n", want: "\x1b[2;4R"},
+		{name: "cursor position at home", input: "\x1b[6n", want: "\x1b[1;1R"},
+		{name: "cursor position past last column", input: "abcde\x1b[6n", want: "\x1b[1;5R"},
+		{name: "primary device attributes", input: "\x1b[c", want: "\x1b[?62;22c"},
+		{name: "primary device attributes 0", input: "\x1b[0c", want: "\x1b[?62;22c"},
+		{name: "DECID", input: "\x1bZ", want: "\x1b[?62;22c"},
+		{name: "secondary device attributes", input: "\x1b[>c", want: "\x1b[>0;0;0c"},
+		{name: "secondary device attributes 0", input: "\x1b[>0c", want: "\x1b[>0;0;0c"},
+		{name: "several", input: "\x1b[5n\x1b[6n", want: "\x1b[0n\x1b[1;1R"},
+		{name: "unknown", input: "\x1b[7n\x1b[=c\x1b[?6n", want: ""},
+		{name: "DECRQSS SGR default", input: "\x1bP$qm\x1b\\", want: "\x1bP1$r0m\x1b\\"},
+		{name: "DECRQSS SGR", input: "\x1b[1;4;38;5;100;41m\x1bP$qm\x1b\\", want: "\x1bP1$r0;1;4;38;5;100;41m\x1b\\"},
+		{name: "DECRQSS unsupported", input: "\x1bP$qr\x1b\\", want: "\x1bP0$r\x1b\\"},
+		{name: "other DCS", input: "\x1bPq#0;2;0;0;0#0!10~\x1b\\", want: ""},
+		{name: "text area size", input: "\x1b[18t", want: "\x1b[8;3;5t"},
+		{name: "pixel sizes unknown", input: "\x1b[14t\x1b[16t", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var replies strings.Builder
+			s, err := NewScreen(WithSize(5, 3), WithReplyWriter(&replies))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := replies.String(); got != test.want {
+				t.Errorf("replies = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSizeReports(t *testing.T) {
+	var replies strings.Builder
+	s, err := NewScreen(WithSize(80, 24), WithReplyWriter(&replies), WithCellPixelSize(9, 18))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	if err := s.SetSize(132, 50); err != nil {
+		t.Fatalf("SetSize(132, 50) = %v", err)
+	}
+	s.Write([]byte("\x1b[18t\x1b[14t\x1b[16t"))
+	if got, want := replies.String(), "\x1b[8;50;132t\x1b[4;900;1188t\x1b[6;18;9t"; got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}
+
+func TestWithCellPixelSizeInvalid(t *testing.T) {
+	if _, err := NewScreen(WithCellPixelSize(0, 18)); err == nil {
+		t.Error("NewScreen(WithCellPixelSize(0, 18)) error = nil, want error")
+	}
+}
+
+func TestParseDeviceControlStringsAreDiscarded(t *testing.T) {
+	s := parsedScreen(t, "a\x1bP$qm\x1b\\b\x1bPq#0;2;0;0;0\x1bx\x07#0!10~\x1b\\c")
+	if err := assertTextXY(s, "abc", 3, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseQueriesDoNotMoveCursor(t *testing.T) {
+	// Without a reply writer, queries are ignored. In particular CSI c isn't
+	// mistaken for CSI C (cursor forward).
+	s := parsedScreen(t, "ab\x1b[c\x1b[6n\x1bZc")
+	if err := assertTextXY(s, "abc", 3, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseCursorDownFarThenWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     int
+		wantLines int
+		wantOOB   int
+	}{
+		// Cursor movement is clamped to the window, which bounds the number
+		// of lines allocated.
+		{name: "default window", lines: 100, wantLines: 100, wantOOB: 1},
+		{name: "huge window", lines: 100000, wantLines: 50001, wantOOB: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(160, test.lines))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(csi(50000, "B") + "x"))
+
+			if got := len(s.screen); got != test.wantLines {
+				t.Errorf("len(s.screen) = %d, want %d", got, test.wantLines)
+			}
+			if s.CursorDownOOB != test.wantOOB {
+				t.Errorf("s.CursorDownOOB = %d, want %d", s.CursorDownOOB, test.wantOOB)
+			}
+
+			// Only the line written to should have any room for nodes.
+			last := len(s.screen) - 1
+			for i, line := range s.screen[:last] {
+				if cap(line.nodes) != 0 {
+					t.Fatalf("cap(s.screen[%d].nodes) = %d, want 0", i, cap(line.nodes))
+				}
+			}
+			if got, want := s.screen[last].asPlain(&s.render), "x"; got != want {
+				t.Errorf("s.screen[%d].asPlain() = %q, want %q", last, got, want)
+			}
+		})
+	}
+}
+
+func TestParseSetModeIsNotCursorPosition(t *testing.T) {
+	// CSI h (set mode) isn't CSI H (cursor position).
+	s := parsedScreen(t, "hello\x1b[20hworld\x1b[20l!")
+	if err := assertTextXY(s, "helloworld!", 11, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseSelectiveErase(t *testing.T) {
+	const (
+		protect   = "\x1b[1\"q"
+		unprotect = "\x1b[0\"q"
+	)
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "selective erase in line keeps protected cells",
+			input: protect + "AB" + unprotect + "CD" + protect + "EF\r\x1b[?K",
+			want:  "AB  EF",
+		},
+		{
+			name:  "normal erase in line erases protected cells",
+			input: protect + "AB" + unprotect + "CD" + protect + "EF\r\x1b[K",
+			want:  "",
+		},
+		{
+			name:  "selective erase to cursor",
+			input: "ab" + protect + "CD" + unprotect + "ef\x1b[2D\x1b[?1K",
+			want:  "  CD f",
+		},
+		{
+			name:  "selective erase in display",
+			input: protect + "keep" + unprotect + " lose\nlose" + protect + "me\x1b[1A\r\x1b[?J",
+			want:  "keep\n    me",
+		},
+		{
+			name:  "normal erase in display",
+			input: protect + "keep" + unprotect + " lose\nlose" + protect + "me\x1b[1A\r\x1b[J",
+			want:  "\n",
+		},
+		{
+			name:  "SGR 0 doesn't unprotect",
+			input: protect + "\x1b[31mAB\x1b[0mCD\r\x1b[?2K",
+			want:  "ABCD",
+		},
+		{
+			name:  "DECSCA with no parameter unprotects",
+			input: protect + "AB\x1b[\"qCD\r\x1b[?2K",
+			want:  "AB",
+		},
+		{
+			name:  "cursor style is ignored",
+			input: "a\x1b[2 qb",
+			want:  "ab",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if err := assertText(s, test.want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseC1Controls(t *testing.T) {
+	tests := []struct {
+		name, input string
+		enabled     bool
+		wantText    string
+		wantX       int
+		wantY       int
+	}{
+		{name: "NEL", input: "abc\x85def", enabled: true, wantText: "abc\ndef", wantX: 3, wantY: 1},
+		{name: "IND", input: "abc\x84def", enabled: true, wantText: "abc\n   def", wantX: 6, wantY: 1},
+		{name: "RI", input: "abc\ndef\x8dghi", enabled: true, wantText: "abcghi\ndef", wantX: 6, wantY: 0},
+		{name: "disabled", input: "abc\x85def", wantText: "abc�def", wantX: 7, wantY: 0},
+		{name: "UTF-8 NEL is not a control", input: "abc\u0085def", enabled: true, wantText: "abc\u0085def", wantX: 7, wantY: 0},
+		{name: "other bytes", input: "a\x86b", enabled: true, wantText: "a�b", wantX: 3, wantY: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithC1Controls(test.enabled))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.wantText, test.wantX, test.wantY); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseCursorKeyMode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  CursorKeyMode
+	}{
+		{input: "", want: CursorKeysNormal},
+		{input: "\x1b[?1h", want: CursorKeysApplication},
+		{input: "\x1b[?1h\x1b[?1l", want: CursorKeysNormal},
+		{input: "\x1b[?25;1h", want: CursorKeysApplication},
+		{input: "\x1b[1h", want: CursorKeysNormal}, // not a private mode
+	}
+
+	for _, test := range tests {
+		s := parsedScreen(t, test.input)
+		if got := s.CursorKeyMode(); got != test.want {
+			t.Errorf("after %q: CursorKeyMode() = %d, want %d", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseBatchedPrivateModes(t *testing.T) {
+	s := parsedScreen(t, "a\x1b[?25;1049;2004hb")
+	for _, mode := range []int{1049, 2004} {
+		if !s.PrivateMode(mode) {
+			t.Errorf("after set: PrivateMode(%d) = false, want true", mode)
+		}
+	}
+	if err := assertTextXY(s, "ab", 2, 0); err != nil {
+		t.Error(err)
+	}
+
+	s.Write([]byte("\x1b[?25;1049;2004;9999lc"))
+	for _, mode := range []int{25, 1049, 2004, 9999} {
+		if s.PrivateMode(mode) {
+			t.Errorf("after reset: PrivateMode(%d) = true, want false", mode)
+		}
+	}
+	if err := assertTextXY(s, "abc", 3, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrivateModeDefaults(t *testing.T) {
+	s := parsedScreen(t, "")
+	tests := []struct {
+		mode int
+		want bool
+	}{
+		{mode: 1, want: false},
+		{mode: 7, want: true},
+		{mode: 25, want: true},
+		{mode: 1049, want: false},
+		{mode: 2004, want: false},
+		{mode: 9999, want: false},
+	}
+	for _, test := range tests {
+		if got := s.PrivateMode(test.mode); got != test.want {
+			t.Errorf("PrivateMode(%d) = %t, want %t", test.mode, got, test.want)
+		}
+	}
+}
+
+func TestParseWithRuneWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    func(rune) int
+		input    string
+		wantText string
+		wantX    int
+	}{
+		{name: "default", input: "漢字ab", wantText: "漢字ab", wantX: 6},
+		{name: "everything narrow", width: func(rune) int { return 1 }, input: "漢字ab", wantText: "漢字ab", wantX: 4},
+		{name: "everything wide", width: func(rune) int { return 2 }, input: "ab", wantText: "ab", wantX: 4},
+		{name: "ambiguous wide", width: func(r rune) int {
+			if r == '±' {
+				return 2
+			}
+			return runeWidth(r)
+		}, input: "±1", wantText: "±1", wantX: 3},
+		{name: "out of range widths", width: func(r rune) int { return int(r - 'a') }, input: "abcd", wantText: "abcd", wantX: 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithRuneWidth(test.width))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.wantText, test.wantX, 0); err != nil {
+				t.Error(err)
+			}
+			if got := s.LineWidth(0); got != test.wantX {
+				t.Errorf("LineWidth(0) = %d, want %d", got, test.wantX)
+			}
+		})
+	}
+}
+
+func TestParseFormatChars(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     FormatCharMode
+		input    string
+		wantText string
+		wantX    int
+	}{
+		{name: "soft hyphen", input: "hy\u00adphen", wantText: "hy\u00adphen", wantX: 6},
+		{name: "bidi controls", input: "a\u200fb\u202ec\u202cd\u2066e\u2069", wantText: "a\u200fb\u202ec\u202cd\u2066e\u2069", wantX: 5},
+		{name: "zero width space", input: "a\u200bb", wantText: "a\u200bb", wantX: 2},
+		{name: "at start of line", input: "\u200eab\n\u00adcd", wantText: "ab\ncd", wantX: 2},
+		{name: "overwritten", input: "ab\u00adc\rxyz", wantText: "xyz", wantX: 3},
+		{name: "dropped", mode: FormatCharsDrop, input: "hy\u00adphen a\u200fb", wantText: "hyphen ab", wantX: 9},
+		{name: "zero width joiner still joins", mode: FormatCharsDrop, input: "👩\u200d💻!", wantText: "👩\u200d💻!", wantX: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithFormatChars(test.mode))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.wantText, test.wantX, s.y); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAnsiInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{in: "", want: 1},
+		{in: "x", want: 1},
+		{in: "0", want: 0},
+		{in: "42", want: 42},
+		{in: "65535", want: 65535},
+		{in: "65536", want: 65535},
+		{in: "2000000000", want: 65535},
+		{in: "99999999999999999999", want: 65535},
+	}
+	for _, test := range tests {
+		if got := ansiInt(test.in); got != test.want {
+			t.Errorf("ansiInt(%q) = %d, want %d", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseHugeParameters(t *testing.T) {
+	tests := []struct {
+		name, input string
+		wantX       int
+		wantY       int
+	}{
+		{name: "down", input: "a\x1b[2000000000Bb", wantX: 2, wantY: 4},
+		{name: "up", input: "\n\na\x1b[99999999999999999999Ab", wantX: 2, wantY: 0},
+		{name: "forward", input: "a\x1b[2000000000Cb", wantX: 10, wantY: 0},
+		{name: "column", input: "a\x1b[2000000000Gb", wantX: 10, wantY: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertXY(s, test.wantX, test.wantY); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseInsertMode(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		wantX             int
+	}{
+		{name: "replace by default", input: "world\rhello", want: "hello", wantX: 5},
+		{name: "insert pushes text right", input: "world\r\x1b[4hhi ", want: "hi world", wantX: 3},
+		{name: "insert in the middle", input: "abef\x1b[3G\x1b[4hcd", want: "abcdef", wantX: 4},
+		{name: "reset mode", input: "abc\r\x1b[4hx\x1b[4ly", want: "xybc", wantX: 2},
+		{name: "text pushed past the edge is lost", input: "0123456789\r\x1b[4hab", want: "ab01234567", wantX: 2},
+		{name: "wide character split", input: "a漢b\x1b[3G\x1b[4hx", want: "a x b", wantX: 3},
+		{name: "inserting a wide character", input: "ab\r\x1b[4h漢", want: "漢ab", wantX: 2},
+		{name: "private mode 4 is not insert mode", input: "abc\r\x1b[?4hx", want: "xbc", wantX: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.want, test.wantX, 0); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseInsertModeMovesLinks(t *testing.T) {
+	s := parsedScreen(t, "\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\\r\x1b[4hsee ")
+	want := `see <a href="http://example.com">link</a>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseHorizontalPosition(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		wantX, wantY      int
+	}{
+		{name: "absolute", input: "abc\x1b[10`", want: "abc", wantX: 9},
+		{name: "absolute then write", input: "abc\x1b[6`x", want: "abc  x", wantX: 6},
+		{name: "absolute default", input: "abc\x1b[`x", want: "xbc", wantX: 1},
+		{name: "absolute past the edge", input: "abc\x1b[999`", want: "abc", wantX: 19},
+		{name: "relative", input: "a\x1b[3ab", want: "a   b", wantX: 5},
+		{name: "relative default", input: "a\x1b[ab", want: "a b", wantX: 3},
+		{name: "relative is not cursor up", input: "a\nb\x1b[a", want: "a\nb", wantX: 2, wantY: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(20, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.want, test.wantX, test.wantY); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseCharacterEditing(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		wantX             int
+	}{
+		{name: "insert characters", input: "abc\x1b[2G\x1b[2@", want: "a  bc", wantX: 1},
+		{name: "delete characters", input: "abcde\x1b[2G\x1b[2P", want: "ade", wantX: 1},
+		{name: "erase characters", input: "abcde\x1b[2G\x1b[2X", want: "a  de", wantX: 1},
+		{name: "count of 0 is 1", input: "abc\r\x1b[0P", want: "bc", wantX: 0},
+		{name: "large count", input: "abc\x1b[2G\x1b[99999P", want: "a", wantX: 1},
+		{name: "insert pushes text past the edge", input: "0123456789\r\x1b[3@", want: "   0123456", wantX: 0},
+		{name: "past the end of the line", input: "ab\x1b[5G\x1b[P\x1b[X\x1b[@", want: "ab", wantX: 4},
+
+		{name: "erase to end from second half", input: "a漢b\x1b[3G\x1b[K", want: "a", wantX: 2},
+		{name: "erase to start from first half", input: "a漢b\x1b[2G\x1b[1K", want: "   b", wantX: 1},
+		{name: "selective erase from second half", input: "a漢b\x1b[3G\x1b[?K", want: "a", wantX: 2},
+		{name: "erase second half", input: "a漢bc\x1b[3G\x1b[X", want: "a  bc", wantX: 2},
+		{name: "erase first half", input: "ab漢c\x1b[2G\x1b[2X", want: "a   c", wantX: 1},
+		{name: "erase from wide to end", input: "a漢bc\x1b[2G\x1b[3X", want: "a   c", wantX: 1},
+		{name: "delete whole wide", input: "a漢bc\x1b[2G\x1b[2P", want: "abc", wantX: 1},
+		{name: "delete first half", input: "a漢bc\x1b[2G\x1b[P", want: "a bc", wantX: 1},
+		{name: "delete second half", input: "a漢bc\x1b[3G\x1b[P", want: "a bc", wantX: 2},
+		{name: "insert before wide", input: "a漢b\x1b[2G\x1b[@", want: "a 漢b", wantX: 1},
+		{name: "insert inside wide", input: "a漢b\x1b[3G\x1b[2@", want: "a    b", wantX: 2},
+		{name: "insert pushes wide past the edge", input: "abcdefgh漢\r\x1b[@", want: " abcdefgh", wantX: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertTextXY(s, test.want, test.wantX, 0); err != nil {
+				t.Error(err)
+			}
+			for x, n := range s.screen[0].nodes {
+				if n.style.cont() && (x == 0 || s.screen[0].nodes[x-1] == emptyNode) {
+					t.Errorf("node %d is a continuation of nothing", x)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDeleteCharactersMovesLinks(t *testing.T) {
+	s := parsedScreen(t, "see \x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\\r\x1b[4P")
+	want := `<a href="http://example.com">link</a>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseTraceFunc(t *testing.T) {
+	type call struct {
+		Kind      string
+		FinalByte rune
+		Params    []string
+	}
+	var got []call
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.TraceFunc = func(kind string, finalByte rune, params []string) {
+		got = append(got, call{kind, finalByte, params})
+	}
+	s.Write([]byte("\x1b[1;31mred\x1b[?25l\x1b[3a\x1b]0;title\x07\x1b7\x1b(B\x1b#8\x1b_bk;t=1\x07\x1bP$qm\x1b\\\x1bx\x1b[1~"))
+
+	want := []call{
+		{Kind: "CSI", FinalByte: 'm', Params: []string{"1", "31"}},
+		{Kind: "CSI", FinalByte: 'l', Params: []string{"?25"}},
+		{Kind: "CSI", FinalByte: 'a', Params: []string{"3"}},
+		{Kind: "OSC", Params: []string{"0", "title"}},
+		{Kind: "ESC", FinalByte: '7'},
+		{Kind: "ESC", FinalByte: 'B', Params: []string{"("}},
+		{Kind: "ESC", FinalByte: '8', Params: []string{"#"}},
+		{Kind: "APC", Params: []string{"bk", "t=1"}},
+		{Kind: "DCS", Params: []string{"$qm"}},
+		{Kind: "ESC", FinalByte: 'x'}, // unsupported, and discarded
+		// CSI 1 ~ isn't recognised, so it is rendered as text, not reported.
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("TraceFunc calls diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestParseBell(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	bells := 0
+	s.BellFunc = func() { bells++ }
+	s.Write([]byte("a\x07b\x07\x07\x1b]0;title\x07c"))
+	if err := assertTextXY(s, "abc", 3, 0); err != nil {
+		t.Error(err)
+	}
+	if bells != 3 {
+		t.Errorf("BellFunc called %d times, want 3", bells)
+	}
+}
+
+func TestParseBellWithoutBellFunc(t *testing.T) {
+	s := parsedScreen(t, "a\x07b")
+	if err := assertTextXY(s, "ab", 2, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseClearFunc(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	type call struct {
+		mode int
+		text string
+	}
+	var calls []call
+	s.ClearFunc = func(mode int) { calls = append(calls, call{mode, s.AsPlainText()}) }
+	s.Write([]byte("one\x1b[2Jtwo\x1b[J\x1b[0J\x1b[1Jthree\x1b[3Jfour\x1b[?2J"))
+
+	want := []call{
+		{mode: 2, text: "one"},
+		{mode: 3, text: "      three"},
+	}
+	if diff := cmp.Diff(calls, want, cmp.AllowUnexported(call{})); diff != "" {
+		t.Errorf("ClearFunc calls diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestParseOverwriteFunc(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var frames []string
+	s.OverwriteFunc = func(html string) { frames = append(frames, html) }
+	s.Write([]byte("start\r\n\x1b[32m|\x1b[0m working\r/ working\r\r- working\r\x1b[K\\ working\rdone\n\rnext"))
+
+	want := []string{
+		`<span class="term-fg32">|</span> working`,
+		"&#47; working",
+		"- working",
+		`\ working`,
+	}
+	if diff := cmp.Diff(frames, want); diff != "" {
+		t.Errorf("OverwriteFunc frames diff (-got +want):\n%s", diff)
+	}
+	if err := assertText(s, "start\ndonerking\nnext"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseOverwriteFuncLimit(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	frames := 0
+	s.OverwriteFunc = func(string) { frames++ }
+	s.Write([]byte(strings.Repeat("frame\r", overwriteFrameLimit+10) + "last\nagain\rand again"))
+	if want := overwriteFrameLimit + 1; frames != want {
+		t.Errorf("OverwriteFunc called %d times, want %d", frames, want)
+	}
+}
+
+func TestParseOverwriteFuncCursorUp(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var frames []string
+	s.OverwriteFunc = func(html string) { frames = append(frames, html) }
+	s.Write([]byte("one\ntwo\r\x1b[Ax"))
+	if len(frames) != 0 {
+		t.Errorf("OverwriteFunc frames = %q, want none", frames)
+	}
+	if err := assertText(s, "xne\ntwo"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseFocusReports(t *testing.T) {
+	s := parsedScreen(t, "\x1b[?1004hone\x1b[I\x1b[O two\x1b[O\x1b[I")
+	if err := assertTextXY(s, "one two", 7, 0); err != nil {
+		t.Error(err)
+	}
+	if !s.PrivateMode(1004) {
+		t.Error("PrivateMode(1004) = false, want true")
+	}
+}
+
+func TestParseMouseReportsDiscarded(t *testing.T) {
+	reports := "\x1b[<64;10;5M\x1b[<0;10;5m" + // SGR
+		"\x1b[64;10;5M" + // urxvt
+		"\x1bOA\x1bOB" // mouse wheel with alternate scroll
+
+	tests := []struct {
+		name, setup string
+	}{
+		{name: "main screen", setup: "\x1b[?1000;1006;1007h"},
+		{name: "alternate screen", setup: "\x1b[?1049h\x1b[?1000;1006;1007h"},
+		{name: "X10 mode", setup: "\x1b[?9h\x1b[?1006h"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.setup+"a"+reports+"b\x1b[31mc"+reports+"d")
+			if err := assertTextXY(s, "abcd", 4, 0); err != nil {
+				t.Error(err)
+			}
+			want := `ab<span class="term-fg31">cd</span>`
+			if got := s.AsHTML(); got != want {
+				t.Errorf("AsHTML() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseMouseReportAcrossWrites(t *testing.T) {
+	s := parsedScreen(t, "\x1b[?1000;1006ha\x1b[<64;")
+	s.Write([]byte("10;5"))
+	s.Write([]byte("Mb"))
+	if err := assertText(s, "ab"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseSingleParameterCSIMWithMouseTracking(t *testing.T) {
+	// With at most one parameter, CSI M is SGR, as without mouse tracking,
+	// and the bytes after it are text.
+	s := parsedScreen(t, "\x1b[?1000hone\x1b[Mtwo\x1b[2Mthree\x1b[M")
+	if err := assertText(s, "onetwothree"); err != nil {
+		t.Error(err)
+	}
+	want := `onetwo<span class="term-fg2">three</span>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseAlternateScrollMode(t *testing.T) {
+	s := parsedScreen(t, "\x1b[?1007h")
+	if !s.PrivateMode(1007) {
+		t.Error("PrivateMode(1007) = false, want true")
+	}
+	s.Write([]byte("\x1b[?1007l"))
+	if s.PrivateMode(1007) {
+		t.Error("PrivateMode(1007) = true, want false")
+	}
+}
+
+func TestParseSS3(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{name: "keys are discarded", input: "a\x1bOA\x1bOP\x1bOSb", want: "ab"},
+		{name: "other characters are text", input: "\x1bOops", want: "Oops"},
+		{name: "escape after ESC O", input: "\x1bO\x1b[31mx", want: "Ox"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := parsedScreen(t, test.input)
+			if err := assertText(s, test.want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseByteOrderMark(t *testing.T) {
+	s := parsedScreen(t, "\xef\xbb\xbfhello\nworld")
+	if err := assertTextXY(s, "hello\nworld", 5, 1); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.screen[0].nodes[0].blob, 'h'; got != want {
+		t.Errorf("first cell = %q, want %q", got, want)
+	}
+}
+
+func TestParseByteOrderMarkOnlyAtStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+	}{
+		{name: "after text", writes: []string{"a\uFEFFb"}},
+		{name: "after a byte order mark", writes: []string{"\uFEFFa\uFEFFb"}},
+		{name: "start of a later write", writes: []string{"a", "\uFEFFb"}},
+		{name: "after an escape sequence", writes: []string{"a\x1b[1m\uFEFFb"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			for _, w := range test.writes {
+				s.Write([]byte(w))
+			}
+			if got := s.AsPlainText(); !strings.Contains(got, "\uFEFF") {
+				t.Errorf("AsPlainText() = %q, want it to contain U+FEFF", got)
+			}
+		})
+	}
+}
+
+func TestParsePushPopSGR(t *testing.T) {
+	s := parsedScreen(t, "\x1b[1;3;38;2;10;20;30;48;5;200m")
+	before := s.style
+
+	s.Write([]byte("\x1b[#{\x1b[0;4;31;42mchanged\x1b[#}"))
+	if s.style != before {
+		t.Errorf("style after pop = %+v, want %+v", s.style, before)
+	}
+
+	// Nested pushes are popped in reverse order. CSI # p and CSI # q are
+	// aliases.
+	s.Write([]byte("\x1b[#p\x1b[0;32m\x1b[#{\x1b[7m"))
+	inner := s.style
+	inner.setReverse(false)
+	s.Write([]byte("\x1b[#}"))
+	if s.style != inner {
+		t.Errorf("style after inner pop = %+v, want %+v", s.style, inner)
+	}
+	s.Write([]byte("\x1b[#q"))
+	if s.style != before {
+		t.Errorf("style after outer pop = %+v, want %+v", s.style, before)
+	}
+
+	// Popping with nothing saved does nothing.
+	s.Write([]byte("\x1b[#}"))
+	if s.style != before {
+		t.Errorf("style after extra pop = %+v, want %+v", s.style, before)
+	}
+}
+
+func TestParsePushPopSGRKeepsLink(t *testing.T) {
+	s := parsedScreen(t, "\x1b[#{\x1b[1m\x1b]8;;http://example.com\x07bold\x1b[#}plain\x1b]8;;\x07")
+	want := `<a href="http://example.com"><span class="term-fg1">bold</span>plain</a>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestParseScrollRegionBoundsBuffer(t *testing.T) {
+	s, err := NewScreen(WithSize(20, 10))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("header\n\x1b[3;7r"))
+	for i := range 1000 {
+		s.Write([]byte(fmt.Sprintf("\x1b[7;1Hline %d\n", i)))
+		if len(s.screen) > 10 {
+			t.Fatalf("after frame %d, len(s.screen) = %d, want at most 10", i, len(s.screen))
+		}
+	}
+	want := "header\n\nline 996\nline 997\nline 998\nline 999\n"
+	if err := assertText(s, want); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseScrollRegion(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			// The rows below the region are kept below it as it scrolls.
+			name:  "line feeds in a top region keep scrollback",
+			input: "\x1b[1;3ra\nb\nc\nd\ne",
+			want:  "a\nb\nc\nd\ne\n\n",
+		},
+		{
+			name:  "line feed at the region bottom scrolls the region",
+			input: "top\n\x1b[2;3r\x1b[2Ha\nb\nc\nd",
+			want:  "top\nc\nd",
+		},
+		{
+			name:  "cursor down stops at the region bottom",
+			input: "top\n\x1b[2;3r\x1b[2Ha\x1b[5Bb",
+			want:  "top\na\n b",
+		},
+		{
+			name:  "reverse index at the region top scrolls down",
+			input: "top\n\x1b[2;3r\x1b[2Ha\nb\x1b[2A\x1bMc",
+			want:  "top\n c\na",
+		},
+		{
+			name:  "cursor position outside the region is absolute",
+			input: "top\n\x1b[2;3r\x1b[5;3Hx",
+			want:  "top\n\n\n\n  x",
+		},
+		{
+			name:  "reset region",
+			input: "top\n\x1b[2;3r\x1b[r\x1b[2Ha",
+			want:  "top\na",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertText(s, test.want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParsePushSGRLimit(t *testing.T) {
+	var input strings.Builder
+	for i := range sgrStackLimit + 5 {
+		fmt.Fprintf(&input, "\x1b[%dm\x1b[#{", 31+i%7)
+	}
+	s := parsedScreen(t, input.String())
+	if got, want := len(s.sgrStack), sgrStackLimit; got != want {
+		t.Errorf("len(s.sgrStack) = %d, want %d", got, want)
+	}
+	// The oldest 5 styles were discarded, so the last one restored is the
+	// sixth pushed.
+	for range sgrStackLimit + 5 {
+		s.Write([]byte("\x1b[#}"))
+	}
+	if got, want := s.style, (style{}).color([]string{"36"}); got != want {
+		t.Errorf("style after popping everything = %+v, want %+v", got, want)
+	}
+}
+
+func TestPending(t *testing.T) {
+	s := parsedScreen(t, "hello \x1b[31mred\x1b]0;title\x07")
+	if got := s.Pending(); got != 0 {
+		t.Errorf("after complete sequences, Pending() = %d, want 0", got)
+	}
+
+	s.Write([]byte("\x1b]8;;http://exa"))
+	if got, want := s.Pending(), len("\x1b]8;;http://exa"); got != want {
+		t.Errorf("mid-OSC, Pending() = %d, want %d", got, want)
+	}
+	s.Write([]byte("mple.com"))
+	if got, want := s.Pending(), len("\x1b]8;;http://example.com"); got != want {
+		t.Errorf("still mid-OSC, Pending() = %d, want %d", got, want)
+	}
+
+	s.Write([]byte("\x1b\\link"))
+	if got := s.Pending(); got != 0 {
+		t.Errorf("after the OSC ended, Pending() = %d, want 0", got)
+	}
+
+	s.Write([]byte("\x1b["))
+	if got, want := s.Pending(), 2; got != want {
+		t.Errorf("mid-CSI, Pending() = %d, want %d", got, want)
+	}
+}
+
+func TestParseUnknownEscapes(t *testing.T) {
+	tests := []struct {
+		name, input string
+		mode        UnknownEscapeMode
+		want        string
+	}{
+		{name: "ESC q", input: "a\x1bqb", want: "ab"},
+		{name: "ESC S and ESC T", input: "a\x1bSb\x1bTc", want: "abc"},
+		{name: "ESC digit", input: "a\x1b1b", want: "ab"},
+		{name: "ESC control character", input: "a\x1b\tb", want: "a\tb"},
+		{name: "ESC q, literal", input: "a\x1bqb", mode: UnknownEscapesLiteral, want: "aqb"},
+		{name: "ESC S and ESC T, literal", input: "a\x1bSb\x1bTc", mode: UnknownEscapesLiteral, want: "aSbTc"},
+		{name: "supported escape, literal", input: "ab\x1b7c\x1b8d", mode: UnknownEscapesLiteral, want: "abd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithUnknownEscapes(test.mode))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if err := assertText(s, test.want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseRuneSplitAcrossWrites(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{name: "3-byte rune", input: "a€b", want: "a€b"},
+		{name: "4-byte rune", input: "a😀b", want: "a😀b"},
+		{name: "wide runes", input: "漢字", want: "漢字"},
+		{name: "rune in an OSC", input: "\x1b]8;;http://example.com/€\x1b\\x\x1b]8;;\x1b\\ …", want: "x …"},
+		{name: "invalid byte", input: "a\xffb", want: "a�b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			for i := range len(test.input) {
+				s.Write([]byte{test.input[i]})
+			}
+			if err := assertText(s, test.want); err != nil {
+				t.Error(err)
+			}
+			if got := s.Pending(); got != 0 {
+				t.Errorf("Pending() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestParseIncompleteRunePending(t *testing.T) {
+	s := parsedScreen(t, "a\xe2\x82")
+	if err := assertTextXY(s, "a", 1, 0); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.Pending(), 2; got != want {
+		t.Errorf("Pending() = %d, want %d", got, want)
+	}
+	s.Write([]byte("\xacb"))
+	if err := assertTextXY(s, "a€b", 3, 0); err != nil {
+		t.Error(err)
+	}
+}
+
 // ----------------------------------------
 
 func parsedScreen(t *testing.T, data string) *Screen {
@@ -0,0 +1,109 @@
+package terminal
+
+import "slices"
+
+// setScrollRegion handles DECSTBM (CSI top ; bottom r), which restricts
+// scrolling to the window rows from top to bottom (1-based, inclusive). With
+// no parameters, or a region that isn't at least two lines, the region is
+// reset to the whole window.
+//
+// Once a region is set, a line feed on its bottom line scrolls the lines in the
+// region up rather than adding a line to the buffer, so that programs that
+// redraw within a region don't grow the buffer without bound.
+func (s *Screen) setScrollRegion(top, bottom string) {
+	t := ansiInt(top) - 1
+	b := s.lines - 1
+	if bottom != "" && bottom != "0" {
+		b = min(ansiInt(bottom), s.lines) - 1
+	}
+	t = max(t, 0)
+	s.marginTop, s.marginBottom = 0, 0
+	if t < b && (t > 0 || b < s.lines-1) {
+		s.marginTop, s.marginBottom = t, b
+	}
+	// DECSTBM also homes the cursor, but programs typically restore the
+	// cursor straight afterwards, and the top of the window here may not be
+	// the top of theirs, so only the column is changed.
+	s.x = 0
+}
+
+// hasScrollRegion reports whether a scroll region smaller than the window is
+// in effect.
+func (s *Screen) hasScrollRegion() bool {
+	return s.marginBottom > 0
+}
+
+// inScrollRegion reports whether the cursor is within the scroll region.
+func (s *Screen) inScrollRegion() bool {
+	return s.hasScrollRegion() && s.y >= s.marginTop && s.y <= s.marginBottom
+}
+
+// moveDown moves the cursor down a line. On the bottom line of the scroll
+// region, the region scrolls up instead.
+func (s *Screen) moveDown() {
+	if !s.hasScrollRegion() || s.y != s.marginBottom {
+		s.y++
+		return
+	}
+	s.scrollRegionUp()
+}
+
+// moveUp moves the cursor up a line, if it can. On the top line of the scroll
+// region, the region scrolls down instead.
+func (s *Screen) moveUp() {
+	if s.hasScrollRegion() && s.y == s.marginTop {
+		s.scrollRegionDown()
+		return
+	}
+	if s.y > 0 {
+		s.y--
+	}
+}
+
+// fillRegion ensures the buffer has lines for every row of the window down
+// to the bottom of the scroll region, and returns the buffer index of the top
+// of the window.
+func (s *Screen) fillRegion() int {
+	for len(s.screen) <= s.top()+s.marginBottom {
+		s.screen = append(s.screen, screenLine{})
+	}
+	return s.top()
+}
+
+// scrollRegionUp scrolls the lines in the scroll region up by one, leaving a
+// blank line at the bottom of the region. Like xterm, when the region starts
+// at the top of the window, the top line goes into the scrollback (and may be
+// scrolled out); otherwise, it is discarded.
+func (s *Screen) scrollRegionUp() {
+	if s.marginTop > 0 {
+		top := s.fillRegion()
+		region := s.screen[top+s.marginTop : top+s.marginBottom+1]
+		first := region[0].recycled()
+		copy(region, region[1:])
+		region[len(region)-1] = first
+		return
+	}
+
+	// The window is anchored to the end of the buffer, so the rows below the
+	// region must exist for the inserted line to push the top line out of
+	// the window.
+	for len(s.screen) < s.lines {
+		s.screen = append(s.screen, screenLine{})
+	}
+	s.screen = slices.Insert(s.screen, s.top()+s.marginBottom+1, screenLine{})
+	if s.maxLines > 0 && len(s.screen) > s.maxLines {
+		s.passScrolledOut(0)
+		s.LinesScrolledOut++
+		s.screen = slices.Delete(s.screen, 0, 1)
+	}
+}
+
+// scrollRegionDown scrolls the lines in the scroll region down by one,
+// discarding the bottom line and leaving a blank line at the top.
+func (s *Screen) scrollRegionDown() {
+	top := s.fillRegion()
+	region := s.screen[top+s.marginTop : top+s.marginBottom+1]
+	last := region[len(region)-1].recycled()
+	copy(region[1:], region)
+	region[0] = last
+}
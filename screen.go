@@ -1,7 +1,9 @@
 package terminal
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"maps"
 	"math"
 	"strconv"
@@ -18,15 +20,31 @@ type Screen struct {
 	// Current cursor position on the screen
 	x, y int
 
+	// Cursor position saved by ESC 7 or CSI s
+	savedCursor position
+
 	// Screen contents
 	screen []screenLine
 
 	// Current style
 	style style
 
+	// Styles saved by XTPUSHSGR, to be restored by XTPOPSGR
+	sgrStack []style
+
 	// Current URL for OSC 8 (iTerm-style) hyperlinking
 	urlBrush string
 
+	// Current tooltip text, set by OSC 1340
+	tooltipBrush string
+
+	// Current shell integration region, set by OSC 133 (see shellMark)
+	shellRegion string
+
+	// Window title set by OSC 0 or 2, and titles saved by CSI 22 t
+	title      string
+	titleStack []string
+
 	// Parser to use for streaming processing
 	parser parser
 
@@ -39,21 +57,165 @@ type Screen struct {
 	// Setting to 0 or negative doesn't enforce a limit.
 	maxColumns int
 
+	// Optional upper bound on the length of OSC 8 link URLs.
+	// Setting to 0 or negative doesn't enforce a limit.
+	maxURLLength int
+
 	// Current window size. This is required to properly bound cursor movement
 	// commands and implement line wrapping.
 	// It defaults to 160 columns * 100 lines.
 	cols, lines int
 
+	// Disable inline images and other elements (see WithElements)
+	noElements bool
+
+	// Disable OSC 8 hyperlinks (see WithHyperlinks)
+	noHyperlinks bool
+
+	// How tabs are handled, and the distance between tab stops
+	tabMode  TabMode
+	tabWidth int
+
+	// Optional function giving the width of runes (see WithRuneWidth)
+	widthFunc func(rune) int
+
+	// How zero-width formatting characters are handled (see WithFormatChars)
+	formatChars FormatCharMode
+
+	// How wide characters cut by narrowing the window are handled (see
+	// WithWideCharResize)
+	wideCharResize WideCharResizeMode
+
+	// Optional destination for replies to queries (see WithReplyWriter)
+	replyWriter io.Writer
+
+	// Size of a character cell in pixels, for pixel size reports (see
+	// WithCellPixelSize). Zero if unknown.
+	cellWidth, cellHeight int
+
+	// DEC private modes that have been set or reset (see PrivateMode)
+	privateModes map[int]bool
+
+	// Insert mode (IRM): characters written are inserted at the cursor,
+	// shifting the rest of the line right, rather than replacing what is
+	// there
+	insertMode bool
+
+	// Scroll region (see setScrollRegion): the top and bottom rows of the
+	// window (0-based, inclusive), or both 0 for the whole window
+	marginTop, marginBottom int
+
+	// Input recording (see WithInputRecording): the retained input and the
+	// offset of its start, and the offset of the input being written
+	recordInput bool
+	input       []byte
+	inputStart  int
+	writeOffset int
+
+	// Interpret 8-bit C1 control bytes (see WithC1Controls)
+	c1Controls bool
+
+	// Report unsupported OSC sequences in the output (see WithDebugOSC)
+	debugOSC bool
+
+	// How unsupported two-character escape sequences are handled (see
+	// WithUnknownEscapes)
+	unknownEscapes UnknownEscapeMode
+
+	// Keep SGR parameters that aren't supported, to write in ANSI output
+	// (see WithUnknownSGR)
+	keepUnknownSGR bool
+
+	// Keep ignored sequences, to render as HTML comments
+	// (see WithIgnoredAsComments)
+	keepIgnored bool
+
+	// Clear leftovers from longer lines overwritten after a CR
+	// (see WithCRClearsToEnd)
+	crClearsToEnd bool
+	crPending     bool // a CR has happened on the current line
+	crPrev        int  // the extent of the line at the last CR
+	crWritten     int  // the max column (exclusive) written since the last CR
+
+	// The HTML of the current line at the last CR, to report to
+	// OverwriteFunc if it is overwritten, and the number of lines reported
+	// since the first CR on the current line
+	overwritePending string
+	overwriteFrames  int
+
+	// Options affecting HTML and plain text rendering
+	render renderOptions
+
 	// Optional callback. If not nil, as each line is scrolled out of the top of
 	// the buffer, this func is called with the HTML.
 	ScrollOutFunc func(lineHTML string)
 
+	// Optional callback. Like ScrollOutFunc, but called with more information
+	// about the line (see ScrollOutLine). Both may be set.
+	ScrollOutLineFunc func(ScrollOutLine)
+
+	// Optional callback. If not nil, it is called at the end of each Write
+	// that may have changed the screen, so that the caller can re-render it.
+	// During a synchronized update (between CSI ?2026h and CSI ?2026l) calls
+	// are held back, and made once when the update ends, so that the caller
+	// only sees complete frames.
+	UpdateFunc func()
+
+	// Optional callback. If not nil, it is called for each BEL character
+	// outside of an escape sequence (which would ring the terminal's bell).
+	// BEL characters are not written to the screen either way.
+	BellFunc func()
+
+	// Optional callback. If not nil, it is called when the whole screen is
+	// erased with ESC [ 2 J (mode 2), or the whole buffer including lines
+	// above the window with ESC [ 3 J (mode 3), which often starts a new
+	// section of output. It is called before the lines are cleared, so their
+	// contents can still be read.
+	ClearFunc func(mode int)
+
+	// Optional callback. If not nil, it is called when text is written over
+	// a line after a carriage return, as progress bars and spinners do to
+	// animate, with the HTML of the line as it was at the carriage return.
+	// So it is called with each frame but the last, which stays in the
+	// buffer. Only the first overwriteFrameLimit frames of each line are
+	// reported.
+	OverwriteFunc func(previousLineHTML string)
+
+	// Optional callback. If not nil, it is called as each escape sequence is
+	// completed, including sequences that are recognised but ignored, for
+	// tools that show what the terminal is doing. Sequences that aren't
+	// recognised at all, and so are rendered as text (such as a CSI with an
+	// unknown final character or unsupported intermediate bytes, or any
+	// two-character escape with UnknownEscapesLiteral), are not reported.
+	// kind is "CSI", "OSC", "DCS", "APC" or "ESC" (for other escape
+	// sequences).
+	//
+	// For CSI sequences, finalByte is the final character and params are the
+	// parameters, with any private marker (such as ?) kept as a prefix of the
+	// first. Intermediate bytes are not reported. For ESC sequences,
+	// finalByte is the character after ESC (or after the intermediate
+	// character, such as the B in ESC ( B, in which case params holds the
+	// intermediate). For string sequences (OSC, DCS and APC), finalByte is 0
+	// and params are the contents split at semicolons; for OSC, only at the
+	// first.
+	TraceFunc func(kind string, finalByte rune, params []string)
+
+	// dirty is true if the screen may have changed since UpdateFunc was last
+	// called. syncUpdate is true during a synchronized update.
+	dirty, syncUpdate bool
+
+	// The HTML of lines last returned by WriteAndRenderDelta, by absolute
+	// line number, from deltaTop (the top of the window as of that call)
+	deltaTop  int
+	deltaHTML map[int]string
+
 	// Processing statistics
 	LinesScrolledOut int // count of lines that scrolled off the top
 	CursorUpOOB      int // count of times ESC [A or ESC [F tried to move y < 0
 	CursorDownOOB    int // count of times ESC [B or ESC [G tried to move y >= height
 	CursorFwdOOB     int // count of times ESC [C tried to move x >= width
 	CursorBackOOB    int // count of times ESC [D tried to move x < 0
+	LinksTooLong     int // count of OSC 8 links dropped for exceeding the URL length limit
 }
 
 // ScreenOption is a functional option for creating new screens.
@@ -64,14 +226,37 @@ func WithSize(w, h int) ScreenOption {
 	return func(s *Screen) error { return s.SetSize(w, h) }
 }
 
-// WithMaxSize sets the screen size limits.
+// WithMaxSize sets the screen size limits. A limit of 0 or less means no
+// limit. It is the same as WithMaxColumns(maxCols) and WithMaxLines(maxLines).
 func WithMaxSize(maxCols, maxLines int) ScreenOption {
 	return func(s *Screen) error {
-		s.maxColumns, s.maxLines = maxCols, maxLines
-		// Ensure the size fits within the new limits.
+		if err := WithMaxColumns(maxCols)(s); err != nil {
+			return err
+		}
+		return WithMaxLines(maxLines)(s)
+	}
+}
+
+// WithMaxColumns limits the window width, without changing the limit on its
+// height. The width is reduced to fit, if needed, and SetSize rejects larger
+// widths. Lines are wrapped at the width, so this also bounds the length of
+// each line. A limit of 0 or less means no limit.
+func WithMaxColumns(maxCols int) ScreenOption {
+	return func(s *Screen) error {
+		s.maxColumns = maxCols
 		if maxCols > 0 {
 			s.cols = min(s.cols, maxCols)
 		}
+		return nil
+	}
+}
+
+// WithMaxLines limits the window height, without changing the limit on its
+// width. The height is reduced to fit, if needed, and SetSize rejects larger
+// heights. A limit of 0 or less means no limit.
+func WithMaxLines(maxLines int) ScreenOption {
+	return func(s *Screen) error {
+		s.maxLines = maxLines
 		if maxLines > 0 {
 			s.lines = min(s.lines, maxLines)
 		}
@@ -79,14 +264,221 @@ func WithMaxSize(maxCols, maxLines int) ScreenOption {
 	}
 }
 
+// WithMaxURLLength limits the length (in bytes) of OSC 8 link URLs. Links
+// with longer URLs are dropped, so the text is not linked, and counted in
+// LinksTooLong. This bounds the memory and output used by links in untrusted
+// input. A limit of 0 or less means no limit.
+func WithMaxURLLength(n int) ScreenOption {
+	return func(s *Screen) error {
+		s.maxURLLength = n
+		return nil
+	}
+}
+
+// WithElements enables or disables elements: iTerm2 inline images, and
+// Buildkite external images and links (OSC 1337, 1338 and 1339). They are
+// enabled by default. When disabled, the sequences are dropped without being
+// parsed, so untrusted input cannot embed images in the output.
+// OSC 8 hyperlinks are controlled separately with WithHyperlinks.
+func WithElements(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.noElements = !enabled
+		return nil
+	}
+}
+
+// WithHyperlinks enables or disables OSC 8 (iTerm-style) hyperlinks. They are
+// enabled by default. When disabled, the sequences are dropped, and the text
+// they would have linked is rendered unlinked.
+func WithHyperlinks(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.noHyperlinks = !enabled
+		return nil
+	}
+}
+
+// WithDebugOSC enables or disables reporting unsupported OSC sequences.
+// Normally they are dropped silently, but when enabled, a message naming the
+// OSC number is rendered on its own line in place of each one. Malformed image
+// and link sequences are always reported.
+func WithDebugOSC(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.debugOSC = enabled
+		return nil
+	}
+}
+
+// UnknownEscapeMode controls how unsupported two-character escape sequences,
+// such as ESC S, are handled. See WithUnknownEscapes.
+type UnknownEscapeMode int
+
+const (
+	// UnknownEscapesDiscard discards the sequence, as a terminal would. This
+	// is the default.
+	UnknownEscapesDiscard UnknownEscapeMode = iota
+
+	// UnknownEscapesLiteral drops the ESC, and the character after it is
+	// rendered as text.
+	UnknownEscapesLiteral
+)
+
+// WithUnknownEscapes sets how unsupported two-character escape sequences (an
+// ESC followed by a character from 0 to ~, such as ESC S or ESC q) are
+// handled. An ESC followed by anything else, such as a control character or a
+// space, is never a complete sequence, so the ESC is dropped and the character
+// handled as normal either way.
+func WithUnknownEscapes(mode UnknownEscapeMode) ScreenOption {
+	return func(s *Screen) error {
+		s.unknownEscapes = mode
+		return nil
+	}
+}
+
+// WithIgnoredAsComments enables or disables rendering sequences that are
+// otherwise dropped (unsupported OSCs, DCSs that aren't queries, and mouse
+// reports) as HTML comments, such as <!-- osc:777;notify;hi -->, at the start
+// of the line they were written on. This is meant for debugging: comments
+// aren't shown, but they are still sent to the browser, and can contain
+// anything the program wrote, so it is off by default. Comments are not
+// included in plain text output.
+func WithIgnoredAsComments(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.keepIgnored = enabled
+		return nil
+	}
+}
+
+// WithUnknownSGR enables or disables keeping SGR parameters that aren't
+// otherwise supported (such as 10-20, alternative fonts) on the style of the
+// text they apply to, so that AsANSI writes them again. Up to 4 parameters
+// are kept, until the style is reset with SGR 0; they can't be turned off
+// individually, since what turns them off isn't known. They don't affect
+// HTML or plain text output. Underline colours (58;5;n and 58;2;r;g;b) take
+// several parameters, so are dropped rather than kept. It is off by default.
+func WithUnknownSGR(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.keepUnknownSGR = enabled
+		return nil
+	}
+}
+
+// keepIgnoredSequence records a dropped sequence on the current line, to be
+// rendered as a comment, if enabled with WithIgnoredAsComments. kind is a
+// short name for the kind of sequence, such as "osc".
+func (s *Screen) keepIgnoredSequence(kind, sequence string) {
+	if !s.keepIgnored {
+		return
+	}
+	line := s.currentLineForWriting()
+	line.ignored = append(line.ignored, kind+":"+sequence)
+}
+
+// WithReplyWriter sets a writer for replies to queries from the program, as
+// would be sent by a terminal on the program's input. Queries answered are:
+//   - Device Status Report (CSI 5 n): reply CSI 0 n ("OK")
+//   - Cursor Position Report (CSI 6 n): reply CSI row ; column R
+//   - Primary Device Attributes (CSI c, CSI 0 c, or ESC Z): reply
+//     CSI ? 62 ; 22 c (a VT220 with ANSI colour)
+//   - Secondary Device Attributes (CSI > c or CSI > 0 c): reply
+//     CSI > 0 ; 0 ; 0 c (a VT100)
+//   - Text area size (CSI 18 t): reply CSI 8 ; lines ; columns t
+//   - Text area and cell size in pixels (CSI 14 t and CSI 16 t): reply
+//     CSI 4 ; height ; width t and CSI 6 ; height ; width t, but only if
+//     a cell size is set with WithCellPixelSize
+//
+// Without a reply writer, queries are ignored.
+func WithReplyWriter(w io.Writer) ScreenOption {
+	return func(s *Screen) error {
+		s.replyWriter = w
+		return nil
+	}
+}
+
+// WithCellPixelSize sets the size in pixels of a character cell, used to
+// answer pixel size reports (see WithReplyWriter). Without it, these reports
+// are ignored, since there is no sensible answer.
+func WithCellPixelSize(width, height int) ScreenOption {
+	return func(s *Screen) error {
+		if width <= 0 || height <= 0 {
+			return fmt.Errorf("invalid cell pixel size %dw x %dh", width, height)
+		}
+		s.cellWidth, s.cellHeight = width, height
+		return nil
+	}
+}
+
+// TabMode controls how tab characters are handled. See WithTabMode.
+type TabMode int
+
+const (
+	// TabPreserve keeps tabs as tab characters in the output, leaving the
+	// browser to align the text following them (see the CSS tab-size
+	// property). The tab still moves the cursor to the next tab stop, and
+	// occupies the cells in between. This is the default.
+	TabPreserve TabMode = iota
+
+	// TabExpand moves the cursor to the next tab stop without writing
+	// anything, as a terminal does. Cells skipped over that haven't been
+	// written to are rendered as spaces.
+	TabExpand
+)
+
+// WithTabMode sets how tab characters are handled.
+func WithTabMode(mode TabMode) ScreenOption {
+	return func(s *Screen) error {
+		s.tabMode = mode
+		return nil
+	}
+}
+
+// WithTabWidth sets the distance between tab stops. The default is 8.
+func WithTabWidth(width int) ScreenOption {
+	return func(s *Screen) error {
+		if width <= 0 {
+			return fmt.Errorf("invalid tab width %d", width)
+		}
+		s.tabWidth = width
+		return nil
+	}
+}
+
+// WithCRClearsToEnd enables or disables clearing leftover text after a carriage
+// return. Progress output often rewrites a line by returning to the start of it
+// with CR; if the new text is shorter than what it overwrites, the end of the
+// old text remains. With this option enabled, the columns written since the
+// last CR are tracked, and on the next newline (or CR), anything between the
+// end of the new text and the end of the old text is cleared.
+// A CR followed by a newline with nothing written in between (CRLF) clears
+// nothing.
+func WithCRClearsToEnd(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.crClearsToEnd = enabled
+		return nil
+	}
+}
+
+// WithC1Controls enables or disables interpreting the 8-bit C1 control bytes
+// 0x84 (IND, index), 0x85 (NEL, next line) and 0x8D (RI, reverse index). These
+// bytes aren't valid UTF-8 on their own, so by default they are rendered as
+// U+FFFD. Only enable this for input that uses 8-bit controls. Bytes that are
+// part of a UTF-8 character, including one split between two Writes, are never
+// interpreted as controls.
+func WithC1Controls(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.c1Controls = enabled
+		return nil
+	}
+}
+
 // NewScreen creates a new screen with various options.
 func NewScreen(opts ...ScreenOption) (*Screen, error) {
 	s := &Screen{
 		// Arbitrarily chosen size, but 160 is double the traditional terminal
 		// width (80) and 100 is 4x the traditional terminal height (25).
 		// 160x100 also matches the buildkite-agent PTY size.
-		cols:  160,
-		lines: 100,
+		cols:     160,
+		lines:    100,
+		tabWidth: 8,
 		parser: parser{
 			mode: parserModeNormal,
 		},
@@ -111,26 +503,46 @@ func (s *Screen) SetSize(cols, lines int) error {
 	if s.maxLines > 0 && lines > s.maxLines {
 		return fmt.Errorf("lines greater than max [%d > %d]", lines, s.maxLines)
 	}
+	if cols < s.cols {
+		for i := s.top(); i < len(s.screen); i++ {
+			s.screen[i].fitWideChar(cols, s.wideCharResize)
+		}
+	}
 	s.cols, s.lines = cols, lines
+	s.marginTop, s.marginBottom = 0, 0
 	return nil
 }
 
+// maxANSIInt is the largest value ansiInt returns. Like xterm, larger
+// parameters are treated as this value, so that they can't drive cursor
+// arithmetic to extremes.
+const maxANSIInt = 65535
+
 // ansiInt parses s as a decimal integer. If s is empty or malformed, it
-// returns 1.
+// returns 1. Values greater than maxANSIInt are clamped to maxANSIInt.
 func ansiInt(s string) int {
 	if s == "" {
 		return 1
 	}
 	i, err := strconv.Atoi(s)
 	if err != nil {
+		if errors.Is(err, strconv.ErrRange) && s[0] != '-' {
+			return maxANSIInt
+		}
 		return 1
 	}
-	return i
+	return min(i, maxANSIInt)
 }
 
 // Move the cursor up, if we can
 func (s *Screen) up(i string) {
+	s.leaveLine()
+	inRegion := s.inScrollRegion()
 	s.y -= ansiInt(i)
+	if inRegion && s.y < s.marginTop {
+		// Cursor movement stops at the edges of the scroll region.
+		s.y = s.marginTop
+	}
 	if s.y < 0 {
 		s.CursorUpOOB++
 		s.y = 0
@@ -139,7 +551,12 @@ func (s *Screen) up(i string) {
 
 // Move the cursor down, if we can
 func (s *Screen) down(i string) {
+	s.leaveLine()
+	inRegion := s.inScrollRegion()
 	s.y += ansiInt(i)
+	if inRegion && s.y > s.marginBottom {
+		s.y = s.marginBottom
+	}
 	if s.y >= s.lines {
 		s.CursorDownOOB++
 		s.y = s.lines - 1
@@ -185,14 +602,18 @@ func (s *Screen) currentLine() *screenLine {
 // currentLineForWriting returns the line the cursor is on, or if there is no
 // line allocated in the buffer yet, allocates a new line and ensures it has
 // enough nodes to write something at the cursor position.
+//
+// If the cursor is several lines below the end of the buffer, the lines in
+// between are added too, but they are left empty: only the line being written
+// is given room for nodes. Since cursor movement is bounded by the window, at
+// most s.lines lines are added by a single call.
 func (s *Screen) currentLineForWriting() *screenLine {
 	// Ensure there are enough lines on screen to start writing here.
 	for s.currentLine() == nil {
 		// If maxLines is not in use, or adding a new line would not make it
 		// larger than maxLines, then just allocate a new line.
 		if s.maxLines <= 0 || len(s.screen)+1 <= s.maxLines {
-			newLine := screenLine{nodes: make([]node, 0, s.cols)}
-			s.screen = append(s.screen, newLine)
+			s.screen = append(s.screen, screenLine{})
 			if s.y >= s.lines {
 				// Because the "window" is always the last s.lines of s.screen
 				// (or all of them, if there are fewer lines than s.lines)
@@ -205,15 +626,12 @@ func (s *Screen) currentLineForWriting() *screenLine {
 
 		// maxLines is in effect, and adding a new line would make the screen
 		// larger than maxLines.
-		// Pass the line being scrolled out to scrollOutFunc, if not nil.
-		if s.ScrollOutFunc != nil {
-			s.ScrollOutFunc(s.screen[0].asHTML())
-		}
+		s.passScrolledOut(0)
 		s.LinesScrolledOut++
 
 		// Trim the first line off the top of the screen.
-		// Recycle its nodes slice to make a new line on the bottom.
-		newLine := screenLine{nodes: s.screen[0].nodes[:0]}
+		// Recycle its storage to make a new line on the bottom.
+		newLine := s.screen[0].recycled()
 		s.screen = append(s.screen[1:], newLine)
 
 		// Since the buffer scrolled down, leaving len(s.screen) unchanged,
@@ -221,32 +639,121 @@ func (s *Screen) currentLineForWriting() *screenLine {
 		s.y--
 	}
 
-	return s.currentLine()
+	line := s.currentLine()
+	if line.nodes == nil {
+		// Make room for a whole line up front, since it's about to be
+		// written.
+		line.nodes = make([]node, 0, s.cols)
+	}
+	if s.shellRegion != "" {
+		s.markShellRegion(line)
+	}
+	return line
 }
 
 // Write a character to the screen's current X&Y, along with the current screen style
 func (s *Screen) write(data rune) {
+	// Combining marks, etc, are added to the previous character rather than
+	// occupying cells of their own.
+	if s.joinCluster(data) {
+		return
+	}
+	if isFormatChar(data) {
+		// Formatting characters that couldn't be attached to the previous
+		// character are dropped.
+		return
+	}
+	width := s.runeWidth(data)
+
 	// Handle line wrapping
 	// Doing this at write time allows the cursor to be positioned past the end,
 	// as would happen if the entire line (including the last column) was
 	// written to, but doesn't allow writing past the last column.
-	if s.x >= s.cols {
-		s.x = 0
-		s.y++
+	// A wide character that doesn't fit in the last column also wraps.
+	if s.x >= s.cols || (width == 2 && s.x == s.cols-1 && s.cols > 1) {
+		s.wrapOrStick(width)
 	}
 
+	s.writeCells(data, width)
+}
+
+// writeCells writes a character occupying width cells at the cursor, and
+// moves the cursor past it. The cells after the first are continuation nodes.
+func (s *Screen) writeCells(data rune, width int) {
+	s.overwriting()
+	s.crWritten = max(s.crWritten, s.x+width)
+
 	line := s.currentLineForWriting()
+	if s.insertMode {
+		line.insertBlanks(s.x, width, s.cols)
+	}
+	for i := range width {
+		line.breakMultiCell(s.x + i)
+	}
 	line.writeNode(s.x, node{blob: data, style: s.style})
+	cont := s.style
+	cont.setCont(true)
+	for i := 1; i < width; i++ {
+		line.writeNode(s.x+i, node{blob: ' ', style: cont})
+	}
 
 	// OSC 8 links work like a style.
 	if s.style.hyperlink() {
 		if line.hyperlinks == nil {
 			line.hyperlinks = make(map[int]string)
 		}
-		line.hyperlinks[s.x] = s.urlBrush
+		for i := range width {
+			line.hyperlinks[s.x+i] = s.urlBrush
+		}
+	}
+	if s.style.tooltip() {
+		if line.tooltips == nil {
+			line.tooltips = make(map[int]string)
+		}
+		for i := range width {
+			line.tooltips[s.x+i] = s.tooltipBrush
+		}
+	}
+	if s.recordInput {
+		line.recordSource(s.x, 1, s.writeOffset)
 	}
 
-	s.x++
+	s.x += width
+}
+
+// joinCluster adds r to the grapheme cluster of the character before the
+// cursor, if r continues that cluster. It reports whether it did.
+//
+// This handles the common cases of grapheme clusters rather than the full
+// Unicode segmentation rules: combining marks and other extending characters,
+// emoji ZWJ sequences (the character after a ZWJ is always joined), and
+// pairs of regional indicators (flags). With FormatCharsAttach, formatting
+// characters (see isFormatChar) are joined too.
+func (s *Screen) joinCluster(r rune) bool {
+	line := s.currentLine()
+	x := s.x - 1
+	if line == nil || x < 0 || x >= len(line.nodes) {
+		return false
+	}
+	if line.nodes[x].style.cont() && x > 0 {
+		x--
+	}
+	prev := line.nodes[x]
+	if prev.style.element() || prev.style.cont() || (prev.blob == '\t' && !prev.style.cluster()) {
+		return false
+	}
+
+	switch {
+	case extendsCluster(r):
+	case isFormatChar(r) && s.formatChars == FormatCharsAttach:
+	case prev.style.cluster() && strings.HasSuffix(line.graphemes[prev.blob], string(zeroWidthJoiner)):
+	case isRegionalIndicator(r) && !prev.style.cluster() && isRegionalIndicator(prev.blob):
+	default:
+		return false
+	}
+
+	line.appendToCluster(x, r)
+	return true
 }
 
 // Append a character to the screen
@@ -254,6 +761,100 @@ func (s *Screen) append(data rune) {
 	s.write(data)
 }
 
+// appendRun appends a run of printable ASCII characters (see isPlainASCII)
+// to the screen. This has the same effect as appending each character in
+// turn, but is faster, because whole stretches of the line are written at
+// once.
+func (s *Screen) appendRun(run []byte) {
+	if len(run) == 0 {
+		return
+	}
+	// The first character may join the previous character's cluster (e.g.
+	// after a ZWJ), so write it normally. The rest can't.
+	s.write(rune(run[0]))
+	run = run[1:]
+	offset := s.writeOffset + 1
+
+	for len(run) > 0 {
+		if s.x >= s.cols && !s.PrivateMode(7) {
+			// Without autowrap, each character overwrites the last column,
+			// so only the last one is left.
+			offset += len(run) - 1
+			run = run[len(run)-1:]
+		}
+		if s.x >= s.cols {
+			s.wrapOrStick(1)
+		}
+		n := min(len(run), s.cols-s.x)
+		s.overwriting()
+		s.crWritten = max(s.crWritten, s.x+n)
+
+		line := s.currentLineForWriting()
+		// Only multi-cell characters overlapping the ends of the stretch
+		// need breaking up; the rest are entirely overwritten.
+		line.breakMultiCell(s.x)
+		line.breakMultiCell(s.x + n - 1)
+		for len(line.nodes) < s.x {
+			line.nodes = append(line.nodes, emptyNode)
+		}
+		for i, b := range run[:n] {
+			nd := node{blob: rune(b), style: s.style}
+			if s.x+i < len(line.nodes) {
+				line.nodes[s.x+i] = nd
+			} else {
+				line.nodes = append(line.nodes, nd)
+			}
+		}
+
+		if s.style.hyperlink() {
+			if line.hyperlinks == nil {
+				line.hyperlinks = make(map[int]string)
+			}
+			for i := range n {
+				line.hyperlinks[s.x+i] = s.urlBrush
+			}
+		}
+		if s.style.tooltip() {
+			if line.tooltips == nil {
+				line.tooltips = make(map[int]string)
+			}
+			for i := range n {
+				line.tooltips[s.x+i] = s.tooltipBrush
+			}
+		}
+		if s.recordInput {
+			for i := range n {
+				line.recordSource(s.x+i, 1, offset+i)
+			}
+			offset += n
+		}
+
+		s.x += n
+		run = run[n:]
+	}
+}
+
+// wrap moves the cursor to the start of the next line, because the text has
+// reached the end of the line, and marks the next line as continuing it.
+func (s *Screen) wrap() {
+	s.x = 0
+	s.moveDown()
+	s.crPending, s.crWritten = false, 0
+	s.currentLineForWriting().wrapped = true
+}
+
+// wrapOrStick makes room to write a character of the given width that
+// doesn't fit in the rest of the line. With autowrap (DECAWM, CSI ?7h) on,
+// the default, it wraps to the next line; with it off (CSI ?7l), the cursor
+// stays on the line, so that the character overwrites the last column.
+func (s *Screen) wrapOrStick(width int) {
+	if s.PrivateMode(7) {
+		s.wrap()
+		return
+	}
+	s.x = max(s.cols-width, 0)
+}
+
 // Append multiple characters to the screen
 func (s *Screen) appendMany(data []rune) {
 	for _, char := range data {
@@ -264,47 +865,50 @@ func (s *Screen) appendMany(data []rune) {
 func (s *Screen) appendElement(i *element) {
 	// Handle wrapping. See comment in [write].
 	if s.x >= s.cols {
-		s.x = 0
-		s.y++
+		s.wrapOrStick(1)
 	}
+	s.overwriting()
+	s.crWritten = max(s.crWritten, s.x+1)
 
 	line := s.currentLineForWriting()
+	if s.insertMode {
+		line.insertBlanks(s.x, 1, s.cols)
+	}
+	line.breakMultiCell(s.x)
 	idx := len(line.elements)
 	line.elements = append(line.elements, i)
 	ns := s.style
 	ns.setElement(true)
 
 	line.writeNode(s.x, node{blob: rune(idx), style: ns})
+	if s.recordInput {
+		line.recordSource(s.x, 1, s.writeOffset)
+	}
 	s.x++
 }
 
 // Set line metadata. Merges the provided data into any existing
 // metadata for the current line, overwriting data when keys collide.
 func (s *Screen) setLineMetadata(namespace string, data map[string]string) {
-	line := s.currentLineForWriting()
-	if line.metadata == nil {
-		line.metadata = map[string]map[string]string{
-			namespace: data,
-		}
-		return
-	}
-
-	ns := line.metadata[namespace]
-	if ns == nil {
-		// namespace did not exist, set all data
-		line.metadata[namespace] = data
-		return
-	}
+	s.currentLineForWriting().mergeMetadata(namespace, data)
+}
 
-	// copy new data over old data
-	for k, v := range data {
-		ns[k] = v
-	}
+// ResetStyle resets the current style to the default, and ends any OSC 8
+// hyperlink or OSC 1340 tooltip, without changing the contents of the screen
+// or the cursor position. This is useful when writing content between chunks
+// of input, so that the style of one doesn't carry over into the other.
+func (s *Screen) ResetStyle() {
+	s.style = style{}
+	s.urlBrush = ""
+	s.tooltipBrush = ""
 }
 
 // Apply color instruction codes to the screen's current style
 func (s *Screen) color(i []string) {
 	s.style = s.style.color(i)
+	if !s.keepUnknownSGR {
+		s.style.unknown = [unknownSGRLimit]uint8{}
+	}
 }
 
 // Apply an escape sequence to the screen
@@ -318,14 +922,40 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 		return instructions[i]
 	}
 
-	if strings.HasPrefix(inst(0), "?") {
+	if p := inst(0); p != "" && strings.ContainsRune("<=>", rune(p[0])) {
+		if code == 'c' && (p == ">" || p == ">0") {
+			// Secondary Device Attributes
+			s.reply(secondaryDeviceAttributes)
+			return
+		}
+
+		// Private sequences using these markers include:
+		// - Kitty keyboard protocol: CSI > flags u (push), CSI < u (pop),
+		//   CSI = flags ; mode u (set)
+		// - xterm modifyOtherKeys: CSI > 4 ; 1 m
+		// None are relevant. Discarding them here also prevents them being
+		// mistaken for the un-marked sequences with the same final
+		// character (restore cursor, SGR, ...).
+		return
+	}
+
+	if p, ok := strings.CutPrefix(inst(0), "?"); ok {
 		// These are typically "private" control sequences, e.g.
-		// - show/hide cursor (not relevant)
+		// - show/hide cursor (tracked, see PrivateMode)
 		// - enable/disable focus reporting (not relevant)
-		// - alternate screen buffer (not implemented)
-		// - bracketed paste mode (not relevant)
+		// - alternate screen buffer (tracked, but not implemented)
+		// - bracketed paste mode (tracked)
+		// - synchronized update (see UpdateFunc)
 		// Particularly, "show cursor" is CSI ?25h, which would be picked up
 		// below if we didn't handle it.
+		switch code {
+		case 'h', 'l':
+			modes := append([]string{p}, instructions[1:]...)
+			s.setPrivateModes(modes, code == 'h')
+
+		case 'J', 'K':
+			s.selectiveErase(code, p)
+		}
 		return
 	}
 
@@ -336,7 +966,7 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 	case 'B': // Cursor Down: go down n
 		s.down(inst(0))
 
-	case 'C': // Cursor Forward: go right n
+	case 'C', 'a': // Cursor Forward (or HPR): go right n
 		s.forward(inst(0))
 
 	case 'D': // Cursor Back: go left n
@@ -350,12 +980,12 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 		s.x = 0
 		s.up(inst(0))
 
-	case 'G': // Cursor Horizontal Absolute: Go to column n (default 1)
+	case 'G', '`': // Cursor Horizontal Absolute (or HPA): Go to column n (default 1)
 		s.x = ansiInt(inst(0)) - 1
 		s.x = max(s.x, 0)
 		s.x = min(s.x, s.cols-1)
 
-	case 'H': // Cursor Position Absolute: Go to row n and column m (default 1;1).
+	case 'H', 'f': // Cursor Position Absolute (or HVP): Go to row n and column m (default 1;1).
 		//
 		// There are a variety of agent versions still in use, which have
 		// different PTY window settings. Although we emulate a window size
@@ -384,6 +1014,20 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 		// "newline" is only needed to preserve intermediate output, we only
 		// need to insert one - multiple CSI H codes without content in between
 		// only need one "newline".
+		//
+		// Once a scroll region is set, though, the program has laid out the
+		// window (for example, a status line below the region), so rows are
+		// positioned absolutely.
+		s.leaveLine()
+		if s.hasScrollRegion() {
+			s.y = ansiInt(inst(0)) - 1
+			s.y = max(s.y, 0)
+			s.y = min(s.y, s.lines-1)
+			s.x = ansiInt(inst(1)) - 1
+			s.x = max(s.x, 0)
+			s.x = min(s.x, s.cols-1)
+			break
+		}
 		var metadata map[string]string
 		if line := s.currentLine(); line != nil && len(line.nodes) > 0 {
 			// clone required since setLineMetadata assumes it can own the map
@@ -397,6 +1041,14 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 			s.setLineMetadata(bkNamespace, metadata)
 		}
 
+	case 'h', 'l': // Set Mode, Reset Mode
+		// Of the ANSI modes, only insert mode (IRM, 4) matters to us.
+		for _, mode := range instructions {
+			if mode == "4" {
+				s.insertMode = code == 'h'
+			}
+		}
+
 	case 'J': // Erase in Display: Clears part of the screen.
 		switch inst(0) {
 		case "0", "": // "erase from current position to end (inclusive)"
@@ -405,6 +1057,9 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 			// Rather than truncate s.screen, clear each following line.
 			// There's a good chance those lines will be used later, and it
 			// avoids having to do maths to fix the cursor position.
+			// If the cursor is below the last allocated line, currentLine is
+			// nil and start is past the end, so nothing is cleared (and no
+			// lines are allocated).
 			start := s.top() + s.y + 1
 			for i := start; i < len(s.screen); i++ {
 				s.screen[i].clearAll()
@@ -425,12 +1080,18 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 			// 2: "erase entire display"
 			// Previous implementations performed this the same as ESC [3J,
 			// which also removes all "scroll-back".
+			if s.ClearFunc != nil {
+				s.ClearFunc(2)
+			}
 			for i := s.top(); i < len(s.screen); i++ {
 				s.screen[i].clearAll()
 			}
 
 		case "3":
 			// 3: "erase whole display including scroll-back buffer"
+			if s.ClearFunc != nil {
+				s.ClearFunc(3)
+			}
 			for i := range s.screen {
 				s.screen[i].clearAll()
 			}
@@ -450,52 +1111,566 @@ func (s *Screen) applyEscape(code rune, instructions []string) {
 
 	case 'M':
 		s.color(instructions)
+
+	case '@', 'P', 'X':
+		// A count of 0 is the same as 1, and more than the width of the
+		// screen is the same as the width.
+		n := min(max(ansiInt(inst(0)), 1), s.cols)
+		line := s.currentLine()
+		if line == nil {
+			break
+		}
+		switch code {
+		case '@': // Insert Characters: insert n blanks at the cursor
+			line.insertBlanks(s.x, n, s.cols)
+		case 'P': // Delete Characters: delete n characters at the cursor
+			line.deleteCells(s.x, n)
+		case 'X': // Erase Characters: erase n characters from the cursor
+			line.clear(s.x, s.x+n-1)
+		}
+
+	case 'c': // Primary Device Attributes
+		if p := inst(0); p == "" || p == "0" {
+			s.reply(primaryDeviceAttributes)
+		}
+
+	case 'n': // Device Status Report
+		switch inst(0) {
+		case "5": // Status: always OK
+			s.reply("\x1b[0n")
+
+		case "6": // Cursor Position Report. If the cursor is past the last
+			// column (after writing in the last column), report the last
+			// column.
+			s.reply(fmt.Sprintf("\x1b[%d;%dR", s.y+1, min(s.x, s.cols-1)+1))
+		}
+
+	case 'r': // Set Top and Bottom Margins (DECSTBM)
+		s.setScrollRegion(inst(0), inst(1))
+
+	case 's': // Save Cursor Position (SCOSC)
+		s.saveCursor()
+
+	case 'u': // Restore Cursor Position (SCORC)
+		s.restoreCursor()
+
+	case 't': // Window manipulation (XTWINOPS)
+		// Of these, only size reports and saving and restoring the title
+		// matter to us.
+		switch inst(0) {
+		case "14": // Report text area size in pixels
+			if s.cellWidth > 0 {
+				s.reply(fmt.Sprintf("\x1b[4;%d;%dt", s.lines*s.cellHeight, s.cols*s.cellWidth))
+			}
+			return
+
+		case "16": // Report cell size in pixels
+			if s.cellWidth > 0 {
+				s.reply(fmt.Sprintf("\x1b[6;%d;%dt", s.cellHeight, s.cellWidth))
+			}
+			return
+
+		case "18": // Report text area size in characters
+			s.reply(fmt.Sprintf("\x1b[8;%d;%dt", s.lines, s.cols))
+			return
+		}
+
+		// The second parameter selects the icon name (1), the window title
+		// (2), or both (0 or omitted). Only the window title is tracked.
+		if inst(1) == "1" {
+			return
+		}
+		switch inst(0) {
+		case "22": // Save title on stack
+			s.pushTitle()
+
+		case "23": // Restore title from stack
+			s.popTitle()
+		}
+	}
+}
+
+// alignmentTest implements DECALN: it fills the window with 'E' and moves the
+// cursor to the top left.
+func (s *Screen) alignmentTest() {
+	s.leaveLine()
+	for s.y = 0; s.y < s.lines; s.y++ {
+		line := s.currentLineForWriting()
+		*line = screenLine{nodes: line.nodes[:0]}
+		for range s.cols {
+			line.nodes = append(line.nodes, node{blob: 'E'})
+		}
+	}
+	s.x, s.y = 0, 0
+}
+
+// applyIntermediateEscape applies a control sequence that includes
+// intermediate bytes (such as CSI 1 " q). It reports whether the sequence was
+// recognised. (Unrecognised sequences are treated as text, like other broken
+// escape sequences.)
+func (s *Screen) applyIntermediateEscape(intermediates string, code rune, instructions []string) bool {
+	apply, ok := intermediateEscapes[intermediates+string(code)]
+	if ok {
+		apply(s, instructions)
+	}
+	return ok
+}
+
+// intermediateEscapes are the control sequences with intermediate bytes that
+// are supported, by their intermediate bytes and final character.
+var intermediateEscapes = map[string]func(s *Screen, instructions []string){
+	// Select character protection attribute (DECSCA)
+	`"q`: func(s *Screen, instructions []string) {
+		// 1 is protected; 0, 2 and none are unprotected.
+		s.style.setProtected(len(instructions) > 0 && instructions[0] == "1")
+	},
+
+	// Set cursor style (DECSCUSR): not relevant
+	" q": func(*Screen, []string) {},
+
+	// Push SGR attributes (XTPUSHSGR)
+	"#{": func(s *Screen, _ []string) { s.pushSGR() },
+	"#p": func(s *Screen, _ []string) { s.pushSGR() },
+
+	// Pop SGR attributes (XTPOPSGR)
+	"#}": func(s *Screen, _ []string) { s.popSGR() },
+	"#q": func(s *Screen, _ []string) { s.popSGR() },
+}
+
+// The maximum depth of the SGR stack. xterm has the same limit.
+const sgrStackLimit = 10
+
+// pushSGR saves the current style (the SGR attributes: colours, bold, and so
+// on) on the SGR stack, for popSGR to restore. xterm allows choosing which
+// attributes to save, but all of them are saved regardless of the parameters.
+// If the stack is full, the oldest style is discarded.
+func (s *Screen) pushSGR() {
+	if len(s.sgrStack) >= sgrStackLimit {
+		s.sgrStack = s.sgrStack[1:]
+	}
+	s.sgrStack = append(s.sgrStack, s.style.visual())
+}
+
+// popSGR restores the style last saved by pushSGR. Links, tooltips and
+// character protection aren't SGR attributes, so are unchanged. If no style
+// is saved, it does nothing.
+func (s *Screen) popSGR() {
+	if len(s.sgrStack) == 0 {
+		return
+	}
+	n := len(s.sgrStack) - 1
+	saved := s.sgrStack[n]
+	s.sgrStack = s.sgrStack[:n]
+	saved.flags |= s.style.flags & sbNonVisual
+	s.style = saved
+}
+
+// selectiveErase implements DECSED (CSI ? J) and DECSEL (CSI ? K). These are
+// like ED and EL (CSI J and CSI K), but leave protected cells (see DECSCA)
+// unchanged. Unlike ED and EL, lines are never truncated.
+func (s *Screen) selectiveErase(code rune, param string) {
+	// Range of lines (within the buffer) to clear entirely.
+	top := s.top()
+	cursor := top + s.y
+	var linesFrom, linesTo int
+
+	switch param {
+	case "0", "": // From the cursor to the end (inclusive)
+		s.currentLine().selectiveBlank(s.x, screenEndOfLine)
+		linesFrom, linesTo = cursor+1, len(s.screen)
+
+	case "1": // From the beginning to the cursor (inclusive)
+		s.currentLine().selectiveBlank(screenStartOfLine, s.x)
+		linesFrom, linesTo = top, cursor
+
+	case "2": // All
+		s.currentLine().selectiveBlank(screenStartOfLine, screenEndOfLine)
+		linesFrom, linesTo = top, len(s.screen)
+
+	default:
+		return
+	}
+
+	if code == 'K' {
+		return
+	}
+	for i := linesFrom; i < min(linesTo, len(s.screen)); i++ {
+		if i != cursor {
+			s.screen[i].selectiveBlank(screenStartOfLine, screenEndOfLine)
+		}
+	}
+}
+
+// trackedPrivateModes are the DEC private modes whose state is recorded (see
+// PrivateMode), with their initial states.
+var trackedPrivateModes = map[int]bool{
+	1:    false, // DECCKM: application cursor keys
+	7:    true,  // DECAWM: autowrap
+	9:    false, // X10 mouse reporting
+	25:   true,  // DECTCEM: cursor visible
+	47:   false, // alternate screen buffer
+	1000: false, // mouse tracking: press and release
+	1002: false, // mouse tracking: button motion
+	1003: false, // mouse tracking: any motion
+	1004: false, // focus reports
+	1006: false, // SGR mouse reports
+	1007: false, // alternate scroll: the mouse wheel sends cursor keys
+	1015: false, // urxvt mouse reports
+	1047: false, // alternate screen buffer
+	1049: false, // alternate screen buffer, saving the cursor
+	2004: false, // bracketed paste
+	2026: false, // synchronized update
+}
+
+// mouseTracking reports whether any mouse tracking mode is set, in which case
+// CSI M with several parameters is a mouse report.
+func (s *Screen) mouseTracking() bool {
+	return s.PrivateMode(9) || s.PrivateMode(1000) || s.PrivateMode(1002) || s.PrivateMode(1003)
+}
+
+// setPrivateModes sets (CSI ? ... h) or resets (CSI ? ... l) DEC private
+// modes. Several modes can be set or reset at once (e.g. CSI ?25;1049h), and
+// each is handled separately. The state of some modes is recorded (see
+// PrivateMode), but most don't affect the output.
+func (s *Screen) setPrivateModes(modes []string, set bool) {
+	for _, mode := range modes {
+		n, err := strconv.Atoi(mode)
+		if err != nil {
+			continue
+		}
+		if _, tracked := trackedPrivateModes[n]; tracked {
+			if s.privateModes == nil {
+				s.privateModes = make(map[int]bool)
+			}
+			s.privateModes[n] = set
+		}
+
+		switch n {
+		case 2026: // Synchronized update
+			s.syncUpdate = set
+			s.notifyUpdate()
+		}
+	}
+}
+
+// PrivateMode reports whether a DEC private mode is set, for example 25
+// (cursor visible) or 2004 (bracketed paste). Only the modes in
+// trackedPrivateModes are recorded; it returns false for all others.
+// Setting a mode often changes what a terminal should send to the program
+// producing the output, which matters when passing input back to it.
+func (s *Screen) PrivateMode(mode int) bool {
+	if set, ok := s.privateModes[mode]; ok {
+		return set
+	}
+	return trackedPrivateModes[mode]
+}
+
+// notifyUpdate calls UpdateFunc if the screen may have changed since the last
+// call, unless a synchronized update is in progress.
+func (s *Screen) notifyUpdate() {
+	if s.UpdateFunc == nil || !s.dirty || s.syncUpdate {
+		return
+	}
+	s.dirty = false
+	s.UpdateFunc()
+}
+
+// Replies to device attributes queries.
+const (
+	primaryDeviceAttributes   = "\x1b[?62;22c"
+	secondaryDeviceAttributes = "\x1b[>0;0;0c"
+)
+
+// reply writes a reply to a query to the reply writer, if there is one.
+func (s *Screen) reply(r string) {
+	if s.replyWriter != nil {
+		io.WriteString(s.replyWriter, r)
+	}
+}
+
+// requestStatusString replies to a DECRQSS query for the given setting. Only
+// the current SGR (setting "m") is supported; other queries are answered as
+// invalid.
+func (s *Screen) requestStatusString(setting string) {
+	switch setting {
+	case "m":
+		params := append([]string{"0"}, sgrParams(style{}, s.style.visual())...)
+		s.reply("\x1bP1$r" + strings.Join(params, ";") + "m\x1b\\")
+	default:
+		s.reply("\x1bP0$r\x1b\\")
+	}
+}
+
+// The maximum depth of the title stack. xterm has the same limit.
+const titleStackLimit = 10
+
+// pushTitle saves the current title on the title stack. If the stack is full,
+// the oldest title is discarded.
+func (s *Screen) pushTitle() {
+	if len(s.titleStack) >= titleStackLimit {
+		s.titleStack = s.titleStack[1:]
 	}
+	s.titleStack = append(s.titleStack, s.title)
+}
+
+// popTitle restores the most recently saved title from the title stack. If the
+// stack is empty, the title is unchanged.
+func (s *Screen) popTitle() {
+	if len(s.titleStack) == 0 {
+		return
+	}
+	n := len(s.titleStack) - 1
+	s.title, s.titleStack = s.titleStack[n], s.titleStack[:n]
+}
+
+// Title returns the window title, as most recently set with OSC 0 or OSC 2
+// (or restored with CSI 23 t).
+func (s *Screen) Title() string {
+	return s.title
+}
+
+// CursorKeyMode is the mode of the cursor (arrow) keys, set with DECCKM
+// (CSI ?1h and CSI ?1l). It determines the sequences a terminal sends for
+// them.
+type CursorKeyMode int
+
+const (
+	// CursorKeysNormal is the default mode, in which the cursor keys send
+	// CSI sequences, such as ESC [ A for up.
+	CursorKeysNormal CursorKeyMode = iota
+
+	// CursorKeysApplication is the mode set by CSI ?1h, in which the cursor
+	// keys send SS3 sequences, such as ESC O A for up.
+	CursorKeysApplication
+)
+
+// CursorKeyMode returns the current cursor key mode, which is useful when
+// passing keyboard input back to the program producing the output.
+func (s *Screen) CursorKeyMode() CursorKeyMode {
+	if s.PrivateMode(1) {
+		return CursorKeysApplication
+	}
+	return CursorKeysNormal
+}
+
+// saveCursor saves the cursor position, for restoring with restoreCursor.
+func (s *Screen) saveCursor() {
+	s.savedCursor = position{x: s.x, y: s.y}
+}
+
+// restoreCursor moves the cursor to the position last saved by saveCursor.
+func (s *Screen) restoreCursor() {
+	s.leaveLine()
+	s.x, s.y = s.savedCursor.x, s.savedCursor.y
 }
 
 // Write writes ANSI text to the screen.
 func (s *Screen) Write(input []byte) (int, error) {
+	if s.recordInput {
+		s.record(input)
+	}
 	s.parser.parseToScreen(input)
+	s.notifyUpdate()
 	return len(input), nil
 }
 
+// Pending returns the number of bytes written that haven't been processed yet,
+// because they are the start of an escape sequence that hasn't ended (or of a
+// multibyte UTF-8 character). These are processed by a later Write that
+// completes the sequence. If the input has ended and Pending is not zero, the
+// input ended mid-sequence.
+func (s *Screen) Pending() int {
+	return len(s.parser.remainder)
+}
+
 // AsHTML returns the contents of the current screen buffer as HTML.
 func (s *Screen) AsHTML() string {
-	lines := make([]string, 0, len(s.screen))
+	var b strings.Builder
+	s.writeHTMLLines(&b, s.firstRenderedLine(true), htmlLinesOptions{})
+	return b.String()
+}
+
+// WriteHTMLTo writes the contents of the current screen buffer to w as HTML,
+// the same as AsHTML, but without building the whole output in memory first.
+// It returns the number of bytes written.
+func (s *Screen) WriteHTMLTo(w io.Writer) (int64, error) {
+	return s.writeHTMLLines(w, s.firstRenderedLine(true), htmlLinesOptions{})
+}
 
-	for _, line := range s.screen {
-		lines = append(lines, line.asHTML())
+// passScrolledOut passes the line at row i, which is being scrolled out, to
+// ScrollOutFunc and ScrollOutLineFunc, if not nil.
+func (s *Screen) passScrolledOut(i int) {
+	if s.ScrollOutFunc != nil {
+		s.ScrollOutFunc(s.lineHTML(i, nil))
+	}
+	if s.ScrollOutLineFunc != nil {
+		s.ScrollOutLineFunc(ScrollOutLine{
+			Number:   s.LinesScrolledOut + i + 1,
+			HTML:     s.lineHTML(i, nil),
+			Text:     s.screen[i].asPlain(&s.render),
+			Metadata: s.screen[i].metadata,
+		})
 	}
+}
 
-	return strings.Join(lines, "\n")
+// Flush scrolls out every line in the screen buffer, passing each to
+// ScrollOutFunc and ScrollOutLineFunc as if they had scrolled out of the top,
+// and leaves the screen empty with the cursor at the top left. Call it once
+// all the input has been written, so that a consumer of scrolled-out lines
+// also gets the lines that were still on the screen.
+func (s *Screen) Flush() {
+	s.leaveLine()
+	for i := range s.screen {
+		s.passScrolledOut(i)
+	}
+	s.LinesScrolledOut += len(s.screen)
+	s.screen = s.screen[:0]
+	s.x, s.y = 0, 0
 }
 
 // AsPlainText renders the screen without any ANSI style etc.
 func (s *Screen) AsPlainText() string {
-	lines := make([]string, 0, len(s.screen))
+	return s.render.joinLines(s.plainLines(s.firstRenderedLine(false), s.render.plainLinks))
+}
+
+// TailHTML returns the last n lines of the screen buffer as HTML, rendered
+// the same way as AsHTML. n is clamped to the number of lines in the buffer.
+// As with AsHTML, any blank lines at the end of the buffer are included.
+func (s *Screen) TailHTML(n int) string {
+	var b strings.Builder
+	s.writeHTMLLines(&b, s.tailStart(n), htmlLinesOptions{})
+	return b.String()
+}
+
+// TailText returns the last n lines of the screen buffer as plain text,
+// rendered the same way as AsPlainText. n is clamped to the number of lines in
+// the buffer. As with AsPlainText, any blank lines at the end of the buffer are
+// included.
+func (s *Screen) TailText(n int) string {
+	rendered, opts := s.renderedLines(s.tailStart(n))
+	lines := make([]string, 0, len(rendered))
+
+	for _, line := range rendered {
+		lines = append(lines, line.asPlain(opts))
+	}
 
-	for _, line := range s.screen {
-		lines = append(lines, line.asPlain())
+	return s.render.joinLines(lines)
+}
+
+// LineHTML returns the line at the given row of the screen buffer (including
+// any lines above the window) as HTML, rendered the same way as in AsHTML. It
+// returns false if the row is out of range.
+func (s *Screen) LineHTML(row int) (string, bool) {
+	if row < 0 || row >= len(s.screen) {
+		return "", false
 	}
+	return s.lineHTML(row, nil), true
+}
 
-	return strings.Join(lines, "\n")
+// LineText returns the line at the given row of the screen buffer (including
+// any lines above the window) as plain text, rendered the same way as in
+// AsPlainText. It returns false if the row is out of range.
+func (s *Screen) LineText(row int) (string, bool) {
+	if row < 0 || row >= len(s.screen) {
+		return "", false
+	}
+	return s.screen[row].asPlain(&s.render), true
+}
+
+// tailStart returns the index of the first of the last n lines in the buffer.
+func (s *Screen) tailStart(n int) int {
+	return max(len(s.screen)-max(n, 0), 0)
 }
 
 func (s *Screen) newLine() {
+	s.index()
 	s.x = 0
-	s.y++
+}
+
+// index moves the cursor down a line, without changing the column.
+func (s *Screen) index() {
+	s.leaveLine()
+	s.moveDown()
 }
 
 func (s *Screen) revNewLine() {
-	if s.y > 0 {
-		s.y--
-	}
+	s.leaveLine()
+	s.moveUp()
+}
+
+// leaveLine is called before the cursor moves to another line. It finishes
+// the current line for WithCRClearsToEnd, and forgets the CR state, which
+// only applies to the line it happened on.
+func (s *Screen) leaveLine() {
+	s.clearAfterCR()
+	s.crPending, s.crWritten = false, 0
+	s.overwritePending = ""
 }
 
 func (s *Screen) carriageReturn() {
+	s.clearAfterCR()
+	if !s.crPending {
+		s.overwriteFrames = 0
+	}
+	if s.OverwriteFunc != nil && s.overwriteFrames < overwriteFrameLimit {
+		if line := s.currentLine(); line != nil && len(line.nodes) > 0 {
+			s.overwritePending = s.lineHTML(s.top()+s.y, nil)
+		}
+	}
+	if !s.crPending || s.crWritten > 0 {
+		// Either the first CR on this line, or something was written since the
+		// last one (and anything beyond it was just cleared).
+		s.crPrev = s.crWritten
+	}
+	s.crPending, s.crWritten = true, 0
 	s.x = 0
 }
 
+// The maximum number of frames of a line reported to OverwriteFunc.
+const overwriteFrameLimit = 100
+
+// overwriting reports the line as it was at the last carriage return to
+// OverwriteFunc, if text is about to be written over it for the first time
+// since then.
+func (s *Screen) overwriting() {
+	if s.overwritePending == "" || !s.crPending || s.crWritten > 0 {
+		return
+	}
+	html := s.overwritePending
+	s.overwritePending = ""
+	s.overwriteFrames++
+	s.OverwriteFunc(html)
+}
+
+// clearAfterCR implements WithCRClearsToEnd: if enabled, and text was written
+// since the last CR that didn't reach the end of the text before it, the
+// remainder of the old text is cleared.
+func (s *Screen) clearAfterCR() {
+	if !s.crClearsToEnd || !s.crPending {
+		return
+	}
+	if s.crWritten > 0 && s.crWritten < s.crPrev {
+		s.currentLine().clear(s.crWritten, s.crPrev-1)
+	}
+}
+
+// tab moves the cursor to the next tab stop, or the last column if there are
+// no more tab stops. With TabPreserve, it writes a tab spanning the cells up
+// to the tab stop.
+func (s *Screen) tab() {
+	if s.x >= s.cols-1 {
+		// No more tab stops on this line.
+		return
+	}
+	next := min((s.x/s.tabWidth+1)*s.tabWidth, s.cols-1)
+
+	if s.tabMode == TabPreserve {
+		s.writeCells('\t', next-s.x)
+		return
+	}
+	s.x = next
+}
+
 func (s *Screen) backspace() {
 	if s.x > 0 {
 		s.x--
@@ -513,23 +1688,69 @@ type screenLine struct {
 	// (if node.style.element(), then elements[node.blob] is the element)
 	elements []*element
 
+	// cluster nodes refer to grapheme clusters in this slice by index
+	// (if node.style.cluster(), then graphemes[node.blob] is the cluster)
+	graphemes []string
+
 	// hyperlinks stores the URL targets for OSC 8 (iTerm-style) links
 	// by X position. URLs are too big to fit in every node, most lines won't
 	// have links and most nodes in a line won't be linked.
 	// So a map is used for sparse storage, only lazily created when text with
 	// a link style is written.
 	hyperlinks map[int]string
+
+	// tooltips stores the text of OSC 1340 tooltips by X position, in the
+	// same way as hyperlinks.
+	tooltips map[int]string
+
+	// sources stores the input offset of the character written to each cell
+	// by X position, only when recording input (see WithInputRecording).
+	sources map[int]int
+
+	// wrapped is true if the line continues the line before it, because the
+	// text reached the end of that line and wrapped.
+	wrapped bool
+
+	// ignored holds sequences written on the line that were dropped, only
+	// when enabled (see WithIgnoredAsComments).
+	ignored []string
 }
 
+// clearAll clears the whole line, keeping its metadata. Its storage is kept
+// for reuse, so that a program repeatedly clearing and redrawing the screen
+// (such as a progress display) doesn't allocate new lines each time.
 func (l *screenLine) clearAll() {
 	if l == nil {
 		return
 	}
-	l.nodes = l.nodes[:0]
+	metadata := l.metadata
+	*l = l.recycled()
+	l.metadata = metadata
+}
+
+// recycled returns an empty line (with no metadata) that reuses the storage of
+// l: its slices are truncated and its maps cleared. l must not be used
+// afterwards.
+func (l *screenLine) recycled() screenLine {
+	clear(l.elements)
+	clear(l.hyperlinks)
+	clear(l.tooltips)
+	clear(l.sources)
+	return screenLine{
+		nodes:      l.nodes[:0],
+		elements:   l.elements[:0],
+		graphemes:  l.graphemes[:0],
+		hyperlinks: l.hyperlinks,
+		tooltips:   l.tooltips,
+		sources:    l.sources,
+		ignored:    l.ignored[:0],
+	}
 }
 
 // clear clears part (or all) of a line. The range to clear is inclusive
-// of xStart and xEnd.
+// of xStart and xEnd. If the range reaches the end of the line, the line is
+// truncated at xStart; otherwise the range is blanked and the content after
+// xEnd is preserved.
 func (l *screenLine) clear(xStart, xEnd int) {
 	if l == nil {
 		return
@@ -548,17 +1769,181 @@ func (l *screenLine) clear(xStart, xEnd int) {
 		return
 	}
 
+	// A multi-cell character at either end of the range is erased entirely,
+	// rather than leaving part of it behind.
+	l.breakMultiCell(xStart)
+	if xEnd < len(l.nodes) {
+		l.breakMultiCell(xEnd)
+	}
+
 	if xEnd >= len(l.nodes)-1 {
-		// Clear from start to end of the line
-		l.nodes = l.nodes[:xStart]
+		// Clear from start to end of the line. Nothing follows the range, so
+		// there is nothing to preserve.
+		l.truncate(xStart)
+		return
+	}
+
+	l.blank(xStart, xEnd)
+}
+
+// selectiveBlank replaces the unprotected nodes from xStart to xEnd
+// (inclusive) with empty nodes. The range is clipped to the line. An
+// unprotected multi-cell character at either end of the range is erased
+// entirely.
+func (l *screenLine) selectiveBlank(xStart, xEnd int) {
+	if l == nil {
 		return
 	}
+	for _, x := range []int{max(xStart, 0), min(xEnd, len(l.nodes)-1)} {
+		if x < len(l.nodes) && !l.nodes[x].style.protected() {
+			l.breakMultiCell(x)
+		}
+	}
+	for i := max(xStart, 0); i <= min(xEnd, len(l.nodes)-1); i++ {
+		if !l.nodes[i].style.protected() {
+			l.nodes[i] = emptyNode
+		}
+	}
+}
 
+// blank replaces the nodes from xStart to xEnd (inclusive) with empty nodes.
+// The range must be within the line.
+func (l *screenLine) blank(xStart, xEnd int) {
 	for i := xStart; i <= xEnd; i++ {
 		l.nodes[i] = emptyNode
 	}
 }
 
+// truncate removes the nodes from x to the end of the line.
+func (l *screenLine) truncate(x int) {
+	l.nodes = l.nodes[:x]
+}
+
+// insertBlanks inserts n empty nodes at x, shifting the nodes from x onwards
+// right. Nodes shifted past cols are dropped. A multi-cell character split by
+// x, or one cut off at cols, is blanked.
+func (l *screenLine) insertBlanks(x, n, cols int) {
+	if x >= len(l.nodes) {
+		return
+	}
+	if l.nodes[x].style.cont() {
+		l.breakMultiCell(x)
+	}
+	if cols > n && cols-n < len(l.nodes) && l.nodes[cols-n].style.cont() {
+		l.breakMultiCell(cols - n)
+	}
+
+	l.nodes = append(l.nodes, make([]node, n)...)
+	copy(l.nodes[x+n:], l.nodes[x:])
+	for i := x; i < x+n; i++ {
+		l.nodes[i] = emptyNode
+	}
+	if len(l.nodes) > cols {
+		l.nodes = l.nodes[:cols]
+	}
+
+	l.hyperlinks = shiftKeys(l.hyperlinks, x, n, cols)
+	l.tooltips = shiftKeys(l.tooltips, x, n, cols)
+	l.sources = shiftKeys(l.sources, x, n, cols)
+}
+
+// deleteCells removes n nodes at x, shifting the nodes after them left. A
+// multi-cell character partly removed is blanked, since it is split.
+func (l *screenLine) deleteCells(x, n int) {
+	if l == nil || x >= len(l.nodes) {
+		return
+	}
+	end := min(x+n, len(l.nodes))
+	l.breakMultiCell(x)
+	if end < len(l.nodes) && l.nodes[end].style.cont() {
+		l.breakMultiCell(end)
+	}
+
+	l.nodes = append(l.nodes[:x], l.nodes[end:]...)
+
+	l.hyperlinks = dropKeys(l.hyperlinks, x, end-x)
+	l.tooltips = dropKeys(l.tooltips, x, end-x)
+	l.sources = dropKeys(l.sources, x, end-x)
+}
+
+// dropKeys removes the entries of m with keys from x to x+n (exclusive), and
+// moves those after them n lower, for nodes removed by deleteCells.
+func dropKeys[V any](m map[int]V, x, n int) map[int]V {
+	if len(m) == 0 {
+		return m
+	}
+	shifted := make(map[int]V, len(m))
+	for k, v := range m {
+		switch {
+		case k < x:
+			shifted[k] = v
+		case k >= x+n:
+			shifted[k-n] = v
+		}
+	}
+	return shifted
+}
+
+// shiftKeys moves the entries of m with keys from x onwards n higher, for
+// nodes shifted right by insertBlanks. Entries moved to cols or beyond are
+// dropped.
+func shiftKeys[V any](m map[int]V, x, n, cols int) map[int]V {
+	if len(m) == 0 {
+		return m
+	}
+	shifted := make(map[int]V, len(m))
+	for k, v := range m {
+		switch {
+		case k < x:
+			shifted[k] = v
+		case k+n < cols:
+			shifted[k+n] = v
+		}
+	}
+	return shifted
+}
+
+// breakMultiCell blanks the rest of a character occupying several cells (a
+// wide character or a preserved tab) that includes x, because x is about to be
+// overwritten.
+func (l *screenLine) breakMultiCell(x int) {
+	if x >= len(l.nodes) {
+		return
+	}
+	start := x
+	for start > 0 && l.nodes[start].style.cont() {
+		start--
+	}
+	end := x + 1
+	for end < len(l.nodes) && l.nodes[end].style.cont() {
+		end++
+	}
+	if end-start > 1 {
+		l.blank(start, end-1)
+	}
+}
+
+// appendToCluster adds r to the grapheme cluster of the node at x, first
+// turning the node into a cluster node if it is a single rune.
+func (l *screenLine) appendToCluster(x int, r rune) {
+	n := &l.nodes[x]
+	if n.style.cluster() {
+		l.graphemes[n.blob] += string(r)
+		return
+	}
+	l.graphemes = append(l.graphemes, string(n.blob)+string(r))
+	n.blob = rune(len(l.graphemes) - 1)
+	n.style.setCluster(true)
+}
+
+// text returns the text of a (non-element) node in the line.
+func (l *screenLine) text(n node) string {
+	if n.style.cluster() {
+		return l.graphemes[n.blob]
+	}
+	return string(n.blob)
+}
+
 func (l *screenLine) writeNode(x int, n node) {
 	// Add columns if currently shorter than the cursor's x position
 	for i := len(l.nodes); i <= x; i++ {
@@ -0,0 +1,156 @@
+package terminal
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// ScrollOutLine describes a line scrolled out of the screen, as passed to
+// ScrollOutLineFunc.
+type ScrollOutLine struct {
+	// Number is the 1-based number of the line among those scrolled out.
+	Number int
+
+	// HTML and Text are the line rendered as HTML and as plain text.
+	HTML, Text string
+
+	// Metadata is the line's metadata (e.g. Buildkite timestamps), keyed by
+	// namespace. It must not be modified.
+	Metadata map[string]map[string]string
+}
+
+// ndjsonLine is the JSON form of a ScrollOutLine written by
+// NewNDJSONScrollWriter.
+type ndjsonLine struct {
+	Line      int    `json:"line"`
+	HTML      string `json:"html"`
+	Text      string `json:"text"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// NewNDJSONScrollWriter returns a func, suitable for use as a
+// ScrollOutLineFunc, that writes each line to w as a JSON object followed by a
+// newline (newline-delimited JSON). Each object has "line", "html" and "text"
+// fields, and a "timestamp" field if the line has a Buildkite timestamp.
+// Write errors are ignored.
+func NewNDJSONScrollWriter(w io.Writer) func(ScrollOutLine) {
+	enc := json.NewEncoder(w)
+	return func(l ScrollOutLine) {
+		out := ndjsonLine{Line: l.Number, HTML: l.HTML, Text: l.Text}
+		if datetime, ok := bkDatetime(l.Metadata[bkNamespace]); ok {
+			out.Timestamp = datetime
+		}
+		enc.Encode(out)
+	}
+}
+
+// NewPlainTextScrollWriter returns a func, suitable for use as a
+// ScrollOutLineFunc, that writes the plain text of each line to w, followed
+// by a newline, for consumers that follow the output like tail -f. Call the
+// Screen's Flush method at the end of the input to write the lines still on
+// the screen. Write errors are ignored.
+func NewPlainTextScrollWriter(w io.Writer) func(ScrollOutLine) {
+	return func(l ScrollOutLine) {
+		io.WriteString(w, l.Text+"\n")
+	}
+}
+
+// ScrollOutDeduper collapses runs of consecutive identical scrolled-out lines,
+// such as those produced by animations that print each frame on a new line.
+// Use it by setting a Screen's ScrollOutFunc to the ScrollOut method.
+//
+// Because a run can only be known to have ended when a different line
+// arrives, the last run is held back until Flush is called.
+type ScrollOutDeduper struct {
+	// Func is called once for each run of identical lines, with the HTML of
+	// the line and the number of times it was repeated (at least 1).
+	Func func(lineHTML string, count int)
+
+	prev  string
+	count int
+}
+
+// NewScrollOutDeduper creates a ScrollOutDeduper that passes each run of
+// identical lines to f.
+func NewScrollOutDeduper(f func(lineHTML string, count int)) *ScrollOutDeduper {
+	return &ScrollOutDeduper{Func: f}
+}
+
+// ScrollOut receives a line scrolled out of the screen. It is suitable for
+// use as a ScrollOutFunc.
+func (d *ScrollOutDeduper) ScrollOut(lineHTML string) {
+	if d.count > 0 && lineHTML == d.prev {
+		d.count++
+		return
+	}
+	d.Flush()
+	d.prev, d.count = lineHTML, 1
+}
+
+// Flush passes any held-back run of lines to Func. Call it once no more lines
+// will be scrolled out, e.g. before rendering the rest of the screen.
+func (d *ScrollOutDeduper) Flush() {
+	if d.count == 0 {
+		return
+	}
+	d.Func(d.prev, d.count)
+	d.prev, d.count = "", 0
+}
+
+// AsciinemaHeader is the header of an asciinema v2 recording. See
+// https://docs.asciinema.org/manual/asciicast/v2/.
+type AsciinemaHeader struct {
+	// Width and Height are the terminal size, in columns and lines.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Timestamp is the start of the recording, written to the header and
+	// used as the zero point for event times. If it is zero, the header has
+	// no timestamp, and event times are relative to the first line with a
+	// Buildkite timestamp.
+	Timestamp time.Time `json:"-"`
+
+	// Title is the title of the recording, if not empty.
+	Title string `json:"title,omitempty"`
+}
+
+// NewAsciinemaWriter returns a func, suitable for use as a ScrollOutLineFunc,
+// that writes lines to w as an asciinema v2 recording: the header as a JSON
+// object, followed by an output event ([time, "o", data]) for each line. The
+// time of each event comes from the line's Buildkite timestamp, relative to
+// the start of the recording. Lines without a timestamp have the same time as
+// the line before. The data is the plain text of the line and a CRLF.
+// The header is written immediately. Write errors are ignored.
+func NewAsciinemaWriter(w io.Writer, header AsciinemaHeader) func(ScrollOutLine) {
+	enc := json.NewEncoder(w)
+
+	var start, elapsed int64 // in milliseconds
+	started := !header.Timestamp.IsZero()
+	if started {
+		start = header.Timestamp.UnixMilli()
+	}
+
+	h := struct {
+		Version int `json:"version"`
+		AsciinemaHeader
+		Timestamp int64 `json:"timestamp,omitempty"`
+	}{
+		Version:         2,
+		AsciinemaHeader: header,
+	}
+	if started {
+		h.Timestamp = header.Timestamp.Unix()
+	}
+	enc.Encode(h)
+
+	return func(l ScrollOutLine) {
+		if millis, ok := bkMillis(l.Metadata[bkNamespace]); ok {
+			if !started {
+				start, started = millis, true
+			}
+			elapsed = max(millis-start, 0)
+		}
+		enc.Encode([]any{float64(elapsed) / 1000, "o", l.Text + "\r\n"})
+	}
+}
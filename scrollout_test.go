@@ -0,0 +1,181 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScrollOutDeduper(t *testing.T) {
+	type run struct {
+		Line  string
+		Count int
+	}
+
+	var got []run
+	d := NewScrollOutDeduper(func(line string, count int) {
+		got = append(got, run{line, count})
+	})
+
+	s, err := NewScreen(WithMaxSize(0, 1))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.ScrollOutFunc = d.ScrollOut
+
+	s.Write([]byte("start\n"))
+	for range 3 {
+		s.Write([]byte("\x1b[32mspinning\x1b[0m\n"))
+	}
+	s.Write([]byte("spinning\nspinning\ndone\nend"))
+
+	want := []run{
+		{"start", 1},
+		{`<span class="term-fg32">spinning</span>`, 3},
+		{"spinning", 2},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("before Flush: runs diff (-got +want):\n%s", diff)
+	}
+
+	d.Flush()
+	want = append(want, run{"done", 1})
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("after Flush: runs diff (-got +want):\n%s", diff)
+	}
+
+	// Flushing again shouldn't repeat the last run.
+	d.Flush()
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("after second Flush: runs diff (-got +want):\n%s", diff)
+	}
+
+	if got, want := s.AsHTML(), "end"; got != want {
+		t.Errorf("s.AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestNDJSONScrollWriter(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewScreen(WithMaxSize(0, 1))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.ScrollOutLineFunc = NewNDJSONScrollWriter(&buf)
+
+	s.Write([]byte("\x1b_bk;t=1700000000000\x07\x1b[31mred\x1b[0m <b>\nplain\nlast"))
+
+	type line struct {
+		Line      int    `json:"line"`
+		HTML      string `json:"html"`
+		Text      string `json:"text"`
+		Timestamp string `json:"timestamp"`
+	}
+	var got []line
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var v line
+		if err := json.Unmarshal([]byte(l), &v); err != nil {
+			t.Fatalf("json.Unmarshal(%q) = %v", l, err)
+		}
+		got = append(got, v)
+	}
+
+	want := []line{
+		{
+			Line:      1,
+			HTML:      `<time datetime="2023-11-14T22:13:20Z">2023-11-14T22:13:20Z</time><span class="term-fg31">red</span> &lt;b&gt;`,
+			Text:      "red <b>",
+			Timestamp: "2023-11-14T22:13:20Z",
+		},
+		{Line: 2, HTML: "plain", Text: "plain"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("NDJSON lines diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPlainTextScrollWriter(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewScreen(WithMaxSize(0, 2))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.ScrollOutLineFunc = NewPlainTextScrollWriter(&buf)
+
+	s.Write([]byte("\x1b_bk;t=1700000000000\x07\x1b[31mone\x1b[0m <b>\ntwo\nthree\n"))
+	if got, want := buf.String(), "one <b>\n"; got != want {
+		t.Errorf("after first write, output = %q, want %q", got, want)
+	}
+
+	s.Write([]byte("four\nfive"))
+	if got, want := buf.String(), "one <b>\ntwo\nthree\n"; got != want {
+		t.Errorf("after second write, output = %q, want %q", got, want)
+	}
+
+	s.Flush()
+	if got, want := buf.String(), "one <b>\ntwo\nthree\nfour\nfive\n"; got != want {
+		t.Errorf("after Flush, output = %q, want %q", got, want)
+	}
+	if got := s.AsPlainText(); got != "" {
+		t.Errorf("after Flush, s.AsPlainText() = %q, want empty", got)
+	}
+	if got, want := s.LinesScrolledOut, 5; got != want {
+		t.Errorf("after Flush, s.LinesScrolledOut = %d, want %d", got, want)
+	}
+
+	// Writing carries on from the top of the empty screen.
+	s.Write([]byte("six"))
+	s.Flush()
+	if got, want := buf.String(), "one <b>\ntwo\nthree\nfour\nfive\nsix\n"; got != want {
+		t.Errorf("after writing more, output = %q, want %q", got, want)
+	}
+}
+
+func TestAsciinemaWriter(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewScreen(WithMaxSize(0, 1))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.ScrollOutLineFunc = NewAsciinemaWriter(&buf, AsciinemaHeader{
+		Width:     80,
+		Height:    24,
+		Timestamp: time.UnixMilli(1700000000000),
+		Title:     "build",
+	})
+
+	s.Write([]byte("\x1b_bk;t=1700000000500\x07\x1b[32mfirst\x1b[0m\nno timestamp\n\x1b_bk;t=1700000002250\x07third\nlast"))
+
+	want := `{"version":2,"width":80,"height":24,"title":"build","timestamp":1700000000}
+[0.5,"o","first\r\n"]
+[0.5,"o","no timestamp\r\n"]
+[2.25,"o","third\r\n"]
+`
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("recording diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAsciinemaWriterStartsAtFirstTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewScreen(WithMaxSize(0, 1))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.ScrollOutLineFunc = NewAsciinemaWriter(&buf, AsciinemaHeader{Width: 80, Height: 24})
+
+	s.Write([]byte("before\n\x1b_bk;t=1700000000000\x07one\n\x1b_bk;t=1700000001000\x07two\nlast"))
+
+	want := `{"version":2,"width":80,"height":24}
+[0,"o","before\r\n"]
+[0,"o","one\r\n"]
+[1,"o","two\r\n"]
+`
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("recording diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,142 @@
+package terminal
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// FindOptions configures Screen.Find.
+type FindOptions struct {
+	// Match case-insensitively.
+	CaseInsensitive bool
+
+	// Interpret the search string as a regular expression (in the syntax
+	// accepted by the regexp package) rather than a literal substring.
+	Regexp bool
+}
+
+// Match is a search result returned by Screen.Find.
+type Match struct {
+	// Index of the line within the screen buffer.
+	Line int
+
+	// The node (cell) range of the match within the line: Start is the index
+	// of the first node and End is one past the last node.
+	Start, End int
+
+	// The matched text.
+	Text string
+}
+
+// Find searches the plain text of each line in the screen buffer, returning
+// the matches in document order. Elements are not part of the plain text, so
+// they are skipped over when matching, but match positions are reported in
+// terms of nodes so that they can be used to address the buffer directly.
+// Matches do not span lines.
+func (s *Screen) Find(substr string, opts FindOptions) ([]Match, error) {
+	if substr == "" {
+		return nil, nil
+	}
+
+	expr := substr
+	if !opts.Regexp {
+		expr = regexp.QuoteMeta(substr)
+	}
+	if opts.CaseInsensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	for i := range s.screen {
+		text, offsets := s.screen[i].plainWithOffsets()
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				// Ignore empty matches.
+				continue
+			}
+			matches = append(matches, Match{
+				Line:  i,
+				Start: offsets[loc[0]],
+				End:   offsets[loc[1]-1] + 1,
+				Text:  text[loc[0]:loc[1]],
+			})
+		}
+	}
+	return matches, nil
+}
+
+// plainWithOffsets returns the plain text of the line (like asPlain, but
+// without trimming), and for each byte of the text, the index of the node it
+// came from.
+func (l *screenLine) plainWithOffsets() (string, []int) {
+	var buf strings.Builder
+	offsets := make([]int, 0, len(l.nodes))
+	for x, n := range l.nodes {
+		if n.style.element() || n.style.cont() {
+			continue
+		}
+		size, _ := buf.WriteString(l.text(n))
+		for range size {
+			offsets = append(offsets, x)
+		}
+	}
+	return buf.String(), offsets
+}
+
+// Range is a range of cells within a line of the screen buffer.
+type Range struct {
+	// Index of the line within the screen buffer.
+	Line int
+
+	// Start is the index of the first node in the range, and End is one past
+	// the last node.
+	Start, End int
+}
+
+// Range returns the range of cells covered by the match.
+func (m Match) Range() Range {
+	return Range{Line: m.Line, Start: m.Start, End: m.End}
+}
+
+// HTMLWithHighlights is like AsHTML, but wraps the cells covered by ranges in
+// <mark class="term-highlight"> tags (for example, to show search results).
+// Ranges may be given in any order. Overlapping ranges are merged into a
+// single mark, but adjacent ranges are marked separately. Like
+// WriteHTMLWithRuns, it always renders the lines as they are in the buffer
+// (even with WrapWord), since the ranges refer to the cells of the buffer.
+func (s *Screen) HTMLWithHighlights(ranges []Range) string {
+	byLine := make(map[int][]Range)
+	for _, r := range ranges {
+		if r.Start < r.End {
+			byLine[r.Line] = append(byLine[r.Line], r)
+		}
+	}
+	for i, rs := range byLine {
+		byLine[i] = mergeRanges(rs)
+	}
+
+	var b strings.Builder
+	s.writeHTMLLines(&b, s.firstRenderedLine(true), htmlLinesOptions{marks: byLine})
+	return b.String()
+}
+
+// mergeRanges sorts ranges within a line, merging those that overlap.
+func mergeRanges(ranges []Range) []Range {
+	slices.SortFunc(ranges, func(a, b Range) int { return cmp.Compare(a.Start, b.Start) })
+
+	var merged []Range
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.Start < merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,180 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFind(t *testing.T) {
+	input := "hello world\n" +
+		"\x1b]1339;url=http://example.com;content=link\x07€€ Hello €\n" +
+		"nothing here\n" +
+		"HELLO hello"
+
+	tests := []struct {
+		name   string
+		substr string
+		opts   FindOptions
+		want   []Match
+	}{
+		{
+			name:   "substring",
+			substr: "hello",
+			want: []Match{
+				{Line: 0, Start: 0, End: 5, Text: "hello"},
+				{Line: 3, Start: 6, End: 11, Text: "hello"},
+			},
+		},
+		{
+			name:   "case insensitive, across elements and multibyte runes",
+			substr: "hello",
+			opts:   FindOptions{CaseInsensitive: true},
+			want: []Match{
+				{Line: 0, Start: 0, End: 5, Text: "hello"},
+				{Line: 1, Start: 4, End: 9, Text: "Hello"},
+				{Line: 3, Start: 0, End: 5, Text: "HELLO"},
+				{Line: 3, Start: 6, End: 11, Text: "hello"},
+			},
+		},
+		{
+			name:   "regexp",
+			substr: "€+",
+			opts:   FindOptions{Regexp: true},
+			want: []Match{
+				{Line: 1, Start: 1, End: 3, Text: "€€"},
+				{Line: 1, Start: 10, End: 11, Text: "€"},
+			},
+		},
+		{
+			name:   "regexp special characters are literal without Regexp",
+			substr: "o.",
+			want:   nil,
+		},
+		{
+			name:   "no empty matches",
+			substr: "x*",
+			opts:   FindOptions{Regexp: true},
+			want:   nil,
+		},
+	}
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte(input))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := s.Find(test.substr, test.opts)
+			if err != nil {
+				t.Fatalf("Find(%q, %+v) error = %v", test.substr, test.opts, err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("Find(%q, %+v) diff (-got +want):\n%s", test.substr, test.opts, diff)
+			}
+		})
+	}
+}
+
+func TestFindInvalidRegexp(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	if _, err := s.Find("(", FindOptions{Regexp: true}); err == nil {
+		t.Error("Find(`(`, Regexp) error = nil, want error")
+	}
+}
+
+func TestHTMLWithHighlights(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		ranges []Range
+		want   string
+	}{
+		{
+			name:   "single range",
+			input:  "hello world\nhello again",
+			ranges: []Range{{Line: 1, Start: 6, End: 11}},
+			want:   "hello world\nhello <mark class=\"term-highlight\">again</mark>",
+		},
+		{
+			name:   "overlapping ranges are merged",
+			input:  "hello world",
+			ranges: []Range{{Line: 0, Start: 4, End: 8}, {Line: 0, Start: 2, End: 5}},
+			want:   `he<mark class="term-highlight">llo wo</mark>rld`,
+		},
+		{
+			name:   "adjacent ranges are marked separately",
+			input:  "hello world",
+			ranges: []Range{{Line: 0, Start: 0, End: 5}, {Line: 0, Start: 5, End: 11}},
+			want:   `<mark class="term-highlight">hello</mark><mark class="term-highlight"> world</mark>`,
+		},
+		{
+			name:   "splitting a styled run",
+			input:  "\x1b[31mhello world",
+			ranges: []Range{{Line: 0, Start: 3, End: 7}},
+			want:   `<span class="term-fg31">hel<mark class="term-highlight">lo w</mark>orld</span>`,
+		},
+		{
+			name:   "highlight spanning style changes",
+			input:  "ab\x1b[32mcd\x1b[0mef",
+			ranges: []Range{{Line: 0, Start: 1, End: 5}},
+			want:   `a<mark class="term-highlight">b<span class="term-fg32">cd</span>e</mark>f`,
+		},
+		{
+			name:   "highlight within a link",
+			input:  "\x1b]8;;http://example.com\x07hello\x1b]8;;\x07 world",
+			ranges: []Range{{Line: 0, Start: 2, End: 8}},
+			want:   `<a href="http://example.com">he<mark class="term-highlight">llo</mark></a><mark class="term-highlight"> wo</mark>rld`,
+		},
+		{
+			name:   "empty and out of range ranges are ignored",
+			input:  "hello",
+			ranges: []Range{{Line: 0, Start: 2, End: 2}, {Line: 5, Start: 0, End: 1}},
+			want:   "hello",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.HTMLWithHighlights(test.ranges), test.want); diff != "" {
+				t.Errorf("HTMLWithHighlights diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHTMLWithHighlightsRenderOptions(t *testing.T) {
+	// Without ranges, the output is the same as AsHTML, whatever the options.
+	input := "\n\nline one\nline two\nline two\nline two\nline three"
+	tests := []struct {
+		name string
+		opt  ScreenOption
+	}{
+		{name: "trim leading blank lines", opt: WithTrimLeadingBlankLines(true)},
+		{name: "max HTML bytes", opt: WithMaxHTMLBytes(20)},
+		{name: "repeat folding", opt: WithRepeatFolding(2)},
+		{name: "trailing newline", opt: WithTrailingNewline(true)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opt)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.HTMLWithHighlights(nil), s.AsHTML()); diff != "" {
+				t.Errorf("HTMLWithHighlights(nil) diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// shellNamespace is the line metadata namespace for shell integration marks
+// (OSC 133).
+const shellNamespace = "shell"
+
+// Regions of shell integration output, recorded under "region" in the shell
+// metadata namespace.
+const (
+	shellRegionPrompt = "prompt" // the prompt and the command typed after it
+	shellRegionOutput = "output" // the output of the command
+)
+
+// shellMark records an OSC 133 shell integration mark, as sent by shells set
+// up for FinalTerm-style integration (iTerm2, VS Code, WezTerm, ...), in the
+// metadata of the current line, under the "shell" namespace:
+//   - A (prompt start): prompt=1
+//   - B (command start, after the prompt): command=1
+//   - C (output start): output=1
+//   - D (command finished): end=1, and exit=code if there is an exit code
+//
+// Lines written from A up to C are also given region=prompt, and lines from C
+// up to D region=output (see WithShellRegionClasses). Other marks and options
+// are ignored.
+func (s *Screen) shellMark(args string) {
+	mark, rest, _ := strings.Cut(args, ";")
+	var data map[string]string
+	switch mark {
+	case "A":
+		s.shellRegion = shellRegionPrompt
+		data = map[string]string{"prompt": "1"}
+	case "B":
+		data = map[string]string{"command": "1"}
+	case "C":
+		s.shellRegion = shellRegionOutput
+		data = map[string]string{"output": "1"}
+	case "D":
+		s.shellRegion = ""
+		data = map[string]string{"end": "1"}
+		if code, _, _ := strings.Cut(rest, ";"); code != "" {
+			data["exit"] = code
+		}
+	default:
+		return
+	}
+	s.setLineMetadata(shellNamespace, data)
+}
+
+// markShellRegion records the current shell integration region on the line,
+// if the line doesn't have one already.
+func (s *Screen) markShellRegion(line *screenLine) {
+	if _, ok := line.metadata[shellNamespace]["region"]; ok {
+		return
+	}
+	line.mergeMetadata(shellNamespace, map[string]string{"region": s.shellRegion})
+}
+
+// WithShellRegionClasses adds a class to lines in the HTML output that are in
+// a shell integration region (see OSC 133): term-prompt for the prompt and
+// command line, and term-output for the output of the command. This lets a
+// viewer style or fold commands and their output. It is off by default.
+func WithShellRegionClasses(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.render.shellRegionClasses = enabled
+		return nil
+	}
+}
+
+// shellRegionClass returns the class for the line's shell integration region,
+// or "" if it isn't in one.
+func (l *screenLine) shellRegionClass() string {
+	switch l.metadata[shellNamespace]["region"] {
+	case shellRegionPrompt:
+		return "term-prompt"
+	case shellRegionOutput:
+		return "term-output"
+	}
+	return ""
+}
+
+// Segment is a range of lines of the screen buffer belonging to one command,
+// from its prompt up to the next prompt (see Segments).
+type Segment struct {
+	// Start is the index within the screen buffer of the first line of the
+	// segment, and End is one past the last.
+	Start, End int
+
+	// Output is the index of the first line of the command's output (OSC 133
+	// C), or End if the output wasn't marked.
+	Output int
+
+	// ExitCode is the exit code of the command, if the shell reported one
+	// when it finished (OSC 133 D), in which case HasExitCode is true.
+	ExitCode    int
+	HasExitCode bool
+}
+
+// Segments splits the lines of the screen buffer (including any lines above
+// the window) into one segment per command, using the OSC 133 shell
+// integration marks: each segment starts at a prompt, and runs up to the next
+// one. Lines before the first prompt make up a segment of their own. It
+// returns nil if the buffer is empty.
+func (s *Screen) Segments() []Segment {
+	var segments []Segment
+	ended := false
+	for i := range s.screen {
+		md := s.screen[i].metadata[shellNamespace]
+		_, output := md["output"]
+		// The end of a command (and the start of its output, if it had none)
+		// is normally marked on the line where the next prompt starts, so
+		// they are handled first.
+		if len(segments) > 0 && !ended {
+			seg := &segments[len(segments)-1]
+			if output && seg.Output < 0 {
+				seg.Output = i
+				output = false
+			}
+			if _, ok := md["end"]; ok {
+				if code, err := strconv.Atoi(md["exit"]); err == nil {
+					seg.ExitCode, seg.HasExitCode = code, true
+				}
+				ended = true
+			}
+		}
+		if _, prompt := md["prompt"]; len(segments) == 0 || (prompt && i > segments[len(segments)-1].Start) {
+			segments = closeSegment(segments, i)
+			segments = append(segments, Segment{Start: i, Output: -1})
+			ended = false
+		}
+		if seg := &segments[len(segments)-1]; output && seg.Output < 0 {
+			seg.Output = i
+		}
+	}
+	return closeSegment(segments, len(s.screen))
+}
+
+// SegmentsFunc is like Segments, but starts a new segment at each line for
+// which isStart returns true, given the line's plain text. This is for output
+// without shell integration marks, where prompts can be recognised from their
+// text. Segments found this way have no output line or exit code.
+func (s *Screen) SegmentsFunc(isStart func(plainText string) bool) []Segment {
+	var segments []Segment
+	for i := range s.screen {
+		if len(segments) == 0 || isStart(s.screen[i].asPlain(&s.render)) {
+			segments = closeSegment(segments, i)
+			segments = append(segments, Segment{Start: i, Output: -1})
+		}
+	}
+	return closeSegment(segments, len(s.screen))
+}
+
+// closeSegment ends the last segment, if any, before line end.
+func closeSegment(segments []Segment, end int) []Segment {
+	if len(segments) == 0 {
+		return segments
+	}
+	seg := &segments[len(segments)-1]
+	seg.End = end
+	if seg.Output < 0 {
+		seg.Output = end
+	}
+	return segments
+}
@@ -0,0 +1,118 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// A prompt, a command and its output, as written by a shell with OSC 133
+// integration.
+const shellCycle = "\x1b]133;A\x07$ \x1b]133;B\x07ls\n" +
+	"\x1b]133;C\x07one\ntwo\n" +
+	"\x1b]133;D;1\x07\x1b]133;A\x07$ "
+
+func TestShellIntegrationMarks(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte(shellCycle))
+
+	var got []map[string]string
+	for row := range len(s.screen) {
+		got = append(got, s.LineMetadata(row)[shellNamespace])
+	}
+	want := []map[string]string{
+		{"prompt": "1", "command": "1", "region": "prompt"},
+		{"output": "1", "region": "output"},
+		{"region": "output"},
+		{"end": "1", "exit": "1", "prompt": "1", "region": "prompt"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("line metadata diff (-want +got):\n%s", diff)
+	}
+
+	if got, want := s.AsPlainText(), "$ ls\none\ntwo\n$"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
+
+func TestShellIntegrationMarksIgnored(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b]133;P;k=i\x07\x1b]133\x07hello"))
+
+	if got := s.LineMetadata(0); got != nil {
+		t.Errorf("LineMetadata(0) = %v, want nil", got)
+	}
+	if got, want := s.AsHTML(), "hello"; got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWithShellRegionClasses(t *testing.T) {
+	s, err := NewScreen(WithShellRegionClasses(true), WithCurrentLineClass("term-current"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte(shellCycle))
+
+	want := `<span class="term-prompt">$ ls</span>` + "\n" +
+		`<span class="term-output">one</span>` + "\n" +
+		`<span class="term-output">two</span>` + "\n" +
+		`<span class="term-current term-prompt">$</span>`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("Welcome\n" +
+		"\x1b]133;A\x07$ \x1b]133;B\x07make\n\x1b]133;C\x07building\ndone\n" +
+		"\x1b]133;D;0\x07\x1b]133;A\x07$ \x1b]133;B\x07false\n\x1b]133;C\x07" +
+		"\x1b]133;D;1\x07\x1b]133;A\x07$ \x1b]133;B\x07exit\n"))
+
+	want := []Segment{
+		{Start: 0, End: 1, Output: 1},
+		{Start: 1, End: 4, Output: 2, ExitCode: 0, HasExitCode: true},
+		{Start: 4, End: 5, Output: 5, ExitCode: 1, HasExitCode: true},
+		{Start: 5, End: 6, Output: 6},
+	}
+	if diff := cmp.Diff(s.Segments(), want); diff != "" {
+		t.Errorf("Segments() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSegmentsFunc(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("$ make\nbuilding\ndone\n$ make test\nok\n$ exit"))
+
+	got := s.SegmentsFunc(func(text string) bool { return strings.HasPrefix(text, "$ ") })
+	want := []Segment{
+		{Start: 0, End: 3, Output: 3},
+		{Start: 3, End: 5, Output: 5},
+		{Start: 5, End: 6, Output: 6},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("SegmentsFunc() diff (-got +want):\n%s", diff)
+	}
+
+	empty, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	if got := empty.Segments(); got != nil {
+		t.Errorf("Segments() on an empty screen = %v, want nil", got)
+	}
+}
@@ -0,0 +1,76 @@
+package terminal
+
+import "slices"
+
+// recordedInputLimit is the most input retained by WithInputRecording. Older
+// input is discarded once the limit is reached.
+const recordedInputLimit = 1 << 20
+
+// WithInputRecording enables or disables recording the input, for debugging
+// how it is rendered. When enabled, the screen retains the most recent input
+// (up to 1 MiB, see RecordedInput, though up to twice that is held in memory),
+// and records the offset in the input of the character written to each cell
+// (see SourceOffset). Offsets are counted from the start of the first Write,
+// across all Writes.
+func WithInputRecording(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.recordInput = enabled
+		return nil
+	}
+}
+
+// RecordedInput returns a copy of the input retained with WithInputRecording,
+// and the offset of its first byte in the whole input (which is more than 0
+// once older input has been discarded). It returns nil if recording is
+// disabled.
+func (s *Screen) RecordedInput() ([]byte, int) {
+	over := max(len(s.input)-recordedInputLimit, 0)
+	return slices.Clone(s.input[over:]), s.inputStart + over
+}
+
+// SourceOffset returns the offset in the input (see WithInputRecording) of
+// the character or element in the cell at the given row and column of the
+// screen buffer (including any lines above the window). For the cells of wide
+// characters after the first, this is the offset of the wide character. It
+// returns false if recording is disabled, or the cell is empty or out of
+// range.
+func (s *Screen) SourceOffset(row, col int) (int, bool) {
+	if row < 0 || row >= len(s.screen) {
+		return 0, false
+	}
+	line := &s.screen[row]
+	if col < 0 || col >= len(line.nodes) {
+		return 0, false
+	}
+	for col > 0 && line.nodes[col].style.cont() {
+		col--
+	}
+	if line.nodes[col].style.empty() {
+		return 0, false
+	}
+	offset, ok := line.sources[col]
+	return offset, ok
+}
+
+// record appends input to the recorded input. Discarding the oldest input
+// means copying the rest, so it is only done once the input is over the limit
+// by as much again, which keeps the cost per byte written constant. Until
+// then, RecordedInput leaves out the excess.
+func (s *Screen) record(input []byte) {
+	s.input = append(s.input, input...)
+	if over := len(s.input) - recordedInputLimit; over >= recordedInputLimit {
+		s.input = append(s.input[:0], s.input[over:]...)
+		s.inputStart += over
+	}
+}
+
+// recordSource records that the n cells from x onwards in the line were
+// written by the input at offset.
+func (l *screenLine) recordSource(x, n, offset int) {
+	if l.sources == nil {
+		l.sources = make(map[int]int)
+	}
+	for i := range n {
+		l.sources[x+i] = offset
+	}
+}
@@ -0,0 +1,114 @@
+package terminal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSourceOffset(t *testing.T) {
+	s, err := NewScreen(WithInputRecording(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	// Offsets are counted across writes, including escape sequences split
+	// between them.
+	s.Write([]byte("ab\x1b[31"))  // 0-5
+	s.Write([]byte("mcd\n漢x\re")) // 6-15
+	s.Write([]byte("\x1b]1339;url=http://example.com\x07"))
+
+	tests := []struct {
+		row, col   int
+		wantOffset int
+		wantFound  bool
+	}{
+		{row: 0, col: 0, wantOffset: 0, wantFound: true},
+		{row: 0, col: 1, wantOffset: 1, wantFound: true},
+		{row: 0, col: 2, wantOffset: 7, wantFound: true}, // after the SGR
+		{row: 0, col: 3, wantOffset: 8, wantFound: true},
+		{row: 0, col: 4},
+		{row: 1, col: 0, wantOffset: 15, wantFound: true}, // e overwrote 漢
+		{row: 1, col: 1, wantOffset: 16, wantFound: true}, // the element, over the rest of 漢
+		{row: 1, col: 2, wantOffset: 13, wantFound: true},
+		{row: 1, col: 3},
+		{row: 2, col: 0},
+		{row: -1, col: 0},
+	}
+	for _, test := range tests {
+		got, found := s.SourceOffset(test.row, test.col)
+		if got != test.wantOffset || found != test.wantFound {
+			t.Errorf("SourceOffset(%d, %d) = (%d, %t), want (%d, %t)", test.row, test.col, got, found, test.wantOffset, test.wantFound)
+		}
+	}
+
+	input, start := s.RecordedInput()
+	if start != 0 {
+		t.Errorf("RecordedInput() start = %d, want 0", start)
+	}
+	if offset, _ := s.SourceOffset(1, 2); input[offset-start] != 'x' {
+		t.Errorf("recorded input at SourceOffset(1, 2) = %q, want 'x'", input[offset-start])
+	}
+}
+
+func TestSourceOffsetWideCharacter(t *testing.T) {
+	s, err := NewScreen(WithInputRecording(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("a漢b"))
+	for col, want := range []int{0, 1, 1, 4} {
+		if got, found := s.SourceOffset(0, col); got != want || !found {
+			t.Errorf("SourceOffset(0, %d) = (%d, %t), want (%d, true)", col, got, found, want)
+		}
+	}
+}
+
+func TestRecordedInputIsBounded(t *testing.T) {
+	s, err := NewScreen(WithInputRecording(true), WithMaxSize(0, 10))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	chunk := bytes.Repeat([]byte("0123456789abcde\n"), 4096) // 64 KiB
+	for range 20 {
+		s.Write(chunk)
+	}
+	s.Write([]byte("end"))
+
+	input, start := s.RecordedInput()
+	if len(input) != recordedInputLimit {
+		t.Errorf("len(RecordedInput()) = %d, want %d", len(input), recordedInputLimit)
+	}
+	if want := 20*len(chunk) + 3 - recordedInputLimit; start != want {
+		t.Errorf("RecordedInput() start = %d, want %d", start, want)
+	}
+	offset, found := s.SourceOffset(len(s.screen)-1, 0)
+	if !found || string(input[offset-start:]) != "end" {
+		t.Errorf("SourceOffset of the last line = (%d, %t), want the offset of %q", offset, found, "end")
+	}
+}
+
+func TestRecordedInputIsACopy(t *testing.T) {
+	s, err := NewScreen(WithInputRecording(true))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("abc"))
+	input, _ := s.RecordedInput()
+	s.Write(bytes.Repeat([]byte("x"), recordedInputLimit))
+	if string(input) != "abc" {
+		t.Errorf("RecordedInput() = %q after a later Write, want %q", input, "abc")
+	}
+}
+
+func TestSourceOffsetDisabled(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("abc"))
+	if _, found := s.SourceOffset(0, 0); found {
+		t.Error("SourceOffset(0, 0) found = true without recording, want false")
+	}
+	if input, _ := s.RecordedInput(); input != nil {
+		t.Errorf("RecordedInput() = %q without recording, want nil", input)
+	}
+}
@@ -0,0 +1,166 @@
+package terminal
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// StripANSI returns the text of input with all escape sequences and control
+// characters (other than newlines and tabs) removed. Unlike AsPlainText, it
+// doesn't interpret the input as a terminal would: cursor movement, carriage
+// returns and backspaces are discarded rather than applied, so nothing that was
+// written is overwritten. Otherwise, sequences are handled as by a Screen with
+// the default options: unsupported two-character escapes (such as ESC q) and
+// SS3 keys (such as ESC O A) are discarded, a broken control sequence leaves
+// everything after the ESC as text, and invalid UTF-8 is replaced with U+FFFD.
+func StripANSI(input []byte) []byte {
+	out := make([]byte, 0, len(input))
+	mode := parserModeNormal
+	escapeStartedAt := 0
+	var intermediates []byte
+
+	for i := 0; i < len(input); {
+		char, charLen := utf8.DecodeRune(input[i:])
+		i += charLen
+
+		switch mode {
+		case parserModeEscape:
+			mode = parserModeNormal
+			switch char {
+			case '[':
+				mode = parserModeControl
+				intermediates = intermediates[:0]
+			case ']':
+				mode = parserModeOSC
+			case '_':
+				mode = parserModeAPC
+			case 'P':
+				mode = parserModeDCS
+			case ')', '(':
+				mode = parserModeCharset
+			case '#':
+				mode = parserModeHash
+			case 'O':
+				mode = parserModeSS3
+			default:
+				if char < 0x30 || char > 0x7e {
+					// Not an escape sequence, so the ESC is dropped and the
+					// character is handled as normal input.
+					i = escapeStartedAt
+				}
+				// Otherwise it's a complete two-character sequence, which is
+				// discarded whether or not it's supported.
+			}
+
+		case parserModeControl:
+			if char >= 0x20 && char <= 0x2f {
+				// An intermediate byte.
+				intermediates = append(intermediates, byte(char))
+				continue
+			}
+			mode = parserModeNormal
+			if !isCSIEnd(string(intermediates), char) {
+				if len(intermediates) == 0 && isCSIParam(char) {
+					mode = parserModeControl
+					continue
+				}
+				// Not a supported control sequence, so (like Screen) the
+				// ESC is dropped and the rest is handled as normal input.
+				i = escapeStartedAt
+			}
+
+		case parserModeSS3:
+			mode = parserModeNormal
+			if !isSS3Key(char) {
+				// ESC O is text, and the character is handled as normal
+				// input.
+				out = append(out, 'O')
+				i -= charLen
+			}
+
+		case parserModeOSC, parserModeAPC:
+			switch char {
+			case '\x07': // BEL terminates the sequence
+				mode = parserModeNormal
+			case '\x1b':
+				// Might be the start of ST (ESC \)
+				if mode == parserModeOSC {
+					mode = parserModeOSCEsc
+				} else {
+					mode = parserModeAPCEsc
+				}
+			}
+
+		case parserModeOSCEsc, parserModeAPCEsc:
+			switch {
+			case char == '\\':
+				mode = parserModeNormal
+			case mode == parserModeOSCEsc:
+				mode = parserModeOSC
+			default:
+				mode = parserModeAPC
+			}
+
+		case parserModeDCS:
+			if char == '\x1b' {
+				mode = parserModeDCSEsc
+			}
+
+		case parserModeDCSEsc:
+			mode = parserModeDCS
+			if char == '\\' {
+				mode = parserModeNormal
+			}
+
+		case parserModeCharset, parserModeHash:
+			// Discard the character set name or hash instruction.
+			mode = parserModeNormal
+
+		case parserModeNormal:
+			if char == '\x1b' {
+				mode = parserModeEscape
+				escapeStartedAt = i
+				continue
+			}
+			out = appendPlain(out, char)
+		}
+	}
+
+	return out
+}
+
+// StripANSIString is like StripANSI, but for strings.
+func StripANSIString(input string) string {
+	return string(StripANSI([]byte(input)))
+}
+
+// appendPlain appends char to out, unless it is a control character other
+// than a newline or tab.
+func appendPlain(out []byte, char rune) []byte {
+	if (char < 0x20 && char != '\n' && char != '\t') || char == 0x7f {
+		return out
+	}
+	return utf8.AppendRune(out, char)
+}
+
+// isCSIParam reports whether char can be part of the parameters of a control
+// sequence, as handled by the parser's handleControlSequence.
+func isCSIParam(char rune) bool {
+	return char == ';' || strings.ContainsRune(csiParamChars, char)
+}
+
+// isCSIEnd reports whether char completes a control sequence with the given
+// intermediate bytes, as handled by the parser's handleControlSequence (and,
+// with intermediate bytes, applyIntermediateEscape).
+func isCSIEnd(intermediates string, char rune) bool {
+	if intermediates != "" {
+		_, ok := intermediateEscapes[intermediates+string(char)]
+		return ok
+	}
+	if strings.ContainsRune(csiCaseSensitiveFinals, char) {
+		return true
+	}
+	upper := unicode.ToUpper(char)
+	return strings.ContainsRune(csiFinals, upper) || strings.ContainsRune(csiIgnoredFinals, upper)
+}
@@ -0,0 +1,76 @@
+package terminal
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain text", input: "hello\nworld", want: "hello\nworld"},
+		{name: "SGR", input: "\x1b[1;31mred\x1b[0m text", want: "red text"},
+		{name: "cursor movement", input: "ab\x1b[2Dc\x1b[10;20H!", want: "abc!"},
+		{name: "private mode", input: "\x1b[?25lhidden\x1b[?25h", want: "hidden"},
+		{name: "intermediate bytes", input: "\x1b[2 qcursor", want: "cursor"},
+		{name: "OSC with BEL", input: "\x1b]0;title\x07text", want: "text"},
+		{name: "OSC with ST", input: "\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\", want: "link"},
+		{name: "APC", input: "\x1b_bk;t=123\x07line", want: "line"},
+		{name: "DCS", input: "\x1bPq#0;2;0;0;0\x07#1\x1b\\sixel", want: "sixel"},
+		{name: "charset", input: "\x1b(Btext", want: "text"},
+		{name: "two-character escapes", input: "a\x1b7b\x1b8c\x1bMd", want: "abcd"},
+		{name: "hash", input: "\x1b#8x", want: "x"},
+		{name: "control characters", input: "a\rb\bc\x07d\te\x7f", want: "abcd\te"},
+		{name: "unknown two-character escape", input: "a\x1bqb", want: "ab"},
+		{name: "not an escape", input: "a\x1b b", want: "a b"},
+		{name: "SS3 keys", input: "a\x1bOA\x1bOPb\x1bOops", want: "abOops"},
+		{name: "broken control sequence", input: "\x1b[1\nnext", want: "[1\nnext"},
+		{name: "unsupported control sequence", input: "a\x1b[1~b", want: "a[1~b"},
+		{name: "unterminated sequence", input: "text\x1b]0;ti", want: "text"},
+		{name: "unicode", input: "\x1b[32m漢字\x1b[0m café", want: "漢字 café"},
+		{name: "invalid utf-8", input: "a\xffb", want: "a�b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(StripANSI([]byte(test.input))); got != test.want {
+				t.Errorf("StripANSI(%q) = %q, want %q", test.input, got, test.want)
+			}
+			if got := StripANSIString(test.input); got != test.want {
+				t.Errorf("StripANSIString(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestStripANSIMatchesScreen(t *testing.T) {
+	// Sequences without cursor movement, which should be left out of the
+	// text in the same way by StripANSI and Screen.
+	inputs := []string{
+		"\x1b[1;31mred\x1b[0m text",
+		"\x1b[?25lhidden\x1b[?25h \x1b[>4;1mkeys\x1b[<u",
+		"\x1b[2 q\x1b[1\"qprotected\x1b[#{\x1b[#}",
+		"a\x1b[1 xb\x1b[1;2$pc",
+		"\x1b]0;title\x07\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\",
+		"\x1b_bk;t=123\x07line\x1bPq#0;2;0;0;0\x07#1\x1b\\sixel",
+		"\x1b(B\x1b)0text\x1b#3",
+		"a\x1bqb\x1b=c\x1b>d\x1bSe",
+		"a\x1b b\x1b\x1b[31mc\x1b\td",
+		"a\x1bOA\x1bOB\x1bOPb\x1bOops \x1bO\x1b[31mx",
+		"\x1b[?1000;1006ha\x1b[<64;10;5M\x1b[<0;10;5mb\x1b[64;10;5Mc",
+		"\x1b[?1004hone\x1b[I\x1b[O two",
+		"a\x1b[1~b\x1b[38:5:1mc\x1b[1\nnext",
+		"\x1b[32m漢字\x1b[0m café",
+	}
+
+	for _, input := range inputs {
+		s, err := NewScreen()
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte(input))
+		if got, want := StripANSIString(input), s.AsPlainText(); got != want {
+			t.Errorf("StripANSIString(%q) = %q, want %q (as AsPlainText)", input, got, want)
+		}
+	}
+}
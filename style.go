@@ -1,59 +1,153 @@
 package terminal
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
+
+// style is the style of a node: colours and flags. It is a small fixed-size
+// value, so styles can be compared with ==.
+type style struct {
+	fg, bg color
+	flags  uint32
 
-type style uint32
+	// SGR parameters that aren't otherwise supported, in the order they were
+	// first set, followed by zeroes (see WithUnknownSGR). 0 is reset, so it
+	// is never unknown.
+	unknown [unknownSGRLimit]uint8
+}
 
-// style encoding:
-// 0...  ...7  8... ...15  16...23     24     25   26....31
-// [fg color]  [bg color]  [flags]  element  link  [unused]
-// flags = bold, faint, etc
+// The maximum number of unknown SGR parameters kept on a style. Any more are
+// dropped.
+const unknownSGRLimit = 4
 
+// style flags
 const (
-	sbFGColorX = 1 << (16 + iota)
-	sbBGColorX
-	sbBold
+	sbBold = 1 << iota
 	sbFaint
 	sbItalic
 	sbUnderline
 	sbStrike
 	sbBlink
+	sbReverse
+	sbConceal
+	sbOverline
+	sbFramed
 	sbElement   // meaning: this node is actually an element
 	sbHyperlink // this node is styled with an OSC 8 (iTerm-style) link
+	sbCluster   // this node is a grapheme cluster of several runes
+	sbCont      // this node continues the one before it (wide characters, tabs)
+	sbProtected // this node is protected from selective erase (DECSCA)
+	sbEmpty     // this node is an empty cell (emptyNode)
+	sbTooltip   // this node has a tooltip (OSC 1340)
 )
 
-// Used for comparing styles - ignores the element bit, link bit, and unused bits.
-const styleComparisonMask = 0x00ffffff
+// Flags that don't affect how a node looks, so are ignored when comparing
+// styles: the element, link, cluster, continuation, protection, empty and
+// tooltip bits. These are also unaffected by SGR 0.
+const sbNonVisual = sbElement | sbHyperlink | sbCluster | sbCont | sbProtected | sbEmpty | sbTooltip
+
+// visual returns the style with the non-visual flags cleared. Two nodes look
+// the same if their visual styles are equal.
+func (s style) visual() style {
+	s.flags &^= sbNonVisual
+	return s
+}
+
+// rendered returns the visual style without unknown SGR parameters, which
+// only appear in ANSI output. Two nodes look the same in HTML and plain text
+// if their rendered styles are equal.
+func (s style) rendered() style {
+	s = s.visual()
+	s.unknown = [unknownSGRLimit]uint8{}
+	return s
+}
+
+// reset returns the style with all visual style information cleared.
+func (s style) reset() style {
+	return style{flags: s.flags & sbNonVisual}
+}
 
 // isPlain reports if there is no style information. elements (that have no
 // other style set) are also considered plain.
-func (s style) isPlain() bool { return s&styleComparisonMask == 0 }
-
-func (s style) fgColor() uint8  { return uint8(s & 0xff) }
-func (s style) bgColor() uint8  { return uint8((s & 0xff_00) >> 8) }
-func (s style) fgColorX() bool  { return s&sbFGColorX != 0 }
-func (s style) bgColorX() bool  { return s&sbBGColorX != 0 }
-func (s style) bold() bool      { return s&sbBold != 0 }
-func (s style) faint() bool     { return s&sbFaint != 0 }
-func (s style) italic() bool    { return s&sbItalic != 0 }
-func (s style) underline() bool { return s&sbUnderline != 0 }
-func (s style) strike() bool    { return s&sbStrike != 0 }
-func (s style) blink() bool     { return s&sbBlink != 0 }
-func (s style) element() bool   { return s&sbElement != 0 }
-func (s style) hyperlink() bool { return s&sbHyperlink != 0 }
-
-func (s *style) setFGColor(v uint8)  { *s = (*s &^ 0xff) | style(v) }
-func (s *style) setBGColor(v uint8)  { *s = (*s &^ 0xff_00) | (style(v) << 8) }
-func (s *style) setFGColorX(v bool)  { *s = (*s &^ sbFGColorX) | booln(v, sbFGColorX) }
-func (s *style) setBGColorX(v bool)  { *s = (*s &^ sbBGColorX) | booln(v, sbBGColorX) }
-func (s *style) setBold(v bool)      { *s = (*s &^ sbBold) | booln(v, sbBold) }
-func (s *style) setFaint(v bool)     { *s = (*s &^ sbFaint) | booln(v, sbFaint) }
-func (s *style) setItalic(v bool)    { *s = (*s &^ sbItalic) | booln(v, sbItalic) }
-func (s *style) setUnderline(v bool) { *s = (*s &^ sbUnderline) | booln(v, sbUnderline) }
-func (s *style) setStrike(v bool)    { *s = (*s &^ sbStrike) | booln(v, sbStrike) }
-func (s *style) setBlink(v bool)     { *s = (*s &^ sbBlink) | booln(v, sbBlink) }
-func (s *style) setElement(v bool)   { *s = (*s &^ sbElement) | booln(v, sbElement) }
-func (s *style) setHyperlink(v bool) { *s = (*s &^ sbHyperlink) | booln(v, sbHyperlink) }
+func (s style) isPlain() bool { return s.rendered() == style{} }
+
+func (s style) bold() bool      { return s.flags&sbBold != 0 }
+func (s style) faint() bool     { return s.flags&sbFaint != 0 }
+func (s style) italic() bool    { return s.flags&sbItalic != 0 }
+func (s style) underline() bool { return s.flags&sbUnderline != 0 }
+func (s style) strike() bool    { return s.flags&sbStrike != 0 }
+func (s style) blink() bool     { return s.flags&sbBlink != 0 }
+func (s style) reverse() bool   { return s.flags&sbReverse != 0 }
+func (s style) conceal() bool   { return s.flags&sbConceal != 0 }
+func (s style) overline() bool  { return s.flags&sbOverline != 0 }
+func (s style) framed() bool    { return s.flags&sbFramed != 0 }
+func (s style) element() bool   { return s.flags&sbElement != 0 }
+func (s style) hyperlink() bool { return s.flags&sbHyperlink != 0 }
+func (s style) cluster() bool   { return s.flags&sbCluster != 0 }
+func (s style) cont() bool      { return s.flags&sbCont != 0 }
+func (s style) protected() bool { return s.flags&sbProtected != 0 }
+func (s style) empty() bool     { return s.flags&sbEmpty != 0 }
+func (s style) tooltip() bool   { return s.flags&sbTooltip != 0 }
+
+func (s *style) setFlag(f uint32, v bool) {
+	if v {
+		s.flags |= f
+	} else {
+		s.flags &^= f
+	}
+}
+
+func (s *style) setBold(v bool)      { s.setFlag(sbBold, v) }
+func (s *style) setFaint(v bool)     { s.setFlag(sbFaint, v) }
+func (s *style) setItalic(v bool)    { s.setFlag(sbItalic, v) }
+func (s *style) setUnderline(v bool) { s.setFlag(sbUnderline, v) }
+func (s *style) setStrike(v bool)    { s.setFlag(sbStrike, v) }
+func (s *style) setBlink(v bool)     { s.setFlag(sbBlink, v) }
+func (s *style) setReverse(v bool)   { s.setFlag(sbReverse, v) }
+func (s *style) setConceal(v bool)   { s.setFlag(sbConceal, v) }
+func (s *style) setOverline(v bool)  { s.setFlag(sbOverline, v) }
+func (s *style) setFramed(v bool)    { s.setFlag(sbFramed, v) }
+func (s *style) setElement(v bool)   { s.setFlag(sbElement, v) }
+func (s *style) setHyperlink(v bool) { s.setFlag(sbHyperlink, v) }
+func (s *style) setCluster(v bool)   { s.setFlag(sbCluster, v) }
+func (s *style) setCont(v bool)      { s.setFlag(sbCont, v) }
+func (s *style) setProtected(v bool) { s.setFlag(sbProtected, v) }
+func (s *style) setTooltip(v bool)   { s.setFlag(sbTooltip, v) }
+
+// StyleInfo describes the style of a cell.
+type StyleInfo struct {
+	Foreground, Background Color
+
+	Bold, Faint, Italic, Underline, Strike, Blink bool
+
+	Reverse, Conceal, Overline, Framed bool
+}
+
+// Equal reports whether s and o describe the same style. StyleInfo (like the
+// internal representation it is made from) is a fixed-size value with no
+// pointers, so this is the same as s == o, and doesn't allocate.
+func (s StyleInfo) Equal(o StyleInfo) bool {
+	return s == o
+}
+
+// info converts the style into a StyleInfo.
+func (s style) info() StyleInfo {
+	return StyleInfo{
+		Foreground: s.fg.info(),
+		Background: s.bg.info(),
+		Bold:       s.bold(),
+		Faint:      s.faint(),
+		Italic:     s.italic(),
+		Underline:  s.underline(),
+		Strike:     s.strike(),
+		Blink:      s.blink(),
+		Reverse:    s.reverse(),
+		Conceal:    s.conceal(),
+		Overline:   s.overline(),
+		Framed:     s.framed(),
+	}
+}
 
 const (
 	COLOR_NORMAL        = iota
@@ -61,32 +155,82 @@ const (
 	COLOR_GOT_48_NEED_5 = iota
 	COLOR_GOT_38        = iota
 	COLOR_GOT_48        = iota
+	COLOR_GOT_38_2      = iota
+	COLOR_GOT_48_2      = iota
+	COLOR_GOT_58_NEED_5 = iota
 )
 
-// CSS classes that make up the style
-func (s style) asClasses() []string {
-	var styles []string
+// downsample returns the style with its colours replaced by the nearest
+// available at the colour depth.
+func (s style) downsample(depth ColorDepth) style {
+	s.fg = s.fg.downsample(depth, false)
+	s.bg = s.bg.downsample(depth, true)
+	return s
+}
 
-	if s.fgColor() > 0 && s.fgColor() < 38 && !s.fgColorX() {
-		styles = append(styles, "term-fg"+strconv.Itoa(int(s.fgColor())))
+// unknownParams returns the unknown SGR parameters kept on the style.
+func (s style) unknownParams() []uint8 {
+	for i, p := range s.unknown {
+		if p == 0 {
+			return s.unknown[:i]
+		}
 	}
-	if s.fgColor() > 38 && !s.fgColorX() {
-		styles = append(styles, "term-fgi"+strconv.Itoa(int(s.fgColor())))
+	return s.unknown[:]
+}
 
+// keepUnknown adds an unknown SGR parameter to the style, unless it is
+// already there or there is no room.
+func (s *style) keepUnknown(p uint8) {
+	for i, q := range s.unknown {
+		if q == p {
+			return
+		}
+		if q == 0 {
+			s.unknown[i] = p
+			return
+		}
 	}
-	if s.fgColorX() {
-		styles = append(styles, "term-fgx"+strconv.Itoa(int(s.fgColor())))
+}
 
+// displayColors returns the foreground and background colours the style is
+// displayed with. These are swapped if the style is reversed.
+func (s style) displayColors() (fg, bg color) {
+	if !s.reverse() {
+		return s.fg, s.bg
 	}
+	return s.bg.swapLayer(), s.fg.swapLayer()
+}
 
-	if s.bgColor() > 0 && s.bgColor() < 48 && !s.bgColorX() {
-		styles = append(styles, "term-bg"+strconv.Itoa(int(s.bgColor())))
-	}
-	if s.bgColor() > 48 && !s.bgColorX() {
-		styles = append(styles, "term-bgi"+strconv.Itoa(int(s.bgColor())))
-	}
-	if s.bgColorX() {
-		styles = append(styles, "term-bgx"+strconv.Itoa(int(s.bgColor())))
+// CSS classes that make up the style
+func (s style) asClasses(opts *renderOptions) []string {
+	var styles []string
+
+	// With CSS variables enabled, palette colours are rendered inline instead
+	// (see asInlineCSS).
+	if !opts.cssVariables {
+		fg, bg := s.displayColors()
+		fg = s.contrastForeground(fg, bg, opts)
+		switch fg.mode() {
+		case colorModeBasic:
+			if fg.value() < 38 {
+				styles = append(styles, "term-fg"+strconv.Itoa(int(fg.value())))
+			} else {
+				styles = append(styles, "term-fgi"+strconv.Itoa(int(fg.value())))
+			}
+		case colorMode256:
+			styles = append(styles, "term-fgx"+strconv.Itoa(int(fg.value())))
+		}
+
+		switch bg.mode() {
+		case colorModeBasic:
+			if bg.value() < 48 {
+				styles = append(styles, "term-bg"+strconv.Itoa(int(bg.value())))
+			} else {
+				styles = append(styles, "term-bgi"+strconv.Itoa(int(bg.value())))
+			}
+		case colorMode256:
+			styles = append(styles, "term-bgx"+strconv.Itoa(int(bg.value())))
+		}
 	}
 
 	if s.bold() {
@@ -104,54 +248,147 @@ func (s style) asClasses() []string {
 	if s.blink() {
 		styles = append(styles, "term-fg5")
 	}
+	if s.reverse() {
+		styles = append(styles, "term-fg7")
+	}
+	if s.conceal() {
+		styles = append(styles, "term-fg8")
+	}
 	if s.strike() {
 		styles = append(styles, "term-fg9")
 	}
+	if s.framed() {
+		styles = append(styles, "term-fg51")
+	}
+	if s.overline() {
+		styles = append(styles, "term-fg53")
+	}
 
 	return styles
 }
 
+// Inline CSS declarations that make up the style. These are used for colours
+// that don't have classes in the stylesheet (24-bit colours, including
+// foregrounds adjusted for contrast), for the base palette when CSS variables
+// are enabled, and for font weights (see WithFontWeights).
+func (s style) asInlineCSS(opts *renderOptions) string {
+	var decls []string
+
+	fg, bg := s.displayColors()
+	fg = s.contrastForeground(fg, bg, opts)
+	if v := fg.asCSS(opts); v != "" {
+		decls = append(decls, "color:"+v)
+	}
+	if v := bg.asCSS(opts); v != "" {
+		decls = append(decls, "background-color:"+v)
+	}
+	if w, ok := s.fontWeight(opts); ok {
+		decls = append(decls, "font-weight:"+strconv.Itoa(w))
+	}
+
+	return strings.Join(decls, ";")
+}
+
+// cssNormalFontWeight is the initial value of the CSS font-weight property.
+const cssNormalFontWeight = 400
+
+// fontWeight returns the font weight to declare for the style, if any (see
+// WithFontWeights).
+func (s style) fontWeight(opts *renderOptions) (int, bool) {
+	switch {
+	case !opts.fontWeights:
+		return 0, false
+	case s.bold():
+		return opts.boldWeight, true
+	case s.faint():
+		return opts.dimWeight, true
+	}
+	return opts.normalWeight, opts.normalWeight != cssNormalFontWeight
+}
+
 // Add colours to an existing style, returning a new style.
 func (s style) color(colors []string) style {
 	if len(colors) == 0 || (len(colors) == 1 && (colors[0] == "0" || colors[0] == "")) {
 		// s with all normal styles masked out
-		return s &^ styleComparisonMask
+		return s.reset()
 	}
 
 	colorMode := COLOR_NORMAL
 
+	// Components of a 24-bit colour, eg 38;2;255;128;0
+	var rgb []uint8
+
+	// Parameters still to skip, of an underline colour (58;5;n or
+	// 58;2;r;g;b), which isn't supported. It is dropped as a whole, so that
+	// its parts aren't taken for other parameters (or kept as unknown ones).
+	skip := 0
+
 	for _, ccs := range colors {
 		// If multiple colors are defined, i.e. \e[30;42m\e then loop through each
-		// one, and assign it to s.fgColor or s.bgColor
+		// one, and assign it to s.fgColor or s.bgColor.
+		// Each parameter is applied in turn, so a later parameter overrides an
+		// earlier one (e.g. 3;23 leaves italic off).
 		cc, err := strconv.ParseUint(ccs, 10, 8)
 		if err != nil {
 			continue
 		}
 
-		// State machine for XTerm colors, eg 38;5;150
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		// State machine for XTerm colors, eg 38;5;150 or 38;2;255;128;0
 		switch colorMode {
+		case COLOR_GOT_58_NEED_5:
+			switch cc {
+			case 5:
+				skip = 1
+			case 2:
+				skip = 3
+			}
+			colorMode = COLOR_NORMAL
+			continue
 		case COLOR_GOT_38_NEED_5:
-			if cc == 5 {
+			switch cc {
+			case 5:
 				colorMode = COLOR_GOT_38
-			} else {
+			case 2:
+				colorMode = COLOR_GOT_38_2
+				rgb = rgb[:0]
+			default:
 				colorMode = COLOR_NORMAL
 			}
 			continue
 		case COLOR_GOT_48_NEED_5:
-			if cc == 5 {
+			switch cc {
+			case 5:
 				colorMode = COLOR_GOT_48
-			} else {
+			case 2:
+				colorMode = COLOR_GOT_48_2
+				rgb = rgb[:0]
+			default:
 				colorMode = COLOR_NORMAL
 			}
 			continue
 		case COLOR_GOT_38:
-			s.setFGColor(uint8(cc))
-			s.setFGColorX(true)
+			s.fg = paletteColor(uint8(cc))
 			colorMode = COLOR_NORMAL
 			continue
 		case COLOR_GOT_48:
-			s.setBGColor(uint8(cc))
-			s.setBGColorX(true)
+			s.bg = paletteColor(uint8(cc))
+			colorMode = COLOR_NORMAL
+			continue
+		case COLOR_GOT_38_2, COLOR_GOT_48_2:
+			rgb = append(rgb, uint8(cc))
+			if len(rgb) < 3 {
+				continue
+			}
+			if colorMode == COLOR_GOT_38_2 {
+				s.fg = rgbColor(rgb[0], rgb[1], rgb[2])
+			} else {
+				s.bg = rgbColor(rgb[0], rgb[1], rgb[2])
+			}
 			colorMode = COLOR_NORMAL
 			continue
 		}
@@ -159,7 +396,7 @@ func (s style) color(colors []string) style {
 		switch cc {
 		case 0:
 			// Reset all styles
-			s &^= styleComparisonMask
+			s = s.reset()
 		case 1:
 			s.setBold(true)
 			s.setFaint(false)
@@ -172,9 +409,17 @@ func (s style) color(colors []string) style {
 			s.setUnderline(true)
 		case 5, 6:
 			s.setBlink(true)
+		case 7:
+			s.setReverse(true)
+		case 8:
+			s.setConceal(true)
 		case 9:
 			s.setStrike(true)
 		case 21, 22:
+			// 21 is "doubly underlined" in ECMA-48, and is treated that way
+			// by xterm and others. But some terminals (e.g. the Linux
+			// console) treat it as "bold off", like 22, and this is what
+			// we have always done.
 			s.setBold(false)
 			s.setFaint(false)
 		case 23:
@@ -183,33 +428,38 @@ func (s style) color(colors []string) style {
 			s.setUnderline(false)
 		case 25:
 			s.setBlink(false)
+		case 27:
+			s.setReverse(false)
+		case 28:
+			s.setConceal(false)
 		case 29:
 			s.setStrike(false)
+		case 51, 52:
+			// Framed and encircled. Encircled is displayed as framed.
+			s.setFramed(true)
+		case 53:
+			s.setOverline(true)
+		case 54:
+			s.setFramed(false)
+		case 55:
+			s.setOverline(false)
 		case 38:
 			colorMode = COLOR_GOT_38_NEED_5
 		case 39:
-			s.setFGColor(0)
-			s.setFGColorX(false)
+			s.fg = defaultColor
 		case 48:
 			colorMode = COLOR_GOT_48_NEED_5
 		case 49:
-			s.setBGColor(0)
-			s.setBGColorX(false)
+			s.bg = defaultColor
+		case 58:
+			colorMode = COLOR_GOT_58_NEED_5
 		case 30, 31, 32, 33, 34, 35, 36, 37, 90, 91, 92, 93, 94, 95, 96, 97:
-			s.setFGColor(uint8(cc))
-			s.setFGColorX(false)
+			s.fg = basicColor(uint8(cc))
 		case 40, 41, 42, 43, 44, 45, 46, 47, 100, 101, 102, 103, 104, 105, 106, 107:
-			s.setBGColor(uint8(cc))
-			s.setBGColorX(false)
+			s.bg = basicColor(uint8(cc))
+		default:
+			s.keepUnknown(uint8(cc))
 		}
 	}
 	return s
 }
-
-// false, true => 0, t
-func booln(b bool, t style) style {
-	if b {
-		return t
-	}
-	return 0
-}
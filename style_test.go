@@ -0,0 +1,183 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStyleOffCodes(t *testing.T) {
+	tests := []struct {
+		on, off int
+		attr    func(StyleInfo) bool
+	}{
+		{on: 1, off: 21, attr: func(s StyleInfo) bool { return s.Bold }},
+		{on: 1, off: 22, attr: func(s StyleInfo) bool { return s.Bold }},
+		{on: 2, off: 22, attr: func(s StyleInfo) bool { return s.Faint }},
+		{on: 3, off: 23, attr: func(s StyleInfo) bool { return s.Italic }},
+		{on: 4, off: 24, attr: func(s StyleInfo) bool { return s.Underline }},
+		{on: 5, off: 25, attr: func(s StyleInfo) bool { return s.Blink }},
+		{on: 7, off: 27, attr: func(s StyleInfo) bool { return s.Reverse }},
+		{on: 8, off: 28, attr: func(s StyleInfo) bool { return s.Conceal }},
+		{on: 9, off: 29, attr: func(s StyleInfo) bool { return s.Strike }},
+		{on: 51, off: 54, attr: func(s StyleInfo) bool { return s.Framed }},
+		{on: 52, off: 54, attr: func(s StyleInfo) bool { return s.Framed }},
+		{on: 53, off: 55, attr: func(s StyleInfo) bool { return s.Overline }},
+	}
+
+	// Every attribute (except bold), and some colours.
+	all := []int{2, 3, 4, 5, 7, 8, 9, 51, 53, 31, 42}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.on)+"/"+strconv.Itoa(test.off), func(t *testing.T) {
+			// The other attributes: those not cleared by the off code.
+			// Bold and faint can't both be set, so faint is left out when
+			// testing bold.
+			var others []string
+			for _, code := range all {
+				offCode := map[int]int{2: 22, 3: 23, 4: 24, 5: 25, 7: 27, 8: 28, 9: 29, 51: 54, 53: 55}[code]
+				if code != test.on && offCode != test.off && !(test.on == 1 && code == 2) {
+					others = append(others, strconv.Itoa(code))
+				}
+			}
+			on, off := strconv.Itoa(test.on), strconv.Itoa(test.off)
+
+			before := style{}.color(append(others, on))
+			if !test.attr(before.info()) {
+				t.Fatalf("after SGR %s: attribute not set", on)
+			}
+
+			got := before.color([]string{off}).info()
+			if test.attr(got) {
+				t.Errorf("after SGR %s: attribute still set", off)
+			}
+
+			// Everything else should be unchanged.
+			want := style{}.color(others).info()
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("after SGR %s: style diff (-got +want):\n%s", off, diff)
+			}
+		})
+	}
+}
+
+func TestStyleReverseSwapsColors(t *testing.T) {
+	opts := &renderOptions{}
+	tests := []struct {
+		sgr  []string
+		want []string
+	}{
+		{sgr: []string{"7"}, want: []string{"term-fg7"}},
+		{sgr: []string{"31", "7"}, want: []string{"term-bg41", "term-fg7"}},
+		{sgr: []string{"31", "42", "7"}, want: []string{"term-fg32", "term-bg41", "term-fg7"}},
+		{sgr: []string{"91", "7"}, want: []string{"term-bgi101", "term-fg7"}},
+		{sgr: []string{"38", "5", "200", "7"}, want: []string{"term-bgx200", "term-fg7"}},
+		{sgr: []string{"31", "7", "27"}, want: []string{"term-fg31"}},
+	}
+
+	for _, test := range tests {
+		got := style{}.color(test.sgr).asClasses(opts)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("style{}.color(%q).asClasses() diff (-got +want):\n%s", test.sgr, diff)
+		}
+	}
+}
+
+func TestStyleParamsAppliedInOrder(t *testing.T) {
+	// Parameters within one SGR sequence are applied left to right, so a
+	// later parameter undoes an earlier one.
+	tests := []struct {
+		sgr  string
+		want StyleInfo
+	}{
+		{sgr: "3;23", want: StyleInfo{}},
+		{sgr: "23;3", want: StyleInfo{Italic: true}},
+		{sgr: "1;22", want: StyleInfo{}},
+		{sgr: "22;1", want: StyleInfo{Bold: true}},
+		{sgr: "4;24", want: StyleInfo{}},
+		{sgr: "24;4", want: StyleInfo{Underline: true}},
+		{sgr: "1;3;4;23", want: StyleInfo{Bold: true, Underline: true}},
+		{sgr: "1;2", want: StyleInfo{Faint: true}},
+		{sgr: "3;0;4", want: StyleInfo{Underline: true}},
+		{sgr: "31;39", want: StyleInfo{}},
+		{sgr: "39;31", want: StyleInfo{Foreground: Color{Mode: ColorPalette, Index: 1}}},
+		{sgr: "38;5;100;48;2;1;2;3;49", want: StyleInfo{Foreground: Color{Mode: ColorPalette, Index: 100}}},
+	}
+
+	for _, test := range tests {
+		s := parsedScreen(t, "\x1b["+test.sgr+"mx")
+		got, ok := s.StyleAt(0, 0)
+		if !ok {
+			t.Fatalf("after SGR %s: StyleAt(0, 0) not found", test.sgr)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("after SGR %s: style diff (-got +want):\n%s", test.sgr, diff)
+		}
+	}
+}
+
+func TestStyleInfoEqual(t *testing.T) {
+	parse := func(sgr ...string) StyleInfo { return style{}.color(sgr).info() }
+
+	tests := []struct {
+		name string
+		a, b StyleInfo
+		want bool
+	}{
+		{name: "default", a: parse(), b: parse(), want: true},
+		{name: "same RGB", a: parse("38", "2", "1", "2", "3"), b: parse("38", "2", "1", "2", "3"), want: true},
+		{name: "different RGB", a: parse("38", "2", "1", "2", "3"), b: parse("38", "2", "1", "2", "4"), want: false},
+		{name: "basic and palette", a: parse("31"), b: parse("38", "5", "1"), want: true},
+		{name: "different flags", a: parse("1"), b: parse("2"), want: false},
+		{name: "layer matters", a: parse("31"), b: parse("41"), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.a.Equal(test.b); got != test.want {
+				t.Errorf("%+v.Equal(%+v) = %t, want %t", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasSameStyleDoesNotAllocate(t *testing.T) {
+	a := node{blob: 'a', style: style{}.color([]string{"1", "38", "2", "10", "20", "30"})}
+	b := node{blob: 'b', style: a.style}
+	b.style.setHyperlink(true)
+	allocs := testing.AllocsPerRun(100, func() {
+		if !a.hasSameStyle(b) {
+			t.Fatal("hasSameStyle() = false, want true")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("hasSameStyle() allocs = %v, want 0", allocs)
+	}
+}
+
+// BenchmarkCoalesceRuns renders lines where every other character has a
+// different 24-bit colour, so the renderer compares styles at every node.
+func BenchmarkCoalesceRuns(b *testing.B) {
+	var input strings.Builder
+	for i := range 80 * 24 {
+		if i%2 == 0 {
+			input.WriteString("\x1b[38;2;" + strconv.Itoa(i%256) + ";100;200m")
+		}
+		input.WriteByte('x')
+		if i%80 == 79 {
+			input.WriteByte('\n')
+		}
+	}
+	s, err := NewScreen()
+	if err != nil {
+		b.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte(input.String()))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		s.AsHTML()
+	}
+}
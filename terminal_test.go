@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"os"
@@ -172,6 +173,11 @@ var rendererTestCases = []struct {
 		// but because we can't implement it properly yet:
 		want: "line 1\nline 2\nline 3\n  m",
 	},
+	{
+		name:  "treats ESC [...f like ESC [...H",
+		input: "line 1\nline 2\nline 3\x1b[2;3fm",
+		want:  "line 1\nline 2\nline 3\n  m",
+	},
 	{
 		name:  "allows clearing lines below the current line",
 		input: "foo\nbar\x1b[A\x1b[Jbaz",
@@ -257,6 +263,16 @@ var rendererTestCases = []struct {
 		input: "\x1b[38;5;169;48;5;50mhello\x1b[0m \x1b[38;5;179mgoodbye",
 		want:  "<span class=\"term-fgx169 term-bgx50\">hello</span> <span class=\"term-fgx179\">goodbye</span>",
 	},
+	{
+		name:  "handles 24-bit colors",
+		input: "\x1b[38;2;255;128;0mhello\x1b[48;2;1;2;3m world\x1b[0m",
+		want:  `<span style="color:#ff8000">hello</span><span style="color:#ff8000;background-color:#010203"> world</span>`,
+	},
+	{
+		name:  "handles 24-bit colors mixed with other codes",
+		input: "\x1b[1;38;2;0;0;255;42mhello",
+		want:  `<span class="term-bg42 term-fg1" style="color:#0000ff">hello</span>`,
+	},
 	{
 		name:  "handles non-xterm codes on the same line as xterm colors",
 		input: "\x1b[38;5;228;5;1mblinking and bold\x1b",
@@ -347,6 +363,11 @@ var rendererTestCases = []struct {
 		input: "a link to \x1b]8;;http://google.com\x1b\\google\x1b]8;;\x1b\\.",
 		want:  `a link to <a href="http://google.com">google</a>.`,
 	},
+	{
+		name:  "renders OSC 8 links with params and URLs containing ; : and =",
+		input: "\x1b]8;id=x:y=z;https://example.com:8080/a;b?c=d:e#f=g\x1b\\link\x1b]8;;\x1b\\.",
+		want:  `<a href="https://example.com:8080/a;b?c=d:e#f=g">link</a>.`,
+	},
 	{
 		name:  "uses URL as link content if missing",
 		input: "\x1b]1339;url=http://google.com\a",
@@ -382,6 +403,16 @@ var rendererTestCases = []struct {
 		input: "\x1b]8;;javascript:alert(1)\x07XSS!\x1b]8;;\x1b\\",
 		want:  `<a href="#">XSS!</a>`,
 	},
+	{
+		name:  "drops OSC 8 links with control characters in the URL",
+		input: "\x1b]8;;http://exa\x01mple.com/\x07link\x1b]8;;\x07 \x1b]8;;http://example.com/\u0085\x07link\x1b]8;;\x07",
+		want:  "link link",
+	},
+	{
+		name:  "drops OSC 8 links with unparseable URLs",
+		input: "a \x1b]8;;http://[::1\x07link\x1b]8;;\x07",
+		want:  "a link",
+	},
 	{
 		name:  "allows artifact: scheme URLs",
 		input: "\x1b]1339;url=artifact://hello.txt\x07\n",
@@ -499,6 +530,84 @@ func TestStreamingRendererAgainstFixtures(t *testing.T) {
 	}
 }
 
+// redrawIdioms are sequences captured from common programs redrawing their
+// output, with the text that is left once they finish.
+var redrawIdioms = []struct {
+	name  string
+	input string
+	want  string
+}{
+	{
+		name:  "bash correcting a typo",
+		input: "user@host:~$ gti\x08\x08\x08\x1b[Kgit status\r\nOn branch main\r\n",
+		want:  "user@host:~$ git status\nOn branch main",
+	},
+	{
+		name: "bash reverse search",
+		input: "user@host:~$ \r\x1b[K(reverse-i-search)`': \x08\x08\x08\x1b[1@m\x08\x08\x08\x1b[C\x1b[C\x1b[C" +
+			"\r\x1b[Kuser@host:~$ make\r\n",
+		want: "user@host:~$ make",
+	},
+	{
+		name: "git remote progress",
+		input: "remote: Counting objects:  50% (1/2)\x1b[K\rremote: Counting objects: 100% (2/2)\x1b[K\r" +
+			"remote: Counting objects: 100% (2/2), done.\x1b[K\n" +
+			"Receiving objects:  50% (1/2)\rReceiving objects: 100% (2/2), done.\n",
+		want: "remote: Counting objects: 100% (2/2), done.\nReceiving objects: 100% (2/2), done.",
+	},
+	{
+		name: "npm spinner",
+		input: "\x1b[?25l\x1b[1G⠋ idealTree\x1b[K\x1b[1G⠙ reify: timing\x1b[K\x1b[1G\x1b[0K\x1b[?25h\n" +
+			"added 1 package in 1s\n",
+		want: "\nadded 1 package in 1s",
+	},
+	{
+		name: "docker pull progress bars",
+		input: "abc: Pulling fs layer \ndef: Pulling fs layer \n" +
+			"\x1b[2A\x1b[2K\rabc: Downloading [==>   ] 1MB/3MB\r\x1b[2B" +
+			"\x1b[1A\x1b[2K\rdef: Download complete \r\x1b[1B" +
+			"\x1b[2A\x1b[2K\rabc: Pull complete \r\x1b[2B",
+		want: "abc: Pull complete\ndef: Download complete",
+	},
+	{
+		name: "cargo build status line",
+		input: "\x1b[1m\x1b[32m   Compiling\x1b[0m foo v0.1.0\n" +
+			"\x1b[1m\x1b[36m    Building\x1b[0m [=>   ] 1/3: foo\r\x1b[K" +
+			"\x1b[1m\x1b[32m   Compiling\x1b[0m bar v0.1.0\n" +
+			"\x1b[1m\x1b[36m    Building\x1b[0m [===> ] 2/3: bar\r\x1b[K" +
+			"\x1b[1m\x1b[32m    Finished\x1b[0m dev [unoptimized] target(s) in 1.0s\n",
+		want: "   Compiling foo v0.1.0\n   Compiling bar v0.1.0\n    Finished dev [unoptimized] target(s) in 1.0s",
+	},
+	{
+		// apt reserves the bottom line of the window for its progress bar
+		// with a scroll region, and draws it with HVP.
+		name: "apt progress bar",
+		input: "\n\x1b7\x1b[0;4r\x1b8\x1b[1A" +
+			"Setting up foo\r\n" +
+			"\x1b7\x1b[5;0f\x1b[42m\x1b[30mProgress: [ 20%]\x1b[49m\x1b[39m [####....] \x1b8" +
+			"Setting up bar\r\nSetting up baz\r\nSetting up qux\r\nSetting up quux\r\n" +
+			"\x1b7\x1b[5;0f\x1b[42m\x1b[30mProgress: [ 60%]\x1b[49m\x1b[39m [####....] \x1b8" +
+			"\n\x1b7\x1b[0;5r\x1b8\x1b[1A\x1b[J",
+		// The erased progress bar leaves blank lines at the bottom.
+		want: "Setting up foo\nSetting up bar\nSetting up baz\nSetting up qux\nSetting up quux\n\n\n",
+	},
+}
+
+func TestRedrawIdioms(t *testing.T) {
+	for _, test := range redrawIdioms {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(80, 5))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsPlainText(), test.want); diff != "" {
+				t.Errorf("AsPlainText() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestScreenWriteToXY(t *testing.T) {
 	s, err := NewScreen()
 	if err != nil {
@@ -546,6 +655,168 @@ func benchmarkRender(filename string, b *testing.B) {
 	}
 }
 
+func TestPlainRunMatchesPerRuneWrites(t *testing.T) {
+	inputs := []string{
+		"hello world",
+		"a line long enough to wrap around the narrow screen several times over\nand more",
+		"0123456789\rabc\x1b[5Cxyz",
+		"漢字漢字漢字\r\x1b[1Cab",
+		"ab字cd\x1b[3Dxyz",
+		"\x1b[31mred\x1b[1m bold\x1b[0m plain",
+		"\x1b]8;;http://example.com\x07linked text\x1b]8;;\x07 unlinked",
+		"👩\u200dx and 🏳\u200d🌈 flag",
+		"\x1b[3;5Hpositioned\x1b[1;1Hover",
+		"abc\x1b[20Cpast the end",
+		"long progress line\rshort\n",
+		"\ttab\tstops\tand text",
+	}
+
+	for _, input := range inputs {
+		for _, opts := range [][]ScreenOption{
+			{WithSize(16, 4)},
+			{WithSize(16, 4), WithCRClearsToEnd(true)},
+			{WithMaxSize(10, 3)},
+		} {
+			whole, err := NewScreen(opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			perRune, err := NewScreen(opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+
+			var scrolledWhole, scrolledPerRune []string
+			whole.ScrollOutFunc = func(l string) { scrolledWhole = append(scrolledWhole, l) }
+			perRune.ScrollOutFunc = func(l string) { scrolledPerRune = append(scrolledPerRune, l) }
+
+			whole.Write([]byte(input))
+			// Writing one rune at a time means plain runs are never longer
+			// than one character.
+			for _, r := range input {
+				perRune.Write([]byte(string(r)))
+			}
+
+			if got, want := whole.AsHTML(), perRune.AsHTML(); got != want {
+				t.Errorf("input %q: AsHTML() = %q, per-rune AsHTML() = %q", input, got, want)
+			}
+			if diff := cmp.Diff(scrolledWhole, scrolledPerRune); diff != "" {
+				t.Errorf("input %q: scrolled out lines diff (-whole +per-rune):\n%s", input, diff)
+			}
+			if whole.x != perRune.x || whole.y != perRune.y {
+				t.Errorf("input %q: cursor = (%d, %d), per-rune cursor = (%d, %d)", input, whole.x, whole.y, perRune.x, perRune.y)
+			}
+		}
+	}
+}
+
+// repaintFrame is a frame of a progress display that homes the cursor,
+// clears the screen and redraws it.
+func repaintFrame(i int) []byte {
+	var f strings.Builder
+	f.WriteString("\x1b[H\x1b[J")
+	for l := range 10 {
+		fmt.Fprintf(&f, "\x1b[32m%3d%%\x1b[0m 👩‍💻 \x1b]8;;http://example.com/%d\x1b\\job %d\x1b]8;;\x1b\\ [%-40s]\n", i%100, l, l, strings.Repeat("#", i%40))
+	}
+	return []byte(f.String())
+}
+
+func TestRepaintLoopAllocs(t *testing.T) {
+	s, err := NewScreen(WithMaxSize(0, 100))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+
+	frame := repaintFrame(7)
+	measure := func() float64 {
+		return testing.AllocsPerRun(100, func() { s.Write(frame) })
+	}
+
+	// Fill the buffer, so lines are scrolling out and being recycled.
+	for i := range 20 {
+		s.Write(repaintFrame(i))
+	}
+	early := measure()
+
+	for i := range 10000 {
+		s.Write(repaintFrame(i))
+	}
+	late := measure()
+
+	// The cost of a frame doesn't grow as more are written.
+	if late > early {
+		t.Errorf("allocs per frame after 10000 frames = %v, want at most %v (as after 20 frames)", late, early)
+	}
+	// Recycled lines keep their nodes, link maps and cluster slices, so a
+	// frame costs less than on a new screen, where they are all allocated.
+	// What is left (about 10 per line) is mainly parsing the SGR parameters
+	// and links, and building the cluster strings.
+	fresh := testing.AllocsPerRun(100, func() {
+		s, err := NewScreen(WithMaxSize(0, 100))
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write(frame)
+	})
+	if late >= fresh {
+		t.Errorf("allocs per frame = %v, want fewer than on a new screen (%v)", late, fresh)
+	}
+	if got, want := len(s.screen), 100; got > want {
+		t.Errorf("len(s.screen) = %d, want at most %d", got, want)
+	}
+}
+
+func TestClearedLinesAreReused(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b_bk;t=123\x07top\n\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\ 👩‍💻\n\x1b[2A\r\x1b[J\nplain"))
+
+	if got, want := s.AsPlainText(), "\nplain"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+	if got, want := s.AsHTML(), `<time datetime="1970-01-01T00:00:00.123Z">1970-01-01T00:00:00.123Z</time>`+"\nplain"; got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+	line := &s.screen[1]
+	if len(line.graphemes) != 0 || len(line.hyperlinks) != 0 {
+		t.Errorf("cleared line has graphemes %q and hyperlinks %v, want none", line.graphemes, line.hyperlinks)
+	}
+}
+
+// plainLog is a large plain ASCII log, for benchmarking the plain text path.
+var plainLog = bytes.Repeat([]byte("2024-01-02 03:04:05 INFO compiling package github.com/example/module/pkg\n"), 20000)
+
+func BenchmarkWritePlainASCII(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s, err := NewScreen()
+		if err != nil {
+			b.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write(plainLog)
+	}
+}
+
+// BenchmarkWritePlainASCIIPerRune writes the same input as
+// BenchmarkWritePlainASCII one rune at a time, as the parser would without
+// the plain text fast path, for comparison.
+func BenchmarkWritePlainASCIIPerRune(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s, err := NewScreen()
+		if err != nil {
+			b.Fatalf("NewScreen() = %v", err)
+		}
+		for _, c := range plainLog {
+			if c == '\n' {
+				s.newLine()
+				continue
+			}
+			s.append(rune(c))
+		}
+	}
+}
+
 func BenchmarkStreamingBuildahBuild(b *testing.B) { benchmarkStreaming("buildah-build.sh", b) }
 func BenchmarkStreamingControl(b *testing.B)      { benchmarkStreaming("control.sh", b) }
 func BenchmarkStreamingCurl(b *testing.B)         { benchmarkStreaming("curl.sh", b) }
@@ -577,3 +848,257 @@ func benchmarkStreaming(filename string, b *testing.B) {
 		_ = s.AsHTML()
 	}
 }
+
+func TestRenderWithElementsAndHyperlinksDisabled(t *testing.T) {
+	input := "hi\x1b]1337;File=name=MS5naWY=;inline=1:AA==\ahello " +
+		"\x1b]1338;url=http://foo.com/foobar.gif;alt=foo bar\a" +
+		"\x1b]1339;url=http://google.com;content=google\a " +
+		"\x1b]8;;http://google.com\x1b\\google\x1b]8;;\x1b\\."
+
+	tests := []struct {
+		name string
+		opts []ScreenOption
+		want string
+	}{
+		{
+			name: "elements disabled",
+			opts: []ScreenOption{WithElements(false)},
+			want: `hihello  <a href="http://google.com">google</a>.`,
+		},
+		{
+			name: "hyperlinks disabled",
+			opts: []ScreenOption{WithHyperlinks(false)},
+			want: "hi\n" + `<img alt="1.gif" src="data:image/gif;base64,AA==">` + "\nhello\n" +
+				`<img alt="foo bar" src="http://foo.com/foobar.gif">` + "\n" +
+				`<a href="http://google.com">google</a> google.`,
+		},
+		{
+			name: "both disabled",
+			opts: []ScreenOption{WithElements(false), WithHyperlinks(false)},
+			want: "hihello  google.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			got := s.AsHTML()
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("AsHTML diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCRClearsToEnd(t *testing.T) {
+	tests := []struct {
+		name, input, want, wantDisabled string
+	}{
+		{
+			name:         "shrinking spinner",
+			input:        "Downloading 50%\rDownloading 100%\rDone\nnext",
+			want:         "Done\nnext",
+			wantDisabled: "Doneloading 100%\nnext",
+		},
+		{
+			name:         "growing output",
+			input:        "Downloading 5%\rDownloading 50%\rDownloading 100%\n",
+			want:         "Downloading 100%",
+			wantDisabled: "Downloading 100%",
+		},
+		{
+			name:         "shrinks twice",
+			input:        "Downloading 100%\rDone\rOK\n",
+			want:         "OK",
+			wantDisabled: "OKneloading 100%",
+		},
+		{
+			name:         "CRLF clears nothing",
+			input:        "hello world\r\nnext",
+			want:         "hello world\nnext",
+			wantDisabled: "hello world\nnext",
+		},
+		{
+			name:         "repeated CR",
+			input:        "hello world\r\r\rbye\n",
+			want:         "bye",
+			wantDisabled: "byelo world",
+		},
+		{
+			name:         "shrinks at a second CR",
+			input:        "hello world\rbye\r\n",
+			want:         "bye",
+			wantDisabled: "byelo world",
+		},
+		{
+			name:         "CR then cursor up",
+			input:        "first line long\nsecond line long\r\x1b[Aab\n",
+			want:         "abrst line long\nsecond line long",
+			wantDisabled: "abrst line long\nsecond line long",
+		},
+		{
+			name:         "no CR",
+			input:        "hello world\n",
+			want:         "hello world",
+			wantDisabled: "hello world",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, enabled := range []bool{true, false} {
+				s, err := NewScreen(WithCRClearsToEnd(enabled))
+				if err != nil {
+					t.Fatalf("NewScreen() = %v", err)
+				}
+				s.Write([]byte(test.input))
+				want := test.want
+				if !enabled {
+					want = test.wantDisabled
+				}
+				if got := s.AsPlainText(); got != want {
+					t.Errorf("WithCRClearsToEnd(%t): AsPlainText() = %q, want %q", enabled, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderUnsupportedOSC(t *testing.T) {
+	input := "hello\x1b]52;c;c2VjcmV0\a world\x1b]7;file://host/dir\x1b\\!"
+
+	tests := []struct {
+		name string
+		opts []ScreenOption
+		want string
+	}{
+		{
+			name: "dropped by default",
+			want: "hello world!",
+		},
+		{
+			name: "reported with WithDebugOSC",
+			opts: []ScreenOption{WithDebugOSC(true)},
+			want: "hello\n*** Unsupported OSC escape sequence: 52\n world\n*** Unsupported OSC escape sequence: 7\n!",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.AsPlainText(), test.want); diff != "" {
+				t.Errorf("AsPlainText diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdateFuncSynchronizedUpdate(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var frames []string
+	s.UpdateFunc = func() { frames = append(frames, s.AsPlainText()) }
+
+	writes := []string{
+		"a",
+		"",
+		"\x1b[?2026h",
+		"\rb",
+		"c",
+		"\x1b[?2026l",
+		"\x1b[?2026hd\x1b[?2026l",
+		"\x1b[?2026he\x1b[?2026lf",
+	}
+	for _, w := range writes {
+		s.Write([]byte(w))
+	}
+
+	want := []string{"a", "bc", "bcd", "bcde", "bcdef"}
+	if diff := cmp.Diff(frames, want); diff != "" {
+		t.Errorf("frames diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestResetStyle(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b[1;31m\x1b]8;;http://example.com\x07styled"))
+	s.ResetStyle()
+	s.Write([]byte(" plain"))
+
+	want := `<a href="http://example.com"><span class="term-fg31 term-fg1">styled</span></a> plain`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+	if err := assertXY(s, 12, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithMaxColumns(t *testing.T) {
+	s, err := NewScreen(WithSize(200, 50), WithMaxColumns(10))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	if s.cols != 10 || s.lines != 50 {
+		t.Errorf("size = %dx%d, want 10x50", s.cols, s.lines)
+	}
+	if err := s.SetSize(11, 5); err == nil {
+		t.Error("SetSize(11, 5) error = nil, want error")
+	}
+	if err := s.SetSize(10, 500); err != nil {
+		t.Errorf("SetSize(10, 500) error = %v, want nil", err)
+	}
+
+	s.Write([]byte(strings.Repeat("x", 25)))
+	if got, want := s.AsPlainText(), "xxxxxxxxxx\nxxxxxxxxxx\nxxxxx"; got != want {
+		t.Errorf("AsPlainText() = %q, want %q", got, want)
+	}
+}
+
+func TestWithMaxLines(t *testing.T) {
+	s, err := NewScreen(WithSize(200, 50), WithMaxLines(10))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	if s.cols != 200 || s.lines != 10 {
+		t.Errorf("size = %dx%d, want 200x10", s.cols, s.lines)
+	}
+	if err := s.SetSize(5, 11); err == nil {
+		t.Error("SetSize(5, 11) error = nil, want error")
+	}
+	if err := s.SetSize(500, 10); err != nil {
+		t.Errorf("SetSize(500, 10) error = %v, want nil", err)
+	}
+}
+
+func TestWithMaxURLLength(t *testing.T) {
+	s, err := NewScreen(WithMaxURLLength(20))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	long := "http://example.com/" + strings.Repeat("a", 1000)
+	s.Write([]byte("\x1b]8;;http://example.com\x1b\\short\x1b]8;;\x1b\\ " +
+		"\x1b]8;;" + long + "\x1b\\long\x1b]8;;\x1b\\ " +
+		"\x1b]8;;http://example.com\x1b\\ok\x1b]8;;" + long + "\x1b\\ends link"))
+
+	want := `<a href="http://example.com">short</a> long <a href="http://example.com">ok</a>ends link`
+	if got := s.AsHTML(); got != want {
+		t.Errorf("AsHTML() = %q, want %q", got, want)
+	}
+	if s.LinksTooLong != 2 {
+		t.Errorf("LinksTooLong = %d, want 2", s.LinksTooLong)
+	}
+}
@@ -0,0 +1,101 @@
+package terminal
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottledRenderer renders a screen's visible window at most once per
+// interval, and only when the screen has changed. This is useful for live
+// viewers, where re-rendering after every Write would be wasteful.
+//
+// A ThrottledRenderer starts rendering as soon as it is created (by
+// NewThrottledRenderer), and stops when Stop is called. While it is running,
+// the screen must only be written to using the renderer's Write method, and
+// the screen's UpdateFunc must not be changed.
+type ThrottledRenderer struct {
+	mu      sync.Mutex // guards screen and changed
+	screen  *Screen
+	changed bool // the screen has changed since the last render
+
+	sink     func(html string)
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewThrottledRenderer creates a ThrottledRenderer, and starts a goroutine
+// that calls sink with the HTML of the visible window of s (see TailHTML) at
+// most once per interval, whenever s has changed. As with UpdateFunc, changes
+// made during a synchronized update are held back until the update ends. The
+// renderer calls any UpdateFunc already set on s, within Write.
+func NewThrottledRenderer(s *Screen, interval time.Duration, sink func(html string)) *ThrottledRenderer {
+	r := &ThrottledRenderer{
+		screen: s,
+		sink:   sink,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	next := s.UpdateFunc
+	s.UpdateFunc = func() {
+		r.changed = true
+		if next != nil {
+			next()
+		}
+	}
+
+	go r.run(interval)
+	return r
+}
+
+// Write writes input to the screen. It is safe to call while the renderer
+// goroutine is running, but not concurrently with other calls to Write.
+func (r *ThrottledRenderer) Write(input []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.screen.Write(input)
+}
+
+// Stop stops the renderer goroutine, waiting until it has exited. If the
+// screen changed since it was last rendered, it is rendered once more before
+// Stop returns, so that sink always sees the final state of the screen. After
+// Stop, sink is not called again, and it is safe to use the screen directly.
+// Calling Stop more than once has no further effect.
+func (r *ThrottledRenderer) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+// run renders the screen on each tick of the interval, until stopped.
+func (r *ThrottledRenderer) run(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.render()
+		case <-r.stop:
+			r.render()
+			return
+		}
+	}
+}
+
+// render calls sink with the visible window, if the screen has changed.
+func (r *ThrottledRenderer) render() {
+	r.mu.Lock()
+	if !r.changed {
+		r.mu.Unlock()
+		return
+	}
+	r.changed = false
+	html := r.screen.TailHTML(r.screen.lines)
+	r.mu.Unlock()
+
+	// Call sink without holding the lock, so a slow sink doesn't block Write.
+	r.sink(html)
+}
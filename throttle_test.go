@@ -0,0 +1,73 @@
+package terminal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestThrottledRendererCoalescesUpdates(t *testing.T) {
+	s, err := NewScreen(WithSize(10, 2))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	var got []string
+	// With a long interval, nothing is rendered until Stop.
+	r := NewThrottledRenderer(s, time.Hour, func(html string) { got = append(got, html) })
+
+	for _, w := range []string{"one\n", "two\n", "\x1b[1mthree"} {
+		r.Write([]byte(w))
+	}
+	r.Stop()
+	r.Stop()
+
+	want := []string{"two\n<span class=\"term-fg1\">three</span>"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("rendered frames diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestThrottledRendererSkipsUnchangedScreen(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	calls := 0
+	r := NewThrottledRenderer(s, time.Hour, func(string) { calls++ })
+
+	// An incomplete synchronized update doesn't count as a change.
+	r.Write([]byte("\x1b[?2026hpartial"))
+	r.Stop()
+
+	if calls != 0 {
+		t.Errorf("sink called %d times, want 0", calls)
+	}
+}
+
+func TestThrottledRendererRendersOnTick(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	updates := 0
+	s.UpdateFunc = func() { updates++ }
+
+	frames := make(chan string, 10)
+	r := NewThrottledRenderer(s, time.Millisecond, func(html string) { frames <- html })
+	defer r.Stop()
+
+	r.Write([]byte("hello"))
+	select {
+	case got := <-frames:
+		if want := "hello"; got != want {
+			t.Errorf("rendered frame = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+	}
+
+	if updates != 1 {
+		t.Errorf("existing UpdateFunc called %d times, want 1", updates)
+	}
+}
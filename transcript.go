@@ -0,0 +1,55 @@
+package terminal
+
+import "strings"
+
+// Transcript combines several screens, such as separately parsed sections of
+// a log, into a single HTML document. The screens share one wrapper and
+// stylesheet, and lines can be numbered continuously across them.
+type Transcript struct {
+	// LineIDPrefix, if not empty, gives each line an id attribute: the
+	// prefix, followed by the line's number within the transcript. Lines are
+	// numbered from 1, continuing from one screen to the next, and replace
+	// any ids the screens would otherwise give their lines (see WithLineIDs).
+	LineIDPrefix string
+
+	// Wrapper controls how the combined contents are wrapped, as in
+	// AsHTMLDocument.
+	Wrapper WrapperOptions
+
+	screens []*Screen
+}
+
+// Add appends a screen to the transcript. The screen is rendered when HTML is
+// called, so it can still be written to in the meantime.
+func (t *Transcript) Add(s *Screen) {
+	t.screens = append(t.screens, s)
+}
+
+// HTML returns the screens, in the order they were added, as a single HTML
+// document. Each screen's lines are rendered as in AsHTML, using that
+// screen's options, and screens are separated by a newline. Line number
+// gutters (see WithLineNumbers) also number lines within the transcript, from
+// 1, rather than within each screen. Lines that have scrolled out of a
+// screen's buffer are not included, and are not counted in the line
+// numbering.
+func (t *Transcript) HTML() string {
+	var b strings.Builder
+	number := 0
+	for _, s := range t.screens {
+		start := s.firstRenderedLine(true)
+		if start == len(s.screen) {
+			continue
+		}
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+
+		s.writeHTMLLines(&b, start, htmlLinesOptions{
+			renumber:   true,
+			numberBase: number + 1 - start,
+			idPrefix:   t.LineIDPrefix,
+		})
+		number += len(s.screen) - start
+	}
+	return t.Wrapper.wrap(b.String())
+}
@@ -0,0 +1,110 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscriptHTML(t *testing.T) {
+	first, err := NewScreen(WithLineIDs("first-"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	first.Write([]byte("\x1b[31mone\x1b[0m\ntwo"))
+
+	second, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	second.Write([]byte("three"))
+
+	tests := []struct {
+		name string
+		tr   Transcript
+		want string
+	}{
+		{
+			name: "without line ids",
+			want: `<pre class="term-container">` +
+				`<span id="first-1"><span class="term-fg31">one</span></span>` + "\n" +
+				`<span id="first-2">two</span>` + "\n" +
+				`three</pre>`,
+		},
+		{
+			name: "continuous line ids",
+			tr:   Transcript{LineIDPrefix: "L", Wrapper: WrapperOptions{Tag: "div"}},
+			want: `<div class="term-container">` +
+				`<span id="L1"><span class="term-fg31">one</span></span>` + "\n" +
+				`<span id="L2">two</span>` + "\n" +
+				`<span id="L3">three</span></div>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.tr.Add(first)
+			test.tr.Add(second)
+			if got := test.tr.HTML(); got != test.want {
+				t.Errorf("HTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptSharesStylesheet(t *testing.T) {
+	tr := Transcript{Wrapper: WrapperOptions{EmbedStylesheet: true}}
+	for range 3 {
+		s, err := NewScreen()
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte("hi"))
+		tr.Add(s)
+	}
+	if got := strings.Count(tr.HTML(), "<style>"); got != 1 {
+		t.Errorf("HTML() has %d <style> elements, want 1", got)
+	}
+}
+
+func TestTranscriptLineNumbers(t *testing.T) {
+	var tr Transcript
+	for _, input := range []string{"one\ntwo", "three"} {
+		s, err := NewScreen(WithLineNumbers(1))
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte(input))
+		tr.Add(s)
+	}
+	want := `<pre class="term-container">` +
+		gutterHTML("1") + "one\n" +
+		gutterHTML("2") + "two\n" +
+		gutterHTML("3") + "three</pre>"
+	if got := tr.HTML(); got != want {
+		t.Errorf("HTML() = %q, want %q", got, want)
+	}
+}
+
+func TestTranscriptScreenOptions(t *testing.T) {
+	// Each screen is rendered with its own options, as in AsHTML.
+	var tr Transcript
+	for _, opts := range [][]ScreenOption{
+		{WithSize(10, 5), WithWrapMode(WrapWord)},
+		{WithTrailingNewline(true)},
+		{WithMaxHTMLBytes(5)},
+	} {
+		s, err := NewScreen(opts...)
+		if err != nil {
+			t.Fatalf("NewScreen() = %v", err)
+		}
+		s.Write([]byte("hello world foo\nline two"))
+		tr.Add(s)
+	}
+	want := `<pre class="term-container">` +
+		"hello\nworld foo\nline two\n" +
+		"hello world foo\nline two\n" +
+		htmlTruncationNotice + "</pre>"
+	if got := tr.HTML(); got != want {
+		t.Errorf("HTML() = %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package terminal
 
 import (
 	"net/url"
+	"strings"
+	"unicode"
 )
 
 const unsafeURLSubstitution = "#"
@@ -30,3 +32,13 @@ func sanitizeURL(s string) string {
 	// default allow
 	return url.String()
 }
+
+// validURL reports if s can be parsed as a URL and contains no control
+// characters.
+func validURL(s string) bool {
+	if strings.ContainsFunc(s, unicode.IsControl) {
+		return false
+	}
+	_, err := url.Parse(s)
+	return err == nil
+}
@@ -38,3 +38,24 @@ func TestSanitizeURL(t *testing.T) {
 		})
 	}
 }
+
+func TestValidURL(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  bool
+	}{
+		{input: "https://example.org/", want: true},
+		{input: "hello.txt", want: true},
+		{input: "", want: true},
+		{input: "http://exa\x01mple.org/", want: false},
+		{input: "http://example.org/\x7f", want: false},
+		{input: "http://example.org/\u0085", want: false},
+		{input: "http://[::1", want: false},
+	}
+
+	for _, tc := range testCases {
+		if got := validURL(tc.input); got != tc.want {
+			t.Errorf("validURL(%q) = %t, want %t", tc.input, got, tc.want)
+		}
+	}
+}
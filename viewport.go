@@ -0,0 +1,105 @@
+package terminal
+
+import (
+	"slices"
+	"strings"
+)
+
+// HTMLViewport renders the screen buffer as HTML like AsHTML, but with each
+// line clipped to the colWidth columns starting at colStart (counting from 0),
+// for viewers that scroll very wide output horizontally. A wide character or
+// tab cut by either edge of the viewport is replaced by spaces in the same
+// style, so the columns stay aligned. Styles, links and tooltips are kept for
+// the part of the line that is shown, including automatic links (see
+// WithAutoLink) that are only partly shown. Like WriteHTMLWithRuns, it always
+// renders the lines as they are in the buffer (even with WrapWord), since the
+// columns are those of the buffer.
+func (s *Screen) HTMLViewport(colStart, colWidth int) string {
+	colStart = max(colStart, 0)
+	colWidth = max(colWidth, 0)
+
+	var b strings.Builder
+	s.writeHTMLLines(&b, s.firstRenderedLine(true), htmlLinesOptions{
+		clip:      true,
+		clipStart: colStart,
+		clipEnd:   colStart + colWidth,
+	})
+	return b.String()
+}
+
+// clippedLineHTML renders the line at row i clipped to the columns from start
+// up to (not including) end, for HTMLViewport.
+func (s *Screen) clippedLineHTML(i, start, end int) string {
+	// Automatic links are found in the whole line (and then kept as links
+	// in the clipped line), so that a URL cut by an edge isn't shortened.
+	opts := s.render
+	opts.autoLink = false
+
+	clipped := s.screen[i].clip(start, end, &s.render)
+	return s.wrapLineHTML(i, s.lineID(i), s.lineGutter(i), clipped.asHTML(&opts, nil))
+}
+
+// clip returns a copy of the line containing only the columns from start to
+// end (exclusive), with automatic links found using opts turned into
+// hyperlinks. The copy shares the line's elements and grapheme clusters.
+func (l *screenLine) clip(start, end int, opts *renderOptions) screenLine {
+	out := screenLine{
+		metadata:  l.metadata,
+		elements:  l.elements,
+		graphemes: l.graphemes,
+		ignored:   l.ignored,
+	}
+	end = min(end, len(l.nodes))
+	if start >= end {
+		return out
+	}
+	out.nodes = slices.Clone(l.nodes[start:end])
+
+	// Characters cut by the left edge: the continuation nodes at the start.
+	for x := 0; x < len(out.nodes) && out.nodes[x].style.cont(); x++ {
+		out.nodes[x] = out.nodes[x].placeholder()
+	}
+	// A character cut by the right edge: the one the node past the end
+	// continues.
+	if end < len(l.nodes) && l.nodes[end].style.cont() {
+		x := len(out.nodes) - 1
+		for ; x > 0 && out.nodes[x].style.cont(); x-- {
+			out.nodes[x] = out.nodes[x].placeholder()
+		}
+		out.nodes[x] = out.nodes[x].placeholder()
+	}
+
+	shift := func(m map[int]string) map[int]string {
+		if len(m) == 0 {
+			return nil
+		}
+		shifted := make(map[int]string)
+		for x := start; x < end; x++ {
+			if v, ok := m[x]; ok {
+				shifted[x-start] = v
+			}
+		}
+		return shifted
+	}
+	out.hyperlinks = shift(l.hyperlinks)
+	out.tooltips = shift(l.tooltips)
+
+	for _, al := range l.autoLinks(opts) {
+		for x := max(al.start, start); x < min(al.end, end); x++ {
+			if out.hyperlinks == nil {
+				out.hyperlinks = make(map[int]string)
+			}
+			out.nodes[x-start].style.setHyperlink(true)
+			out.hyperlinks[x-start] = al.url
+		}
+	}
+	return out
+}
+
+// placeholder returns a space in the same style as n, to stand in for part of
+// a character that can't be shown.
+func (n node) placeholder() node {
+	n.style.flags &^= sbCont | sbCluster | sbElement | sbEmpty
+	n.blob = ' '
+	return n
+}
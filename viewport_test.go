@@ -0,0 +1,113 @@
+package terminal
+
+import "testing"
+
+func TestHTMLViewport(t *testing.T) {
+	tests := []struct {
+		name, input  string
+		start, width int
+		options      []ScreenOption
+		want         string
+	}{
+		{
+			name:  "plain text",
+			input: "0123456789\nabcdefghij\nshort",
+			start: 3, width: 4,
+			want: "3456\ndefg\nrt",
+		},
+		{
+			name:  "line shorter than the offset",
+			input: "0123456789\nab",
+			start: 3, width: 4,
+			want: "3456\n&nbsp;",
+		},
+		{
+			name:  "wide character cut by the left edge",
+			input: "a漢字b",
+			start: 2, width: 4,
+			want: " 字b",
+		},
+		{
+			name:  "wide character cut by the right edge",
+			input: "a漢字b",
+			start: 0, width: 4,
+			want: "a漢",
+		},
+		{
+			name:  "wide characters cut by both edges",
+			input: "\x1b[31m漢字漢\x1b[0m",
+			start: 1, width: 4,
+			want: `<span class="term-fg31"> 字 </span>`,
+		},
+		{
+			name:  "styled run split at the edges",
+			input: "ab\x1b[1mcdef\x1b[0mgh",
+			start: 3, width: 4,
+			want: `<span class="term-fg1">def</span>g`,
+		},
+		{
+			name:  "link split at the edge",
+			input: "see \x1b]8;;http://example.com\x1b\\the docs\x1b]8;;\x1b\\ here",
+			start: 6, width: 5,
+			want: `<a href="http://example.com">e doc</a>`,
+		},
+		{
+			name:  "automatic link cut by the edge keeps the whole URL",
+			input: "go to https://example.com/page now",
+			start: 10, width: 10,
+			options: []ScreenOption{WithAutoLink(true)},
+			want:    `<a href="https://example.com/page">s:&#47;&#47;exampl</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.options...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.HTMLViewport(test.start, test.width); got != test.want {
+				t.Errorf("HTMLViewport(%d, %d) = %q, want %q", test.start, test.width, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTMLViewportWholeLine(t *testing.T) {
+	s, err := NewScreen(WithLineIDs("L"))
+	if err != nil {
+		t.Fatalf("NewScreen() = %v", err)
+	}
+	s.Write([]byte("\x1b[32mgreen\x1b[0m 漢字 \x1b]8;;http://example.com\x07link\x1b]8;;\x07\nnext"))
+	if got, want := s.HTMLViewport(0, 1000), s.AsHTML(); got != want {
+		t.Errorf("HTMLViewport(0, 1000) = %q, want AsHTML() = %q", got, want)
+	}
+}
+
+func TestHTMLViewportRenderOptions(t *testing.T) {
+	// With a viewport wider than the lines, the output is the same as AsHTML,
+	// whatever the options.
+	input := "\n\nline one\nline two\nline two\nline two\nline three"
+	tests := []struct {
+		name string
+		opt  ScreenOption
+	}{
+		{name: "trim leading blank lines", opt: WithTrimLeadingBlankLines(true)},
+		{name: "max HTML bytes", opt: WithMaxHTMLBytes(20)},
+		{name: "repeat folding", opt: WithRepeatFolding(2)},
+		{name: "trailing newline", opt: WithTrailingNewline(true)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opt)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(input))
+			if got, want := s.HTMLViewport(0, 100), s.AsHTML(); got != want {
+				t.Errorf("HTMLViewport(0, 100) = %q, want %q", got, want)
+			}
+		})
+	}
+}
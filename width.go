@@ -0,0 +1,315 @@
+package terminal
+
+import (
+	"slices"
+	"unicode"
+)
+
+// runeWidth returns the number of cells a rune occupies when written on its
+// own: 2 for wide characters (East Asian wide and fullwidth characters, and
+// emoji with default emoji presentation), and 1 for everything else.
+// Characters that extend the previous character's grapheme cluster (see
+// extendsCluster) occupy no cells of their own, and are handled separately.
+func runeWidth(r rune) int {
+	if r >= 0x1100 && unicode.Is(wideChars, r) {
+		return 2
+	}
+	return 1
+}
+
+// WithRuneWidth sets the function used to measure the number of cells a rune
+// occupies, instead of the built-in table (which follows the East Asian Width
+// property and emoji presentation). Terminals differ on the width of some
+// characters, such as those of ambiguous East Asian width and newer emoji, so
+// this allows matching a particular terminal. f should return 1 or 2; other
+// values are treated as the nearest of those. Characters that extend a
+// grapheme cluster (such as combining marks) are handled separately, and f
+// is not called for them.
+func WithRuneWidth(f func(r rune) int) ScreenOption {
+	return func(s *Screen) error {
+		s.widthFunc = f
+		return nil
+	}
+}
+
+// runeWidth returns the number of cells r occupies on the screen, using the
+// function set with WithRuneWidth if there is one.
+func (s *Screen) runeWidth(r rune) int {
+	if s.widthFunc == nil {
+		return runeWidth(r)
+	}
+	return min(max(s.widthFunc(r), 1), 2)
+}
+
+// isFormatChar reports if r is a formatting character with no width of its
+// own, such as the soft hyphen, the zero-width space, or the bidirectional
+// text controls (U+200E, U+200F, U+202A-E, U+2066-9). The zero-width joiner and
+// tag characters are formatting characters too, but are handled as part of
+// grapheme clusters (see extendsCluster).
+func isFormatChar(r rune) bool {
+	return unicode.Is(unicode.Cf, r) && !extendsCluster(r)
+}
+
+// FormatCharMode controls how formatting characters with no width of their
+// own (such as the soft hyphen and bidirectional text controls) are handled.
+// See WithFormatChars.
+type FormatCharMode int
+
+const (
+	// FormatCharsAttach keeps formatting characters in the output, attached
+	// to the character before them, so they don't occupy a cell. Formatting
+	// characters at the start of a line are dropped. This is the default.
+	FormatCharsAttach FormatCharMode = iota
+
+	// FormatCharsDrop drops formatting characters.
+	FormatCharsDrop
+)
+
+// WithFormatChars sets how formatting characters with no width of their own
+// are handled. Either way, they never occupy a cell.
+func WithFormatChars(mode FormatCharMode) ScreenOption {
+	return func(s *Screen) error {
+		s.formatChars = mode
+		return nil
+	}
+}
+
+// WideCharResizeMode controls what happens to a character occupying several
+// cells (a wide character or a preserved tab) that is cut by the new last
+// column when the window is narrowed. See WithWideCharResize.
+type WideCharResizeMode int
+
+const (
+	// WideCharResizeBlank blanks all the cells of the character. This is the
+	// default.
+	WideCharResizeBlank WideCharResizeMode = iota
+
+	// WideCharResizeShift moves the character left, so that it ends in the
+	// new last column, replacing the cells it is moved over. A character
+	// wider than the new window is blanked.
+	WideCharResizeShift
+)
+
+// WithWideCharResize sets how a multi-cell character cut by the new last
+// column is handled when the window is narrowed (see SetSize), so that the
+// lines in the window never hold part of such a character. Lines above the
+// window are left as they are.
+func WithWideCharResize(mode WideCharResizeMode) ScreenOption {
+	return func(s *Screen) error {
+		s.wideCharResize = mode
+		return nil
+	}
+}
+
+// fitWideChar fixes up a multi-cell character that starts before the column
+// cols but continues past it, because the window is being narrowed to cols
+// columns.
+func (l *screenLine) fitWideChar(cols int, mode WideCharResizeMode) {
+	if cols >= len(l.nodes) || !l.nodes[cols].style.cont() {
+		return
+	}
+	start := cols
+	for start > 0 && l.nodes[start].style.cont() {
+		start--
+	}
+	end := cols + 1
+	for end < len(l.nodes) && l.nodes[end].style.cont() {
+		end++
+	}
+	width := end - start
+	if mode != WideCharResizeShift || width > cols {
+		l.blank(start, end-1)
+		return
+	}
+
+	to := cols - width
+	l.breakMultiCell(to)
+	moved := slices.Clone(l.nodes[start:end])
+	l.blank(start, end-1)
+	copy(l.nodes[to:], moved)
+	l.hyperlinks = moveKeys(l.hyperlinks, start, to, width)
+	l.tooltips = moveKeys(l.tooltips, start, to, width)
+	l.sources = moveKeys(l.sources, start, to, width)
+}
+
+// moveKeys moves the entries of m with keys from x up to x+n to the same
+// offsets from to, for nodes moved left by fitWideChar. Entries for the cells
+// moved over are dropped.
+func moveKeys[V any](m map[int]V, x, to, n int) map[int]V {
+	if len(m) == 0 {
+		return m
+	}
+	for k := to; k < x; k++ {
+		delete(m, k)
+	}
+	for k := range n {
+		v, ok := m[x+k]
+		delete(m, x+k)
+		if ok {
+			m[to+k] = v
+		}
+	}
+	return m
+}
+
+// extendsCluster reports if r is always part of the grapheme cluster of the
+// preceding character, rather than starting a new one: combining marks,
+// variation selectors, the zero-width joiner, emoji skin tone modifiers and
+// emoji tag characters.
+func extendsCluster(r rune) bool {
+	switch {
+	case r == zeroWidthJoiner:
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF: // emoji modifiers (skin tones)
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // tags, used in subdivision flags
+		return true
+	}
+	// Mn includes the variation selectors.
+	return unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// isRegionalIndicator reports if r is one of the regional indicator
+// symbols, pairs of which make flag emoji.
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// wideChars contains the characters that occupy two cells. It is derived from
+// the Unicode East Asian Width property (W and F) and includes the regional
+// indicators, which are drawn as one wide flag when paired.
+var wideChars = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x231a, 0x231b, 1},
+		{0x2329, 0x232a, 1},
+		{0x23e9, 0x23ec, 1},
+		{0x23f0, 0x23f0, 1},
+		{0x23f3, 0x23f3, 1},
+		{0x25fd, 0x25fe, 1},
+		{0x2614, 0x2615, 1},
+		{0x2648, 0x2653, 1},
+		{0x267f, 0x267f, 1},
+		{0x2693, 0x2693, 1},
+		{0x26a1, 0x26a1, 1},
+		{0x26aa, 0x26ab, 1},
+		{0x26bd, 0x26be, 1},
+		{0x26c4, 0x26c5, 1},
+		{0x26ce, 0x26ce, 1},
+		{0x26d4, 0x26d4, 1},
+		{0x26ea, 0x26ea, 1},
+		{0x26f2, 0x26f3, 1},
+		{0x26f5, 0x26f5, 1},
+		{0x26fa, 0x26fa, 1},
+		{0x26fd, 0x26fd, 1},
+		{0x2705, 0x2705, 1},
+		{0x270a, 0x270b, 1},
+		{0x2728, 0x2728, 1},
+		{0x274c, 0x274c, 1},
+		{0x274e, 0x274e, 1},
+		{0x2753, 0x2755, 1},
+		{0x2757, 0x2757, 1},
+		{0x2795, 0x2797, 1},
+		{0x27b0, 0x27b0, 1},
+		{0x27bf, 0x27bf, 1},
+		{0x2b1b, 0x2b1c, 1},
+		{0x2b50, 0x2b50, 1},
+		{0x2b55, 0x2b55, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x16fe0, 0x16fe4, 1},
+		{0x17000, 0x18aff, 1},
+		{0x1b000, 0x1b2ff, 1},
+		{0x1f004, 0x1f004, 1},
+		{0x1f0cf, 0x1f0cf, 1},
+		{0x1f18e, 0x1f18e, 1},
+		{0x1f191, 0x1f19a, 1},
+		{0x1f1e6, 0x1f202, 1},
+		{0x1f210, 0x1f23b, 1},
+		{0x1f240, 0x1f248, 1},
+		{0x1f250, 0x1f251, 1},
+		{0x1f260, 0x1f265, 1},
+		{0x1f300, 0x1f320, 1},
+		{0x1f32d, 0x1f335, 1},
+		{0x1f337, 0x1f37c, 1},
+		{0x1f37e, 0x1f393, 1},
+		{0x1f3a0, 0x1f3ca, 1},
+		{0x1f3cf, 0x1f3d3, 1},
+		{0x1f3e0, 0x1f3f0, 1},
+		{0x1f3f4, 0x1f3f4, 1},
+		{0x1f3f8, 0x1f43e, 1},
+		{0x1f440, 0x1f440, 1},
+		{0x1f442, 0x1f4fc, 1},
+		{0x1f4ff, 0x1f53d, 1},
+		{0x1f54b, 0x1f54e, 1},
+		{0x1f550, 0x1f567, 1},
+		{0x1f57a, 0x1f57a, 1},
+		{0x1f595, 0x1f596, 1},
+		{0x1f5a4, 0x1f5a4, 1},
+		{0x1f5fb, 0x1f64f, 1},
+		{0x1f680, 0x1f6c5, 1},
+		{0x1f6cc, 0x1f6cc, 1},
+		{0x1f6d0, 0x1f6d2, 1},
+		{0x1f6d5, 0x1f6d7, 1},
+		{0x1f6dc, 0x1f6df, 1},
+		{0x1f6eb, 0x1f6ec, 1},
+		{0x1f6f4, 0x1f6fc, 1},
+		{0x1f7e0, 0x1f7eb, 1},
+		{0x1f7f0, 0x1f7f0, 1},
+		{0x1f90c, 0x1f93a, 1},
+		{0x1f93c, 0x1f945, 1},
+		{0x1f947, 0x1f9ff, 1},
+		{0x1fa70, 0x1faff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// LineWidth returns the display width, in columns, of the line at the given
+// row of the screen buffer (including any lines above the window), not
+// counting trailing blanks. Wide characters count as 2 columns, characters
+// that extend a grapheme cluster count as 0, and elements count as their
+// declared width if that was given in cells (e.g. width=3), and 1 otherwise.
+// It returns 0 if the row is out of range.
+func (s *Screen) LineWidth(row int) int {
+	if row < 0 || row >= len(s.screen) {
+		return 0
+	}
+	line := &s.screen[row]
+
+	// width is the width up to and including the current node, and end is
+	// the width up to the end of the last non-blank node.
+	width, end := 0, 0
+	blank := true
+	for _, n := range line.nodes {
+		switch {
+		case n.style.cont():
+			// Continues the preceding node, so is blank if that was.
+			width++
+		case n.style.element():
+			width += line.elements[n.blob].cells()
+			blank = false
+		default:
+			width++
+			blank = !n.style.cluster() && (n.blob == ' ' || n.blob == '\t')
+		}
+		if !blank {
+			end = width
+		}
+	}
+	return end
+}
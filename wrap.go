@@ -0,0 +1,171 @@
+package terminal
+
+import "strings"
+
+// WrapMode is how lines that wrapped at the screen width are rendered, for
+// WithWrapMode.
+type WrapMode int
+
+const (
+	// WrapHard renders lines as the terminal wrapped them: at the screen
+	// width, even if that is part way through a word. This is the default.
+	WrapHard WrapMode = iota
+
+	// WrapWord rewraps text that wrapped at the screen width so that lines
+	// break at spaces instead, where possible. A word that doesn't fit on a
+	// line by itself is still broken at the screen width.
+	WrapWord
+)
+
+// WithWrapMode sets how text that wrapped at the screen width is rendered in
+// AsHTML, WriteHTMLTo, TailHTML, AsPlainText and TailText. Terminals only
+// break lines at the screen width (WrapHard, the default), but for reading
+// exported logs, WrapWord can be easier. Only the rendering is affected: the
+// screen buffer is unchanged, and lines that the program broke itself (with
+// a newline) aren't joined. With WrapWord, rewrapping can take an extra
+// line, which has the same attributes as the last line of the text (but no
+// line id). WriteHTMLWithRuns always renders the lines as they are in the
+// buffer, since its runs refer to the cells of the buffer.
+func WithWrapMode(mode WrapMode) ScreenOption {
+	return func(s *Screen) error {
+		s.render.wrapMode = mode
+		return nil
+	}
+}
+
+// logicalEnd returns the index of the last line of the text starting at line
+// i: the last of the lines after it that continue it because the text
+// wrapped, or i if there are none.
+func (s *Screen) logicalEnd(i int) int {
+	for i+1 < len(s.screen) && s.screen[i+1].wrapped {
+		i++
+	}
+	return i
+}
+
+// wordWrapped returns the lines from i to j (inclusive), which are one line
+// of text that wrapped, joined and then wrapped at spaces (see WrapWord).
+// Automatic links are found in the joined line and turned into hyperlinks, so
+// the lines should be rendered with automatic links off (see rewrapOptions).
+func (s *Screen) wordWrapped(i, j int) []screenLine {
+	joined := s.screen[i]
+	if j > i {
+		joined = joinWrapped(s.screen[i : j+1])
+	}
+	nodes := joined.nodes
+
+	var lines []screenLine
+	start := 0
+	for len(nodes)-start > s.cols {
+		end := start + s.cols
+		next := end
+		if isSpace(nodes[end]) {
+			// The line ends at a space, which the break replaces.
+			next = end + 1
+		} else {
+			// Break at the last space on the line, if there is one, which
+			// the break also replaces.
+			for k := end - 1; k > start; k-- {
+				if isSpace(nodes[k]) {
+					end, next = k, k+1
+					break
+				}
+			}
+		}
+		// Don't break part way through a wide character or tab.
+		for end > start+1 && nodes[end].style.cont() {
+			end, next = end-1, end-1
+		}
+		lines = append(lines, joined.clip(start, end, &s.render))
+		start = next
+	}
+	return append(lines, joined.clip(start, len(nodes), &s.render))
+}
+
+// rewrapOptions returns the render options for lines from wordWrapped.
+func (s *Screen) rewrapOptions() *renderOptions {
+	opts := s.render
+	opts.autoLink = false
+	return &opts
+}
+
+// wordWrappedHTML renders the lines from i to j (inclusive), which are one
+// line of text that wrapped, rewrapped at spaces (see WrapWord). Each line is
+// wrapped in a span with the attributes of the corresponding line of the
+// buffer.
+func (s *Screen) wordWrappedHTML(i, j int, o *htmlLinesOptions) string {
+	opts := s.rewrapOptions()
+	var out []string
+	for k, line := range s.wordWrapped(i, j) {
+		// Lines beyond those of the buffer have no number of their own.
+		row, id, gutter := j, "", ""
+		if i+k <= j {
+			row = i + k
+			id, gutter = s.lineLabels(row, o)
+		} else if s.render.lineNumbers {
+			gutter = gutterHTML("")
+		}
+		out = append(out, s.wrapLineHTML(row, id, gutter, line.asHTML(opts, nil)))
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderedLines returns the lines from start onwards to render as plain text,
+// and the options to render them with: the lines of the buffer, or with
+// WrapWord, those lines rewrapped.
+func (s *Screen) renderedLines(start int) ([]screenLine, *renderOptions) {
+	if s.render.wrapMode != WrapWord {
+		return s.screen[start:], &s.render
+	}
+	var lines []screenLine
+	for i := start; i < len(s.screen); i++ {
+		j := s.logicalEnd(i)
+		lines = append(lines, s.wordWrapped(i, j)...)
+		i = j
+	}
+	return lines, s.rewrapOptions()
+}
+
+// isSpace reports if n is a space (including an empty cell).
+func isSpace(n node) bool {
+	return n.blob == ' ' && n.style.flags&(sbElement|sbCluster|sbCont) == 0
+}
+
+// joinWrapped returns the lines joined into one line, with the metadata of
+// the first. The buffer's lines are not changed.
+func joinWrapped(lines []screenLine) screenLine {
+	out := screenLine{metadata: lines[0].metadata}
+	for _, l := range lines {
+		offset := len(out.nodes)
+		for _, n := range l.nodes {
+			switch {
+			case n.style.element():
+				n.blob += rune(len(out.elements))
+			case n.style.cluster():
+				n.blob += rune(len(out.graphemes))
+			}
+			out.nodes = append(out.nodes, n)
+		}
+		out.elements = append(out.elements, l.elements...)
+		out.graphemes = append(out.graphemes, l.graphemes...)
+		out.hyperlinks = appendShifted(out.hyperlinks, l.hyperlinks, offset)
+		out.tooltips = appendShifted(out.tooltips, l.tooltips, offset)
+		out.ignored = append(out.ignored, l.ignored...)
+	}
+	return out
+}
+
+// appendShifted adds the entries of src to dst, with keys increased by
+// offset, creating dst if needed.
+func appendShifted(dst, src map[int]string, offset int) map[int]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[int]string, len(src))
+	}
+	for k, v := range src {
+		dst[k+offset] = v
+	}
+	return dst
+}
@@ -0,0 +1,114 @@
+package terminal
+
+import "testing"
+
+func TestWithWrapMode(t *testing.T) {
+	tests := []struct {
+		name, input string
+		mode        WrapMode
+		want        string
+	}{
+		{
+			name:  "hard by default",
+			input: "hello wonderful world",
+			want:  "hello wond\nerful worl\nd",
+		},
+		{
+			name:  "words",
+			input: "hello wonderful world",
+			mode:  WrapWord,
+			want:  "hello\nwonderful\nworld",
+		},
+		{
+			name:  "break at a space",
+			input: "abcdefghij klm",
+			mode:  WrapWord,
+			want:  "abcdefghij\nklm",
+		},
+		{
+			name:  "word longer than the width",
+			input: "abcdefghijklmno pq",
+			mode:  WrapWord,
+			want:  "abcdefghij\nklmno pq",
+		},
+		{
+			name:  "extra line",
+			input: "aaaa bbbbbb cccccc",
+			mode:  WrapWord,
+			want:  "aaaa\nbbbbbb\ncccccc",
+		},
+		{
+			name:  "newlines are kept",
+			input: "short\nhello wonderful world\nend",
+			mode:  WrapWord,
+			want:  "short\nhello\nwonderful\nworld\nend",
+		},
+		{
+			name:  "wide characters",
+			input: "abcdefghi漢字",
+			mode:  WrapWord,
+			want:  "abcdefghi\n漢字",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithSize(10, 10), WithWrapMode(test.mode))
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsPlainText(); got != test.want {
+				t.Errorf("AsPlainText() = %q, want %q", got, test.want)
+			}
+			if got := s.TailText(10); got != test.want {
+				t.Errorf("TailText(10) = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithWrapModeHTML(t *testing.T) {
+	tests := []struct {
+		name, input string
+		opts        []ScreenOption
+		want        string
+	}{
+		{
+			name:  "styles",
+			input: "\x1b[31mhello wonderful\x1b[0m world",
+			want:  "<span class=\"term-fg31\">hello</span>\n<span class=\"term-fg31\">wonderful</span>\nworld",
+		},
+		{
+			name:  "links",
+			input: "see \x1b]8;;http://example.com\x1b\\wonderful\x1b]8;;\x1b\\ things",
+			want:  "see\n<a href=\"http://example.com\">wonderful</a>\nthings",
+		},
+		{
+			name:  "line ids",
+			input: "aaaa bbbbbb cccccc\nend",
+			opts:  []ScreenOption{WithLineIDs("L")},
+			want:  "<span id=\"L1\">aaaa</span>\n<span id=\"L2\">bbbbbb</span>\ncccccc\n<span id=\"L3\">end</span>",
+		},
+		{
+			name:  "automatic links",
+			input: "see http://example.com/",
+			opts:  []ScreenOption{WithAutoLink(true)},
+			want:  "see\n<a href=\"http://example.com/\">http:&#47;&#47;exa</a>\n<a href=\"http://example.com/\">mple.com&#47;</a>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]ScreenOption{WithSize(10, 10), WithWrapMode(WrapWord)}, test.opts...)
+			s, err := NewScreen(opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if got := s.AsHTML(); got != test.want {
+				t.Errorf("AsHTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}